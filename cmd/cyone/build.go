@@ -0,0 +1,77 @@
+package main
+
+import (
+	"cyone/internal/bytecode"
+	"cyone/internal/listing"
+	"cyone/internal/symbols"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// buildCommand compiles a source file to an Intel HEX image, and optionally writes the listing
+// of the generated code next to the source lines and the symbol file of the image
+func buildCommand(args []string) int {
+	flags := flag.NewFlagSet("build", flag.ExitOnError)
+	filename := flags.String("file", "", "Path to the file to be compiled")
+	output := flags.String("o", "", "Path of the Intel HEX file to write instead of the standard output")
+	listingFile := flags.String("listing", "", "Path of the listing file to write")
+	symbolsFile := flags.String("symbols", "", "Path of the JSON symbol file to write")
+	code := addCodeFlags(flags)
+	flags.Parse(args)
+
+	if *filename == "" {
+		fmt.Println("Usage: cyone build -file <filename> [-o <filename>] [-listing <filename>] [-symbols <filename>]")
+		return 2
+	}
+	options, err := code.options()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error in compiler options:", err)
+		return 2
+	}
+
+	source, ok := readSource(*filename)
+	if !ok {
+		return 1
+	}
+	program, bytecodes, ok := compileSource(*filename, source, options)
+	if !ok {
+		return 1
+	}
+
+	hex := strings.Join(bytecode.GenerateIntelHex(bytecodes), "\n") + "\n"
+	if *output == "" {
+		fmt.Print(hex)
+	} else if err := os.WriteFile(*output, []byte(hex), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing file:", err)
+		return 1
+	}
+
+	if *listingFile != "" {
+		file, err := os.Create(*listingFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing file:", err)
+			return 1
+		}
+		defer file.Close()
+		if err := listing.Write(file, source, program, bytecodes); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing listing:", err)
+			return 1
+		}
+	}
+
+	if *symbolsFile != "" {
+		file, err := os.Create(*symbolsFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing file:", err)
+			return 1
+		}
+		defer file.Close()
+		if err := symbols.New(*filename, program, bytecodes).Write(file); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing symbols:", err)
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"cyone/internal/dap"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// dapCommand serves the Debug Adapter Protocol over the standard input and output, for
+// editors that debug Cyone programs
+func dapCommand(args []string) int {
+	flags := flag.NewFlagSet("dap", flag.ExitOnError)
+	code := addCodeFlags(flags)
+	flags.Parse(args)
+
+	options, err := code.options()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error in compiler options:", err)
+		return 2
+	}
+
+	if err := dap.NewServer(os.Stdin, os.Stdout, options).Serve(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error serving the debug adapter protocol:", err)
+		return 1
+	}
+	return 0
+}
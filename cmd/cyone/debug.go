@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"cyone/internal/debugger"
+	"cyone/internal/vm"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// debugHelp lists the commands of the interactive debugger
+const debugHelp = `Commands:
+  break <line>|<label>|<address>  Stop at a source line or when entering a block (b)
+  delete                          Remove every breakpoint
+  watch <variable>|mem[<address>] Stop when a memory address changes (w)
+  step                            Execute a single statement (s)
+  continue                        Run until a breakpoint, a watchpoint or the end (c)
+  print <expression>              Show the value of an expression (p)
+  backtrace                       Show the latest blocks entered by goto (bt)
+  where                           Show the current statement
+  quit                            Leave the debugger (q)`
+
+// debugCommand compiles a program and runs it under the interactive debugger, reading
+// commands from the standard input
+func debugCommand(args []string) int {
+	flags := flag.NewFlagSet("debug", flag.ExitOnError)
+	filename := flags.String("file", "", "Path to the file to be debugged")
+	maxSteps := flags.Int("max-steps", 0, "Maximum number of statements to execute, 0 for no limit")
+	code := addCodeFlags(flags)
+	flags.Parse(args)
+
+	if *filename == "" {
+		fmt.Println("Usage: cyone debug -file <filename> [-max-steps <n>]")
+		return 2
+	}
+
+	options, err := code.options()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error in compiler options:", err)
+		return 2
+	}
+
+	program, bytecodes, ok := compileFile(*filename, options)
+	if !ok {
+		return 1
+	}
+	source, err := os.ReadFile(*filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading the file:", err)
+		return 1
+	}
+
+	session, err := debugger.NewSession(program, bytecodes, vm.NewLoggingRegistry(os.Stdout, options.Kernel))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error starting the program:", err)
+		return 1
+	}
+	session.Machine().MaxSteps = *maxSteps
+
+	d := &debugREPL{session: session, source: strings.Split(string(source), "\n"), out: os.Stdout}
+	d.where()
+	d.run(os.Stdin)
+	return 0
+}
+
+// debugREPL reads debugger commands and prints their results
+type debugREPL struct {
+	session *debugger.Session
+	source  []string
+	out     io.Writer
+}
+
+// run executes commands until quit or the end of input
+func (d *debugREPL) run(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	fmt.Fprint(d.out, "(cyone) ")
+	for scanner.Scan() {
+		command, argument, _ := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		argument = strings.TrimSpace(argument)
+		switch command {
+		case "":
+		case "break", "b":
+			d.setBreakpoint(argument)
+		case "delete":
+			d.session.ClearBreakpoints()
+			fmt.Fprintln(d.out, "Deleted all breakpoints")
+		case "watch", "w":
+			address, word, err := d.session.ResolveAddress(argument)
+			if err != nil {
+				fmt.Fprintln(d.out, "Error:", err)
+				break
+			}
+			d.session.Watch(address)
+			if word {
+				d.session.Watch(address + 1)
+				fmt.Fprintf(d.out, "Watching mem[0x%04X] and mem[0x%04X]\n", address, address+1)
+			} else {
+				fmt.Fprintf(d.out, "Watching mem[0x%04X]\n", address)
+			}
+		case "step", "s":
+			d.report(d.session.Step())
+		case "continue", "c":
+			d.report(d.session.Continue())
+		case "print", "p":
+			value, err := d.session.Evaluate(argument)
+			if err != nil {
+				fmt.Fprintln(d.out, "Error:", err)
+				break
+			}
+			fmt.Fprintf(d.out, "%s = %s (%d)\n", argument, value, value.Bits)
+		case "backtrace", "bt":
+			d.backtrace()
+		case "where":
+			d.where()
+		case "help", "h":
+			fmt.Fprintln(d.out, debugHelp)
+		case "quit", "q":
+			return
+		default:
+			fmt.Fprintf(d.out, "Unknown command '%s', type 'help' for the list of commands\n", command)
+		}
+		fmt.Fprint(d.out, "(cyone) ")
+	}
+}
+
+// setBreakpoint sets a breakpoint on a decimal line number, a block label or a block address
+func (d *debugREPL) setBreakpoint(target string) {
+	if line, err := strconv.Atoi(target); err == nil {
+		line, err = d.session.BreakAtLine(line)
+		if err != nil {
+			fmt.Fprintln(d.out, "Error:", err)
+			return
+		}
+		fmt.Fprintf(d.out, "Breakpoint at line %d\n", line)
+		return
+	}
+	address, exists := d.session.BlockAddress(target)
+	if !exists {
+		parsed, err := strconv.ParseUint(target, 0, 16)
+		if err != nil {
+			fmt.Fprintf(d.out, "Error: '%s' is neither a line, a block label nor a block address\n", target)
+			return
+		}
+		address = uint16(parsed)
+	}
+	if err := d.session.BreakAtBlock(address); err != nil {
+		fmt.Fprintln(d.out, "Error:", err)
+		return
+	}
+	fmt.Fprintf(d.out, "Breakpoint at block %s\n", d.describeBlock(address))
+}
+
+// report prints why execution stopped and where
+func (d *debugREPL) report(stop debugger.Stop, err error) {
+	if err != nil {
+		fmt.Fprintln(d.out, "Error:", err)
+		return
+	}
+	switch stop.Reason {
+	case debugger.StopHalted:
+		fmt.Fprintf(d.out, "Program halted after %d statements\n", d.session.Machine().Steps)
+		return
+	case debugger.StopBreakpoint:
+		fmt.Fprintln(d.out, "Breakpoint hit")
+	case debugger.StopWatchpoint:
+		fmt.Fprintf(d.out, "Watchpoint: mem[0x%04X] changed from 0x%02X to 0x%02X\n", stop.WatchAddress, stop.OldValue, stop.NewValue)
+	}
+	d.where()
+}
+
+// where prints the next statement with its source line
+func (d *debugREPL) where() {
+	machine := d.session.Machine()
+	if machine.Halted {
+		fmt.Fprintln(d.out, "The program is not running")
+		return
+	}
+	fmt.Fprintf(d.out, "0x%04X in block %s\n", machine.PC, d.session.BlockName(machine.Block))
+	d.printSource(machine.PC)
+}
+
+// printSource prints the source line of the statement at address, if known
+func (d *debugREPL) printSource(address uint16) {
+	line, found := d.session.Location(address)
+	if !found || line.Span.Start.Line > len(d.source) {
+		return
+	}
+	number := line.Span.Start.Line
+	fmt.Fprintf(d.out, "%5d | %s\n", number, d.source[number-1])
+}
+
+// backtrace prints the latest block entries, the most recent first
+func (d *debugREPL) backtrace() {
+	for i, jump := range d.session.Backtrace() {
+		if jump.From == 0x0000 {
+			fmt.Fprintf(d.out, "#%-2d block %s entered from the start vector\n", i, d.describeBlock(jump.Block))
+			continue
+		}
+		from := fmt.Sprintf("0x%04X", jump.From)
+		if line, found := d.session.Location(jump.From); found {
+			from = line.Span.Start.String()
+		}
+		fmt.Fprintf(d.out, "#%-2d block %s entered from %s at step %d\n", i, d.describeBlock(jump.Block), from, jump.Step)
+	}
+}
+
+// describeBlock formats a block address followed by its label, if any
+func (d *debugREPL) describeBlock(address uint16) string {
+	if name := d.session.BlockName(address); name != fmt.Sprintf("0x%04X", address) {
+		return fmt.Sprintf("%s (0x%04X)", name, address)
+	}
+	return fmt.Sprintf("0x%04X", address)
+}
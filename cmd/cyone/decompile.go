@@ -0,0 +1,91 @@
+package main
+
+import (
+	"cyone/internal/bytecode"
+	"cyone/internal/compiler"
+	"cyone/internal/disasm"
+	"cyone/internal/format"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// decompileCommand reconstructs the source of an Intel HEX image
+func decompileCommand(args []string) int {
+	flags := flag.NewFlagSet("decompile", flag.ExitOnError)
+	hexFile := flags.String("hex", "", "Path to the Intel HEX file to be decompiled")
+	symbolsFile := flags.String("symbols", "", "Path to the symbol file written by the build, naming variables and blocks")
+	output := flags.String("o", "", "Path of the source file to write instead of the standard output")
+	verify := flags.Bool("verify", false, "Compile the reconstructed source and check that it yields the same image")
+	kernelFile := addKernelFlag(flags)
+	flags.Parse(args)
+
+	if *hexFile == "" {
+		fmt.Println("Usage: cyone decompile -hex <filename> [-symbols <filename>] [-o <filename>] [-verify]")
+		return 2
+	}
+
+	table, err := loadKernel(*kernelFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error in kernel description:", err)
+		return 2
+	}
+	symbols := &disasm.Symbols{}
+	if *symbolsFile != "" {
+		file, err := readSymbolFile(*symbolsFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *symbolsFile, err)
+			return 1
+		}
+		symbols = file.Disasm()
+	}
+	symbols.Kernel = table
+
+	file, err := os.Open(*hexFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading file:", err)
+		return 1
+	}
+	defer file.Close()
+	image, err := disasm.ReadIntelHex(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *hexFile, err)
+		return 1
+	}
+	program, err := disasm.Decompile(image, symbols)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *hexFile, err)
+		return 1
+	}
+	source := format.Program(program)
+
+	if *output == "" {
+		fmt.Print(source)
+	} else if err := os.WriteFile(*output, []byte(source), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing file:", err)
+		return 1
+	}
+
+	if *verify {
+		options := bytecode.DefaultOptions()
+		options.Kernel = table
+		filename := *output
+		if filename == "" {
+			filename = "<decompiled>"
+		}
+		_, bytecodes, diagnostics := compiler.Compile(filename, source, options)
+		for _, d := range diagnostics {
+			fmt.Fprintln(os.Stderr, d.String())
+		}
+		if diagnostics.HasErrors() {
+			fmt.Fprintln(os.Stderr, "Verification failed: the reconstructed source does not compile")
+			return 1
+		}
+		if err := image.Compare(disasm.DecodeBytecode(bytecodes)); err != nil {
+			fmt.Fprintln(os.Stderr, "Verification failed:", err)
+			return 1
+		}
+		fmt.Fprintln(os.Stderr, "Verified: the reconstructed source compiles to the same image")
+	}
+	return 0
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"cyone/internal/disasm"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// disasmCommand prints the annotated listing of an Intel HEX image
+func disasmCommand(args []string) int {
+	flags := flag.NewFlagSet("disasm", flag.ExitOnError)
+	hexFile := flags.String("hex", "", "Path to the Intel HEX file to be disassembled")
+	symbolsFile := flags.String("symbols", "", "Path to the symbol file written by the build, naming variables and blocks")
+	kernelFile := addKernelFlag(flags)
+	flags.Parse(args)
+
+	if *hexFile == "" {
+		fmt.Println("Usage: cyone disasm -hex <filename> [-symbols <filename>]")
+		return 2
+	}
+
+	table, err := loadKernel(*kernelFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error in kernel description:", err)
+		return 2
+	}
+	symbols := &disasm.Symbols{}
+	if *symbolsFile != "" {
+		file, err := readSymbolFile(*symbolsFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *symbolsFile, err)
+			return 1
+		}
+		symbols = file.Disasm()
+	}
+	symbols.Kernel = table
+
+	file, err := os.Open(*hexFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading file:", err)
+		return 1
+	}
+	defer file.Close()
+	image, err := disasm.ReadIntelHex(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *hexFile, err)
+		return 1
+	}
+
+	if err := image.WriteListing(os.Stdout, symbols); err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing listing:", err)
+		return 1
+	}
+	status := 0
+	for _, record := range image.Records {
+		if record.Err != nil {
+			fmt.Fprintf(os.Stderr, "%s: record at 0x%04X: %v\n", *hexFile, record.Address, record.Err)
+			status = 1
+		}
+	}
+	return status
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"cyone/internal/format"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// fmtCommand reprints source files in the canonical style. Without files, the standard input
+// is formatted to the standard output.
+func fmtCommand(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := flags.Bool("w", false, "Write the result to the source files instead of the standard output")
+	diff := flags.Bool("d", false, "Print the differences with the formatted source instead of the source")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: cyone fmt [-w] [-d] [files...]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "Error: -w requires file arguments")
+			return 2
+		}
+		source, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading the standard input:", err)
+			return 1
+		}
+		if !formatFile("<standard input>", string(source), false, *diff) {
+			return 1
+		}
+		return 0
+	}
+
+	status := 0
+	for _, filename := range flags.Args() {
+		source, err := os.ReadFile(filename)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading file:", err)
+			status = 1
+			continue
+		}
+		if !formatFile(filename, string(source), *write, *diff) {
+			status = 1
+		}
+	}
+	return status
+}
+
+// formatFile formats a source file and prints, writes or diffs the result. Reports whether the
+// file could be formatted.
+func formatFile(filename, source string, write, diff bool) bool {
+	formatted, diagnostics := format.Source(filename, source)
+	for _, d := range diagnostics {
+		fmt.Fprintln(os.Stderr, d.String())
+	}
+	if diagnostics.HasErrors() {
+		return false
+	}
+
+	if diff {
+		fmt.Print(format.Diff(filename+".orig", source, filename, formatted))
+	}
+	if write {
+		if formatted == source {
+			return true
+		}
+		info, err := os.Stat(filename)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing file:", err)
+			return false
+		}
+		if err := os.WriteFile(filename, []byte(formatted), info.Mode().Perm()); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing file:", err)
+			return false
+		}
+	}
+	if !write && !diff {
+		fmt.Print(formatted)
+	}
+	return true
+}
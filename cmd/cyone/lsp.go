@@ -0,0 +1,28 @@
+package main
+
+import (
+	"cyone/internal/lsp"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// lspCommand serves the Language Server Protocol over the standard input and output, for
+// editors that edit Cyone programs
+func lspCommand(args []string) int {
+	flags := flag.NewFlagSet("lsp", flag.ExitOnError)
+	code := addCodeFlags(flags)
+	flags.Parse(args)
+
+	options, err := code.options()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error in compiler options:", err)
+		return 2
+	}
+
+	if err := lsp.NewServer(os.Stdin, os.Stdout, options).Serve(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error serving the language server protocol:", err)
+		return 1
+	}
+	return 0
+}
@@ -2,13 +2,15 @@ package main
 
 import (
 	"bufio"
+	"cyone/internal/ast"
 	"cyone/internal/bytecode"
-	"cyone/internal/lexer"
-	"cyone/internal/parser"
-	"cyone/internal/token"
+	"cyone/internal/compiler"
+	"cyone/internal/kernel"
+	"cyone/internal/symbols"
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -29,10 +31,17 @@ var CODEBUILDREVISION string
 
 func main() {
 
+	// Subcommands have their own flags and are dispatched before the compiler flags are parsed
+	if len(os.Args) > 1 {
+		if command, exists := commands[os.Args[1]]; exists {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
+
 	// Defer a function to recover from a panic and handle errors gracefully.
 	defer func() {
 		if r := recover(); r != nil {
-			fmt.Println("an error occurred:", r)
+			fmt.Fprintln(os.Stderr, "an error occurred:", r)
 		}
 	}()
 
@@ -41,6 +50,7 @@ func main() {
 	flag.BoolVar(&info, "info", false, "Display program compilation and version information")
 	flag.BoolVar(&license, "license", false, "Display program license information")
 	filename := flag.String("file", "", "Path to the file to be parsed")
+	code := addCodeFlags(flag.CommandLine)
 	flag.Parse()
 
 	if info {
@@ -69,14 +79,115 @@ func main() {
 	// Ensure the filename argument is provided
 	if *filename == "" {
 		fmt.Println("Usage: cyone -file <filename>")
+		fmt.Println("       cyone build -file <filename> [-o <filename>] [-listing <filename>] [-symbols <filename>]")
+		fmt.Println("       cyone run -file <filename> [-max-steps <n>] [-display <width>x<height>] [-palette <colors>] [-png <filename>] [-frames <pattern>]")
+		fmt.Println("       cyone run -hex <filename> [-symbols <filename>] [-max-steps <n>] [-display <width>x<height>] [-palette <colors>] [-png <filename>] [-frames <pattern>]")
+		fmt.Println("       cyone debug -file <filename> [-max-steps <n>]")
+		fmt.Println("       cyone dap")
+		fmt.Println("       cyone lsp")
+		fmt.Println("       cyone fmt [-w] [-d] <filenames>")
+		fmt.Println("       cyone disasm -hex <filename> [-symbols <filename>]")
+		fmt.Println("       cyone decompile -hex <filename> [-symbols <filename>] [-o <filename>] [-verify]")
+		fmt.Println("Every command but fmt accepts -kernel <filename>, the JSON description of the kernel functions")
 		return
 	}
 
+	options, err := code.options()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error in compiler options:", err)
+		os.Exit(2)
+	}
+
+	_, bytecodes, ok := compileFile(*filename, options)
+	if !ok {
+		os.Exit(1)
+	}
+
+	for _, line := range bytecode.GenerateIntelHex(bytecodes) {
+		fmt.Println(line)
+	}
+
+}
+
+// commands maps the name of each subcommand to the function that runs it with the remaining
+// arguments and returns the exit status
+var commands = map[string]func(args []string) int{
+	"build":     buildCommand,
+	"run":       runCommand,
+	"debug":     debugCommand,
+	"dap":       dapCommand,
+	"lsp":       lspCommand,
+	"fmt":       fmtCommand,
+	"disasm":    disasmCommand,
+	"decompile": decompileCommand,
+}
+
+// codeFlags holds the command-line flags that configure the placement of blocks and the
+// kernel that programs are compiled for
+type codeFlags struct {
+	start, end, placement, kernel *string
+}
+
+// addCodeFlags defines the code placement and kernel flags on a flag set
+func addCodeFlags(flags *flag.FlagSet) codeFlags {
+	return codeFlags{
+		start:     flags.String("code-start", "0x0100", "First code address used to place blocks without an explicit address"),
+		end:       flags.String("code-end", "0xFFFF", "Last code address used to place blocks without an explicit address"),
+		placement: flags.String("placement", "first-fit", "Placement of blocks without an explicit address: first-fit or packed"),
+		kernel:    addKernelFlag(flags),
+	}
+}
+
+// addKernelFlag defines the flag selecting the kernel description file on a flag set
+func addKernelFlag(flags *flag.FlagSet) *string {
+	return flags.String("kernel", "", "Path to the JSON description of the kernel functions, the reference kernel by default")
+}
+
+// options converts the parsed flags to bytecode generation options
+func (f codeFlags) options() (bytecode.Options, error) {
+	options, err := parseCodeOptions(*f.start, *f.end, *f.placement)
+	if err != nil {
+		return options, err
+	}
+	options.Kernel, err = loadKernel(*f.kernel)
+	return options, err
+}
+
+// loadKernel reads a kernel description file, or returns the reference kernel when filename is
+// empty
+func loadKernel(filename string) (*kernel.Table, error) {
+	if filename == "" {
+		return kernel.Default(), nil
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	table, err := kernel.Read(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return table, nil
+}
+
+// compileFile compiles a source file to bytecode, printing its diagnostics to stderr.
+// It reports false when the file could not be read or contains errors.
+func compileFile(filename string, options bytecode.Options) (*ast.Program, []bytecode.Bytecode, bool) {
+	source, ok := readSource(filename)
+	if !ok {
+		return nil, nil, false
+	}
+	return compileSource(filename, source, options)
+}
+
+// readSource reads the content of a source file, printing the error when it cannot be read
+func readSource(filename string) (string, bool) {
 	// Open the file
-	file, err := os.Open(*filename)
+	file, err := os.Open(filename)
 	if err != nil {
-		fmt.Println("Error opening the file:", err)
-		return
+		fmt.Fprintln(os.Stderr, "Error opening the file:", err)
+		return "", false
 	}
 	defer file.Close()
 
@@ -87,45 +198,48 @@ func main() {
 		code.WriteString(scanner.Text() + "\n")
 	}
 	if err := scanner.Err(); err != nil {
-		fmt.Println("Error reading the file:", err)
-		return
+		fmt.Fprintln(os.Stderr, "Error reading the file:", err)
+		return "", false
 	}
+	return code.String(), true
+}
 
-	// Initialize the lexer
-	lex := lexer.NewLexer(code.String())
-	var tokens []token.Token
-	for {
-		tok, err := lex.NextToken()
-		if err != nil {
-			fmt.Println("Error tokenizing input:", err)
-			return
-		}
-		if tok.Type == token.EOF {
-			break
-		}
-		tokens = append(tokens, tok)
-		// fmt.Printf("{Type: %s, Literal: \"%s\"}\n", tok.Type, tok.Literal)
+// compileSource compiles source code to bytecode, printing its diagnostics to stderr.
+// It reports false when the source contains errors.
+func compileSource(filename, source string, options bytecode.Options) (*ast.Program, []bytecode.Bytecode, bool) {
+	program, bytecodes, diagnostics := compiler.Compile(filename, source, options)
+	for _, d := range diagnostics {
+		fmt.Fprintln(os.Stderr, d.String())
 	}
+	return program, bytecodes, !diagnostics.HasErrors()
+}
 
-	// Initialize the parser
-	par := parser.NewParser(tokens)
-	program, err := par.Parse()
+// readSymbolFile reads a symbol file written by the build
+func readSymbolFile(filename string) (*symbols.File, error) {
+	file, err := os.Open(filename)
 	if err != nil {
-		fmt.Println("Error parsing tokens:", err)
-		return
+		return nil, err
 	}
+	defer file.Close()
+	return symbols.Read(file)
+}
 
-	// Print the AST
-	// fmt.Println(program.String())
-
-	bytecodes, err := bytecode.GenerateBytecode(program)
+// parseCodeOptions converts the code placement command-line flags to bytecode generation options
+func parseCodeOptions(codeStart, codeEnd, placement string) (bytecode.Options, error) {
+	options := bytecode.DefaultOptions()
+	start, err := strconv.ParseUint(codeStart, 0, 16)
 	if err != nil {
-		fmt.Println("Error generating bytecode:", err)
-		return
+		return options, fmt.Errorf("invalid code start address '%s': %v", codeStart, err)
 	}
-
-	for _, line := range bytecode.GenerateIntelHex(bytecodes) {
-		fmt.Println(line)
+	end, err := strconv.ParseUint(codeEnd, 0, 16)
+	if err != nil {
+		return options, fmt.Errorf("invalid code end address '%s': %v", codeEnd, err)
 	}
-
+	if start > end {
+		return options, fmt.Errorf("code start address 0x%04X is greater than code end address 0x%04X", start, end)
+	}
+	options.CodeStart = uint16(start)
+	options.CodeEnd = uint16(end)
+	options.Placement, err = bytecode.ParsePlacement(placement)
+	return options, err
 }
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"cyone/internal/display"
+	"cyone/internal/symbols"
+	"cyone/internal/vm"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// runCommand compiles a program, or loads an Intel HEX image, and executes it on the reference
+// virtual machine, logging kernel calls and dumping data memory when execution stops
+func runCommand(args []string) int {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	filename := flags.String("file", "", "Path to the file to be executed")
+	hexFile := flags.String("hex", "", "Path to the Intel HEX image to be executed instead of a source file")
+	symbolsFile := flags.String("symbols", "", "Path to the symbol file of the image, naming variables and locating faults")
+	maxSteps := flags.Int("max-steps", 100000, "Maximum number of statements to execute, 0 for no limit")
+	displaySize := flags.String("display", fmt.Sprintf("%dx%d", display.DefaultWidth, display.DefaultHeight), "Size of the simulated display as WIDTHxHEIGHT")
+	palette := flags.String("palette", "", "Colors of the simulated display as a comma-separated list of #RRGGBB values")
+	snapshot := flags.String("png", "", "Path of a PNG snapshot of the display written when execution stops")
+	frames := flags.String("frames", "", "Path pattern of the PNG frames written each time a block is entered after drawing, such as frame-%04d.png")
+	code := addCodeFlags(flags)
+	flags.Parse(args)
+
+	if (*filename == "") == (*hexFile == "") {
+		fmt.Println("Usage: cyone run -file <filename> [-max-steps <n>] [-display <width>x<height>] [-palette <colors>] [-png <filename>] [-frames <pattern>]")
+		fmt.Println("       cyone run -hex <filename> [-symbols <filename>] [-max-steps <n>] [-display <width>x<height>] [-palette <colors>] [-png <filename>] [-frames <pattern>]")
+		return 2
+	}
+
+	options, err := code.options()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error in compiler options:", err)
+		return 2
+	}
+
+	registry := vm.NewLoggingRegistry(os.Stdout, options.Kernel)
+	machine := vm.NewMachine(registry)
+	machine.MaxSteps = *maxSteps
+
+	// Symbols locate faults in the source and name the variables of the memory dump
+	var table *symbols.File
+	if *filename != "" {
+		program, bytecodes, ok := compileFile(*filename, options)
+		if !ok {
+			return 1
+		}
+		machine.LoadBytecode(bytecodes)
+		table = symbols.New(*filename, program, bytecodes)
+	} else {
+		if err := loadHexFile(machine, *hexFile); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *hexFile, err)
+			return 1
+		}
+		if *symbolsFile != "" {
+			if table, err = readSymbolFile(*symbolsFile); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", *symbolsFile, err)
+				return 1
+			}
+		}
+	}
+
+	// The display replaces the logging stubs of the drawing functions when its output is requested
+	var screen *display.Display
+	if *frames != "" {
+		if err := checkFramePattern(*frames); err != nil {
+			fmt.Fprintln(os.Stderr, "Error in display options:", err)
+			return 2
+		}
+	}
+	if *snapshot != "" || *frames != "" {
+		screen, err = newDisplay(*displaySize, *palette)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error in display options:", err)
+			return 2
+		}
+		if err := screen.Register(registry); err != nil {
+			fmt.Fprintln(os.Stderr, "Error in display options:", err)
+			return 2
+		}
+	}
+	frame := 0
+	if *frames != "" {
+		machine.OnBlockEnter = func(m *vm.Machine) error {
+			if !screen.Dirty() {
+				return nil
+			}
+			frame++
+			return writePNG(screen, fmt.Sprintf(*frames, frame))
+		}
+	}
+
+	status := 0
+	err = machine.Start()
+	if err == nil {
+		err = machine.Run()
+	}
+	switch {
+	case errors.Is(err, vm.ErrStepLimit):
+		fmt.Fprintln(os.Stderr, "Execution stopped:", err)
+		printLocation(table, machine.PC)
+		status = 1
+	case err != nil:
+		fmt.Fprintln(os.Stderr, "Execution failed:", err)
+		var fault *vm.Fault
+		if errors.As(err, &fault) {
+			printLocation(table, fault.Address)
+		} else {
+			printLocation(table, machine.PC)
+		}
+		status = 1
+	default:
+		fmt.Printf("Halted after %d statements in block 0x%04X\n", machine.Steps, machine.Block)
+	}
+
+	if *frames != "" && screen.Dirty() {
+		frame++
+		if err := writePNG(screen, fmt.Sprintf(*frames, frame)); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing frame:", err)
+			status = 1
+		}
+	}
+	if *snapshot != "" {
+		if err := writePNG(screen, *snapshot); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing snapshot:", err)
+			status = 1
+		}
+	}
+
+	if table != nil {
+		dumpVariables(machine, table.Variables)
+	}
+	fmt.Println("Memory:")
+	machine.DumpMemory(os.Stdout)
+	return status
+}
+
+// loadHexFile loads an Intel HEX image into the code memory of a machine
+func loadHexFile(machine *vm.Machine, filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return machine.LoadIntelHex(file)
+}
+
+// printLocation prints the source location of a code address when symbols are known
+func printLocation(table *symbols.File, address uint16) {
+	if table == nil {
+		return
+	}
+	if location := table.Describe(address); location != "" {
+		fmt.Fprintf(os.Stderr, "  at %s\n", location)
+	}
+}
+
+// dumpVariables prints the value of every declared variable
+func dumpVariables(machine *vm.Machine, variables []symbols.Variable) {
+	if len(variables) == 0 {
+		return
+	}
+	fmt.Println("Variables:")
+	for _, variable := range variables {
+		address := uint16(variable.Address)
+		if variable.Type == "word" {
+			fmt.Printf("  %s (0x%04X) = 0x%02X%02X\n", variable.Name, address, machine.Memory[address], machine.Memory[address+1])
+		} else {
+			fmt.Printf("  %s (0x%04X) = 0x%02X\n", variable.Name, address, machine.Memory[address])
+		}
+	}
+}
+
+// newDisplay creates the simulated display from the size and palette flags
+func newDisplay(size, palette string) (*display.Display, error) {
+	width, height, err := display.ParseSize(size)
+	if err != nil {
+		return nil, err
+	}
+	colors := display.DefaultPalette()
+	if palette != "" {
+		colors, err = display.ParsePalette(palette)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return display.New(width, height, colors), nil
+}
+
+// checkFramePattern verifies that the -frames pattern formats a distinct file name for every
+// frame number, so that frames do not overwrite each other
+func checkFramePattern(pattern string) error {
+	first, second := fmt.Sprintf(pattern, 1), fmt.Sprintf(pattern, 2)
+	if first == second || strings.Contains(first, "%!") {
+		return fmt.Errorf("frame pattern '%s' must contain a single verb numbering the frames, such as %%04d", pattern)
+	}
+	return nil
+}
+
+// writePNG saves the framebuffer of a display to a PNG file
+func writePNG(screen *display.Display, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := screen.WritePNG(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
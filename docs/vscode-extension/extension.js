@@ -0,0 +1,33 @@
+const vscode = require('vscode');
+const { LanguageClient } = require('vscode-languageclient/node');
+
+let client;
+
+// Starts `cyone lsp` as the language server of Cyone source files and `cyone dap` as the
+// debug adapter of Cyone programs
+function activate(context) {
+  const executable = vscode.workspace.getConfiguration('cyone').get('executable', 'cyone');
+
+  client = new LanguageClient(
+    'cyone',
+    'Cyone',
+    { command: executable, args: ['lsp'] },
+    { documentSelector: [{ scheme: 'file', language: 'cyone' }] }
+  );
+  client.start();
+
+  context.subscriptions.push(
+    vscode.debug.registerDebugAdapterDescriptorFactory('cyone', {
+      createDebugAdapterDescriptor() {
+        const executable = vscode.workspace.getConfiguration('cyone').get('executable', 'cyone');
+        return new vscode.DebugAdapterExecutable(executable, ['dap']);
+      },
+    })
+  );
+}
+
+function deactivate() {
+  return client ? client.stop() : undefined;
+}
+
+module.exports = { activate, deactivate };
@@ -1,18 +1,48 @@
 package ast
 
 import (
+	"cyone/internal/token"
 	"encoding/json"
 	"fmt"
 )
 
-// Node defines the base interface for all AST nodes
-type Node interface{}
+// Node defines the base interface for all AST nodes, every node knows its source span
+type Node interface {
+	Location() token.Span
+}
+
+// Comment represents a '//' comment, the text includes the leading slashes
+type Comment struct {
+	token.Span
+	Text string //`json:"text"`
+}
+
+// Comments holds the comments attached to a declaration or a statement: those on the lines
+// before it, and the one following it on the same line. Comments found inside a statement are
+// attached before the next one.
+type Comments struct {
+	Leading  []*Comment //`json:"leading,omitempty"`
+	Trailing *Comment   //`json:"trailing,omitempty"`
+}
+
+// Attached returns the comments of a node
+func (c *Comments) Attached() *Comments {
+	return c
+}
 
-// Program represents the entire parsed program
+// Commented is implemented by the nodes that carry comments
+type Commented interface {
+	Node
+	Attached() *Comments
+}
+
+// Program represents the entire parsed program, Comments holds the comments after its last
+// declaration
 type Program struct {
 	Variables []*VariableDeclaration //`json:"variables"`
 	Start     *StartBlock            //`json:"start_block"`
 	Blocks    []*Block               //`json:"blocks"`
+	Comments  []*Comment             //`json:"comments,omitempty"`
 }
 
 func (p *Program) String() (string, error) {
@@ -23,85 +53,161 @@ func (p *Program) String() (string, error) {
 	return string(bytes), nil
 }
 
-// VariableDeclaration represents the declaration of a variable
+// VariableDeclaration represents the declaration of a variable. Type is "byte" or "word" as
+// written after the address, or empty for a byte variable declared without a type.
 type VariableDeclaration struct {
+	token.Span
+	Comments
 	Name    string //`json:"name"`
 	Address string //`json:"address"`
+	Type    string //`json:"type,omitempty"`
+}
+
+// IsWord reports whether the variable holds a 16-bit word, stored high byte first at its
+// address and the next one
+func (v *VariableDeclaration) IsWord() bool {
+	return v.Type == "word"
 }
 
-// StartBlock represents the 'start' block of the program
+// StartBlock represents the 'start' block of the program, either an address or a block label
 type StartBlock struct {
-	Address string //`json:"address"`
+	token.Span
+	Comments
+	Address string //`json:"address,omitempty"`
+	Label   string //`json:"label,omitempty"`
 }
 
-// Block represents a code block
+// Block represents a code block, optionally named so that it can be referred to by label.
+// Opening holds the comment following its opening brace on the same line, Closing holds the
+// comments between its last statement and its closing brace. The trailing comment of the then
+// block of an if statement is the one after its closing brace when an 'else' follows.
 type Block struct {
+	token.Span
+	Comments
+	Name       string      //`json:"name,omitempty"`
 	Address    string      //`json:"address,omitempty"`
+	Opening    *Comment    //`json:"opening,omitempty"`
 	Statements []Statement //`json:"statements"`
+	Closing    []*Comment  //`json:"closing,omitempty"`
 }
 
 // Statement represents a statement within a block
-type Statement interface{}
+type Statement interface {
+	Commented
+}
 
 // Assignment represents an assignment statement (e.g., x = 0x0A)
 type Assignment struct {
+	token.Span
+	Comments
 	VariableName string     //`json:"variable_name"`
 	Expression   Expression //`json:"expression"`
 }
 
-// IfStatement represents an 'if' statement with optional 'else' block
+// IfStatement represents an 'if' statement with an optional 'else' block or 'else if' chain.
+// At most one of ElseBlock and ElseIf is set.
 type IfStatement struct {
+	token.Span
+	Comments
+	ConditionExpression Expression   //`json:"condition_expression"`
+	ThenBlock           *Block       //`json:"then_block,omitempty"`
+	ElseBlock           *Block       //`json:"else_block,omitempty"`
+	ElseIf              *IfStatement //`json:"else_if,omitempty"`
+}
+
+// WhileStatement represents a 'while' loop (e.g., while (x < 0x0A) { x = x + 0x01; })
+type WhileStatement struct {
+	token.Span
+	Comments
 	ConditionExpression Expression //`json:"condition_expression"`
-	ThenBlock           *Block     //`json:"then_block,omitempty"`
-	ElseBlock           *Block     //`json:"else_block,omitempty"`
+	Body                *Block     //`json:"body"`
 }
 
 // Call represents a call statement (e.g., call fn(0x0200, 0x0200, 0x0200); )
 type Call struct {
+	token.Span
+	Comments
 	FunctionName string       //`json:"function_expression"`
 	Parameters   []Expression //`json:"parameters"`
 }
 
-// Goto represents a goto statement (e.g., goto 0x0200)
+// CallExpression represents a call to a kernel function that returns a value, used as an
+// operand (e.g., x = call READ_ADC (0x02);)
+type CallExpression struct {
+	token.Span
+	FunctionName string       //`json:"function_name"`
+	Parameters   []Expression //`json:"parameters"`
+}
+
+// Goto represents a goto statement, either to an address or to a block label (e.g., goto 0x0200, goto main)
 type Goto struct {
-	Address string //`json:"goto_address"`
+	token.Span
+	Comments
+	Address string //`json:"goto_address,omitempty"`
+	Label   string //`json:"goto_label,omitempty"`
 }
 
 // Expression represents an expression, which can be a constant or a variable
-type Expression interface{}
+type Expression interface {
+	Node
+}
 
 // ParameterValue represents a value for a parameter
-type ParameterValue interface{}
+type ParameterValue interface {
+	Node
+}
 
 // ByteValue represents a byte literal value
 type ByteValue struct {
+	token.Span
 	Value string //`json:"value"`
 }
 
 // MemoryLocation represents a location in memory (e.g., a variable or address)
 type MemoryLocation struct {
+	token.Span
 	Address string //`json:"address"`
 }
 
 // MemoryAssignment represents a direct memory operation (e.g., mem[0x0004] = resultado)
 type MemoryAssignment struct {
+	token.Span
+	Comments
 	MemoryAddress Expression //`json:"memory_address"`
 	Value         Expression //`json:"value"`
 }
 
 // BinaryExpression represents a binary expression (e.g., x + y)
 type BinaryExpression struct {
+	token.Span
 	LeftExpression  Expression //`json:"left_expression"`
 	Operator        string     //`json:"operator"`
 	RightExpression Expression //`json:"right_expression"`
 }
 
+// UnaryExpression represents a prefix operator applied to an expression (e.g., -x, !flag)
+type UnaryExpression struct {
+	token.Span
+	Operator   string     //`json:"operator"`
+	Expression Expression //`json:"expression"`
+}
+
 // Variable represents a variable expression
 type Variable struct {
+	token.Span
 	Name string //`json:"name"`
 }
 
 // Constant represents a constant value expression
 type Constant struct {
+	token.Span
 	Value string //`json:"value"`
 }
+
+// SpanOf returns the source span of a node, or an empty span if the node is nil
+func SpanOf(node Node) token.Span {
+	if node == nil {
+		return token.Span{}
+	}
+	return node.Location()
+}
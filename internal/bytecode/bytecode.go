@@ -5,13 +5,44 @@ import (
 	"strconv"
 
 	pkg_ast "cyone/internal/ast"
+	"cyone/internal/diagnostic"
+	"cyone/internal/kernel"
 	pkg_token "cyone/internal/token"
 )
 
+// Tags of the expression operands, see docs/bytecode.md
+const (
+	OperandMemory     byte = 0x00 // Byte stored in data memory
+	OperandConstant   byte = 0x01 // Byte constant
+	OperandBinary     byte = 0x02 // Binary operator applied to two operands
+	OperandUnary      byte = 0x03 // Unary operator applied to one operand
+	OperandLogical    byte = 0x04 // Logical operator with short-circuit evaluation
+	OperandWord       byte = 0x05 // Word constant
+	OperandCall       byte = 0x06 // Value returned by a kernel function
+	OperandWordMemory byte = 0x07 // Word stored in data memory, high byte first
+)
+
+// Markers that precede the branches of an if statement
+const (
+	BranchThen byte = 0x00
+	BranchElse byte = 0x01
+)
+
 type Bytecode struct {
 	Address  uint16
 	Opcode   byte
 	Operands []byte
+
+	// Block is the source block of an OP_BLOCK record, and Lines the location of each of
+	// its statements. Both are empty for the start vector.
+	Block *pkg_ast.Block
+	Lines []Line
+}
+
+// Line associates the code address of a statement with its location in the source code
+type Line struct {
+	Address uint16
+	Span    pkg_token.Span
 }
 
 type Interval struct {
@@ -54,103 +85,273 @@ func (im *IntervalManager) PrintIntervals() {
 	}
 }
 
+// generator holds the state shared by the bytecode generation functions of a program.
+// Semantic errors are collected in diagnostics so that every problem is reported at once.
+type generator struct {
+	variableAddressMap map[string]uint16
+	words              map[string]bool // Variables declared as words
+	blockAddresses     map[*pkg_ast.Block]uint16
+	labels             map[string]*pkg_ast.Block
+	blockStarts        map[uint16]*pkg_ast.Block
+	lines              []Line
+	kernel             *kernel.Table
+	diagnostics        diagnostic.List
+}
+
+// startVectorInterval is the code range occupied by the start vector record
+var startVectorInterval = Interval{Start: 0x0000, End: 0x0003}
+
+// placedBlock associates a block of the program with the code interval it occupies
+type placedBlock struct {
+	interval Interval
+	block    *pkg_ast.Block
+}
+
+// parseAddress converts a hexadecimal address literal to a 16-bit address
+func parseAddress(literal string, span pkg_token.Span, what string) (uint16, error) {
+	address, err := strconv.ParseUint(literal, 0, 16)
+	if err != nil {
+		return 0, diagnostic.Errorf(diagnostic.InvalidNumber, span, "failed to convert %s '%s' to integer: %v", what, literal, err)
+	}
+	return uint16(address), nil
+}
+
+// OperatorOpcode returns the opcode that encodes an operator written in the source code
+func OperatorOpcode(operator string, span pkg_token.Span) (byte, error) {
+	var token pkg_token.TokenType
+	if len(operator) == 1 {
+		var exists bool
+		token, exists = pkg_token.SingleCharTokens[operator[0]]
+		if !exists {
+			return 0, diagnostic.Errorf(diagnostic.InvalidOperator, span, "single character token '%s' not found in the token map", operator)
+		}
+	} else if len(operator) == 2 {
+		var exists bool
+		token, exists = pkg_token.MultiCharTokens[operator]
+		if !exists {
+			return 0, diagnostic.Errorf(diagnostic.InvalidOperator, span, "multi-character token '%s' not found in the token map", operator)
+		}
+	}
+	tokenOpcode, exists := pkg_token.TokenOpcodes[token]
+	if !exists {
+		return 0, diagnostic.Errorf(diagnostic.InvalidOperator, span, "token opcode for '%s' not found in the token opcodes map", operator)
+	}
+	return tokenOpcode, nil
+}
+
+// OperatorSymbol returns the operator written in the source code for an operator opcode, it is
+// the inverse of OperatorOpcode
+func OperatorSymbol(opcode byte) (string, bool) {
+	for symbol, token := range pkg_token.MultiCharTokens {
+		if pkg_token.TokenOpcodes[token] == opcode {
+			return symbol, true
+		}
+	}
+	for character, token := range pkg_token.SingleCharTokens {
+		if pkg_token.TokenOpcodes[token] == opcode {
+			return string(character), true
+		}
+	}
+	return "", false
+}
+
 // generateExpressionOperands generates the bytecode operands for a given expression.
-// It handles different types of expressions (variables, constants, binary expressions, memory locations, and byte values).
+// It handles different types of expressions (variables, constants, binary, logical and unary expressions, memory locations, byte values and calls).
 // Returns the generated bytecode operands and an error if any issue occurs.
-func generateExpressionOperands(expr pkg_ast.Expression, variableAddressMap map[string]uint16) ([]byte, error) {
+func (g *generator) generateExpressionOperands(expr pkg_ast.Expression) ([]byte, error) {
 	var operands []byte
 	switch expr := expr.(type) {
 	case *pkg_ast.Variable:
-		address, exists := variableAddressMap[expr.Name]
+		address, exists := g.variableAddressMap[expr.Name]
 		if !exists {
-			return nil, fmt.Errorf("variable '%s' not found in the variable address map", expr.Name)
+			return nil, diagnostic.Errorf(diagnostic.UndefinedVariable, expr.Span, "variable '%s' not found in the variable address map", expr.Name)
 		}
-		operands = append(operands, 0x00, byte((address>>8)&0xFF), byte(address&0xFF))
+		tag := OperandMemory
+		if g.words[expr.Name] {
+			tag = OperandWordMemory
+		}
+		operands = append(operands, tag, byte((address>>8)&0xFF), byte(address&0xFF))
 	case *pkg_ast.Constant:
-		operands = append(operands, 0x01)
-		value, err := strconv.ParseInt(expr.Value, 0, 8)
+		value, err := ParseConstant(expr.Value)
 		if err != nil {
-			return nil, fmt.Errorf("failed to convert constant '%s' to integer: %v", expr.Value, err)
+			return nil, diagnostic.Errorf(diagnostic.InvalidNumber, expr.Span, "%v", err)
+		}
+		if value.Word {
+			operands = append(operands, OperandWord, byte(value.Bits>>8), byte(value.Bits))
+		} else {
+			operands = append(operands, OperandConstant, byte(value.Bits))
 		}
-		operands = append(operands, uint8(value))
 	case *pkg_ast.BinaryExpression:
-		operands = append(operands, 0x02)
-		var token pkg_token.TokenType
-		if len(expr.Operator) == 1 {
-			var exists bool
-			token, exists = pkg_token.SingleCharTokens[expr.Operator[0]]
-			if !exists {
-				return nil, fmt.Errorf("single character token '%s' not found in the token map", expr.Operator)
-			}
-		} else if len(expr.Operator) == 2 {
-			var exists bool
-			token, exists = pkg_token.MultiCharTokens[expr.Operator]
-			if !exists {
-				return nil, fmt.Errorf("multi-character token '%s' not found in the token map", expr.Operator)
-			}
+		tokenOpcode, err := OperatorOpcode(expr.Operator, expr.Span)
+		if err != nil {
+			return nil, err
 		}
-		tokenOpcode, exists := pkg_token.TokenOpcodes[token]
-		if !exists {
-			return nil, fmt.Errorf("token opcode for '%s' not found in the token opcodes map", expr.Operator)
+		if tokenOpcode == pkg_token.OP_AND || tokenOpcode == pkg_token.OP_OR {
+			return g.generateShortCircuitOperands(expr, tokenOpcode)
 		}
+		if tokenOpcode == pkg_token.OP_SLASH || tokenOpcode == pkg_token.OP_MOD {
+			if divisor, ok := constantValue(expr.RightExpression); ok && divisor.Bits == 0 {
+				operation := "division"
+				if tokenOpcode == pkg_token.OP_MOD {
+					operation = "modulo"
+				}
+				return nil, diagnostic.Errorf(diagnostic.DivisionByZero, pkg_ast.SpanOf(expr.RightExpression), "%s by constant zero", operation)
+			}
+		}
+		operands = append(operands, OperandBinary)
 		operands = append(operands, tokenOpcode)
-		leftOperands, err := generateExpressionOperands(expr.LeftExpression, variableAddressMap)
+		leftOperands, err := g.generateExpressionOperands(expr.LeftExpression)
 		if err != nil {
 			return nil, err
 		}
 		operands = append(operands, leftOperands...)
-		rightOperands, err := generateExpressionOperands(expr.RightExpression, variableAddressMap)
+		rightOperands, err := g.generateExpressionOperands(expr.RightExpression)
 		if err != nil {
 			return nil, err
 		}
 		operands = append(operands, rightOperands...)
+	case *pkg_ast.UnaryExpression:
+		operands = append(operands, OperandUnary)
+		tokenOpcode, err := OperatorOpcode(expr.Operator, expr.Span)
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, tokenOpcode)
+		operandOperands, err := g.generateExpressionOperands(expr.Expression)
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, operandOperands...)
 
 	case *pkg_ast.MemoryLocation:
-		address, exists := variableAddressMap[expr.Address]
+		address, exists := g.variableAddressMap[expr.Address]
 		if !exists {
-			tempValue, err := strconv.ParseInt(expr.Address, 0, 16)
+			var err error
+			address, err = parseAddress(expr.Address, expr.Span, "memory location address")
 			if err != nil {
-				return nil, fmt.Errorf("failed to convert memory location address '%s' to integer: %v", expr.Address, err)
+				return nil, err
 			}
-			address = uint16(tempValue)
 		}
-		operands = append(operands, 0x00, byte((address>>8)&0xFF), byte(address&0xFF))
+		operands = append(operands, OperandMemory, byte((address>>8)&0xFF), byte(address&0xFF))
 
 	case *pkg_ast.ByteValue:
-		operands = append(operands, 0x01)
-		value, err := strconv.ParseInt(expr.Value, 0, 8)
+		operands = append(operands, OperandConstant)
+		value, err := strconv.ParseUint(expr.Value, 0, 8)
 		if err != nil {
-			return nil, fmt.Errorf("failed to convert byte value '%s' to integer: %v", expr.Value, err)
+			return nil, diagnostic.Errorf(diagnostic.InvalidNumber, expr.Span, "%s does not fit in a byte", expr.Value)
 		}
 		operands = append(operands, uint8(value))
+	case *pkg_ast.CallExpression:
+		function, exists := g.kernel.Lookup(expr.FunctionName)
+		if !exists {
+			return nil, diagnostic.Errorf(diagnostic.UnknownFunction, expr.Span, "function '%s' is not provided by the %s kernel", expr.FunctionName, g.kernel.Name)
+		}
+		if function.Returns == "" {
+			return nil, diagnostic.Errorf(diagnostic.NoReturnValue, expr.Span, "%s returns no value, it can only be called as a statement", function.Signature())
+		}
+		operands = append(operands, OperandCall, function.Opcode, pkg_token.OP_LPAREN)
+		operands = append(operands, g.generateParameterOperands(expr.FunctionName, expr.Parameters, expr.Span)...)
+		operands = append(operands, pkg_token.OP_RPAREN)
 	default:
-		return nil, fmt.Errorf("unexpected expression type: %T", expr)
+		return nil, diagnostic.Errorf(diagnostic.Internal, pkg_ast.SpanOf(expr), "unexpected expression type: %T", expr)
 	}
 	return operands, nil
 }
 
+// kind returns the size of the value of an expression, as it is evaluated by the kernel: a word
+// when the expression reads a word variable or a word literal, unless a comparison or a logical
+// operator turns it into a truth value, and a byte otherwise
+func (g *generator) kind(expr pkg_ast.Expression) kernel.Kind {
+	switch expr := expr.(type) {
+	case *pkg_ast.Variable:
+		if g.words[expr.Name] {
+			return kernel.Word
+		}
+	case *pkg_ast.Constant:
+		if value, err := ParseConstant(expr.Value); err == nil && value.Word {
+			return kernel.Word
+		}
+	case *pkg_ast.UnaryExpression:
+		if opcode, err := OperatorOpcode(expr.Operator, expr.Span); err == nil && !Boolean(opcode) {
+			return g.kind(expr.Expression)
+		}
+	case *pkg_ast.BinaryExpression:
+		if opcode, err := OperatorOpcode(expr.Operator, expr.Span); err == nil && !Boolean(opcode) {
+			if g.kind(expr.LeftExpression) == kernel.Word || g.kind(expr.RightExpression) == kernel.Word {
+				return kernel.Word
+			}
+		}
+	}
+	return kernel.Byte
+}
+
+// generateStoreOperands generates the operands of the value stored by an assignment to target.
+// A word target accepts any expression, a byte is zero-extended. A byte target only accepts
+// byte expressions, or a word literal whose value fits in a byte.
+func (g *generator) generateStoreOperands(expr pkg_ast.Expression, word bool, target string) []byte {
+	if word || g.kind(expr) == kernel.Byte {
+		return g.generateOperandsOrReport(expr)
+	}
+	if constant, isConstant := expr.(*pkg_ast.Constant); isConstant {
+		value, _ := ParseConstant(constant.Value)
+		if value.Bits > 0xFF {
+			g.diagnostics.Add(diagnostic.Errorf(diagnostic.InvalidNumber, constant.Span, "constant %s does not fit in a byte, %s holds a byte", constant.Value, target))
+			return nil
+		}
+		return []byte{OperandConstant, byte(value.Bits)}
+	}
+	g.diagnostics.Add(diagnostic.Errorf(diagnostic.WordToByte, pkg_ast.SpanOf(expr), "%s holds a byte, but the expression is a word", target))
+	return nil
+}
+
+// generateShortCircuitOperands generates the operands of a logical '&&' or '||' expression.
+// The length of the right operand is stored before it, so the kernel can skip it without
+// decoding when the left operand alone decides the result.
+func (g *generator) generateShortCircuitOperands(expr *pkg_ast.BinaryExpression, tokenOpcode byte) ([]byte, error) {
+	leftOperands, err := g.generateExpressionOperands(expr.LeftExpression)
+	if err != nil {
+		return nil, err
+	}
+	rightOperands, err := g.generateExpressionOperands(expr.RightExpression)
+	if err != nil {
+		return nil, err
+	}
+	if len(rightOperands) > 0xFF {
+		return nil, diagnostic.Errorf(diagnostic.ExpressionTooLong, pkg_ast.SpanOf(expr.RightExpression), "right operand of '%s' is %d bytes long, the maximum is 255", expr.Operator, len(rightOperands))
+	}
+	operands := []byte{OperandLogical, tokenOpcode}
+	operands = append(operands, leftOperands...)
+	operands = append(operands, byte(len(rightOperands)))
+	operands = append(operands, rightOperands...)
+	return operands, nil
+}
+
 // generateStatementOperands generates the bytecode operands for a given statement.
-// It handles different types of statements (assignments, if statements, goto statements, and function calls).
-// Returns the generated bytecode operands and an error if any issue occurs.
-func generateStatementOperands(stmt pkg_ast.Statement, variableAddressMap map[string]uint16) ([]byte, error) {
+// It handles different types of statements (assignments, if statements, while loops, goto statements, and function calls).
+// Errors are recorded in the generator's diagnostics and generation continues with the next
+// statement, so the returned operands are only meaningful when no error was reported.
+// The offset of the statement from the start of its block record is recorded in the line table.
+func (g *generator) generateStatementOperands(stmt pkg_ast.Statement, offset int) []byte {
+	g.lines = append(g.lines, Line{Address: uint16(offset), Span: pkg_ast.SpanOf(stmt)})
 	var operands []byte
 	switch s := stmt.(type) {
 	case *pkg_ast.Assignment:
-		address, exists := variableAddressMap[s.VariableName]
+		address, exists := g.variableAddressMap[s.VariableName]
 		if !exists {
-			return nil, fmt.Errorf("variable '%s' not found in the variable address map", s.VariableName)
+			g.diagnostics.Add(diagnostic.Errorf(diagnostic.UndefinedVariable, s.Span, "variable '%s' not found in the variable address map", s.VariableName))
 		}
 		u := []byte{
 			pkg_token.OP_IDENTIFIER,
 			byte((address >> 8) & 0xFF),
 			byte(address & 0xFF),
-			pkg_token.OP_ASSIGN,
 		}
-		exprOperands, err := generateExpressionOperands(s.Expression, variableAddressMap)
-		if err != nil {
-			return nil, err
+		// A colon before the assignment marks the store of a word
+		word := g.words[s.VariableName]
+		if word {
+			u = append(u, pkg_token.OP_COLON)
 		}
-		u = append(u, exprOperands...)
+		u = append(u, pkg_token.OP_ASSIGN)
+		u = append(u, g.generateStoreOperands(s.Expression, word, fmt.Sprintf("'%s'", s.VariableName))...)
 		u = append(u, pkg_token.OP_EOF)
 		operands = append(operands, u...)
 	case *pkg_ast.IfStatement:
@@ -158,39 +359,44 @@ func generateStatementOperands(stmt pkg_ast.Statement, variableAddressMap map[st
 			pkg_token.OP_IF,
 		}
 		u = append(u, pkg_token.OP_LPAREN)
-		conditionOperands, err := generateExpressionOperands(s.ConditionExpression, variableAddressMap)
-		if err != nil {
-			return nil, err
-		}
-		u = append(u, conditionOperands...)
+		u = append(u, g.generateOperandsOrReport(s.ConditionExpression)...)
 		u = append(u, pkg_token.OP_RPAREN)
-		u = append(u, 0x00, pkg_token.OP_LBRACE)
+		u = append(u, BranchThen, pkg_token.OP_LBRACE)
 		if s.ThenBlock != nil {
 			for _, stmt := range s.ThenBlock.Statements {
-				thenOperands, err := generateStatementOperands(stmt, variableAddressMap)
-				if err != nil {
-					return nil, err
-				}
-				u = append(u, thenOperands...)
+				u = append(u, g.generateStatementOperands(stmt, offset+len(u))...)
 			}
 		}
 		u = append(u, pkg_token.OP_RBRACE)
-		u = append(u, 0x01, pkg_token.OP_LBRACE)
+		u = append(u, BranchElse, pkg_token.OP_LBRACE)
 		if s.ElseBlock != nil {
-			for _, stmt := range s.ThenBlock.Statements {
-				elseOperands, err := generateStatementOperands(stmt, variableAddressMap)
-				if err != nil {
-					return nil, err
-				}
-				u = append(u, elseOperands...)
+			for _, stmt := range s.ElseBlock.Statements {
+				u = append(u, g.generateStatementOperands(stmt, offset+len(u))...)
+			}
+		} else if s.ElseIf != nil {
+			// An 'else if' is an 'if' nested as the only statement of the else branch
+			u = append(u, g.generateStatementOperands(s.ElseIf, offset+len(u))...)
+		}
+		u = append(u, pkg_token.OP_RBRACE)
+		operands = append(operands, u...)
+	case *pkg_ast.WhileStatement:
+		u := []byte{
+			pkg_token.OP_WHILE,
+			pkg_token.OP_LPAREN,
+		}
+		u = append(u, g.generateOperandsOrReport(s.ConditionExpression)...)
+		u = append(u, pkg_token.OP_RPAREN, pkg_token.OP_LBRACE)
+		if s.Body != nil {
+			for _, stmt := range s.Body.Statements {
+				u = append(u, g.generateStatementOperands(stmt, offset+len(u))...)
 			}
 		}
 		u = append(u, pkg_token.OP_RBRACE)
 		operands = append(operands, u...)
 	case *pkg_ast.Goto:
-		address, err := strconv.ParseInt(s.Address, 0, 16)
+		address, err := g.resolveTarget(s.Address, s.Label, s.Span, "goto address")
 		if err != nil {
-			return nil, fmt.Errorf("failed to convert goto address '%s' to integer: %v", s.Address, err)
+			g.diagnostics.AddError(err)
 		}
 		u := []byte{
 			pkg_token.OP_GOTO,
@@ -203,24 +409,26 @@ func generateStatementOperands(stmt pkg_ast.Statement, variableAddressMap map[st
 		u := []byte{
 			pkg_token.OP_CALL,
 		}
-		functionToken, exists := pkg_token.FunctionOpCodes[s.FunctionName]
-		if !exists {
-			return nil, fmt.Errorf("function '%s' not found in the function opcodes map", s.FunctionName)
+		var functionToken byte
+		if function, exists := g.kernel.Lookup(s.FunctionName); exists {
+			functionToken = function.Opcode
+		} else {
+			g.diagnostics.Add(diagnostic.Errorf(diagnostic.UnknownFunction, s.Span, "function '%s' is not provided by the %s kernel", s.FunctionName, g.kernel.Name))
 		}
 		u = append(u, functionToken, pkg_token.OP_LPAREN)
-		for _, expr := range s.Parameters {
-			paramOperands, err := generateExpressionOperands(expr, variableAddressMap)
-			if err != nil {
-				return nil, err
-			}
-			u = append(u, paramOperands...)
-		}
+		u = append(u, g.generateParameterOperands(s.FunctionName, s.Parameters, s.Span)...)
 		u = append(u, pkg_token.OP_RPAREN, pkg_token.OP_EOF)
 		operands = append(operands, u...)
 	case *pkg_ast.MemoryAssignment:
-		address, err := strconv.ParseInt(s.MemoryAddress.(*pkg_ast.Constant).Value, 0, 16)
-		if err != nil {
-			return nil, fmt.Errorf("failed to convert memory address '%s' to integer: %v", s.MemoryAddress.(*pkg_ast.Constant).Value, err)
+		var address uint16
+		if memoryAddress, ok := s.MemoryAddress.(*pkg_ast.Constant); ok {
+			var err error
+			address, err = parseAddress(memoryAddress.Value, memoryAddress.Span, "memory address")
+			if err != nil {
+				g.diagnostics.AddError(err)
+			}
+		} else {
+			g.diagnostics.Add(diagnostic.Errorf(diagnostic.InvalidMemoryAddress, pkg_ast.SpanOf(s.MemoryAddress), "memory address must be a constant"))
 		}
 		u := []byte{
 			pkg_token.OP_IDENTIFIER,
@@ -228,76 +436,227 @@ func generateStatementOperands(stmt pkg_ast.Statement, variableAddressMap map[st
 			byte(address & 0xFF),
 			pkg_token.OP_ASSIGN,
 		}
-		exprOperands, err := generateExpressionOperands(s.Value, variableAddressMap)
-		if err != nil {
-			return nil, err
-		}
-		u = append(u, exprOperands...)
+		u = append(u, g.generateStoreOperands(s.Value, false, fmt.Sprintf("mem[0x%04X]", address))...)
 		u = append(u, pkg_token.OP_EOF)
 		operands = append(operands, u...)
 	default:
-		return nil, fmt.Errorf("unexpected statement type: %T", s)
+		g.diagnostics.Add(diagnostic.Errorf(diagnostic.Internal, pkg_ast.SpanOf(stmt), "unexpected statement type: %T", s))
 	}
-	return operands, nil
+	return operands
+}
+
+// generateParameterOperands generates the operands of the parameters of a call, checking their
+// number and their kinds against the signature of the function. Byte parameters are passed by
+// value, as a constant or as the value of a variable. Address parameters are passed as the word
+// constant of an address, given directly or as a variable. Word parameters are passed by value,
+// as a constant or as the value of a word variable.
+func (g *generator) generateParameterOperands(name string, parameters []pkg_ast.Expression, span pkg_token.Span) []byte {
+	function, exists := g.kernel.Lookup(name)
+	if exists && len(parameters) != len(function.Parameters) {
+		if len(parameters) > len(function.Parameters) {
+			span = pkg_ast.SpanOf(parameters[len(function.Parameters)])
+		}
+		g.diagnostics.Add(diagnostic.Errorf(diagnostic.ParameterCount, span, "%s expects %d parameters, got %d", function.Signature(), len(function.Parameters), len(parameters)))
+	}
+
+	var operands []byte
+	for i, parameter := range parameters {
+		kind := kernel.Byte
+		if exists && i < len(function.Parameters) {
+			kind = function.Parameters[i].Kind
+		}
+		u, d := g.generateParameter(parameter, kind)
+		if d != nil {
+			if exists && i < len(function.Parameters) {
+				d.Message = fmt.Sprintf("parameter %d of %s: %s", i+1, function.Signature(), d.Message)
+			}
+			g.diagnostics.Add(d)
+		}
+		operands = append(operands, u...)
+	}
+	return operands
+}
+
+// generateParameter generates the operand of a call parameter of a given kind
+func (g *generator) generateParameter(parameter pkg_ast.Expression, kind kernel.Kind) ([]byte, *diagnostic.Diagnostic) {
+	span := pkg_ast.SpanOf(parameter)
+	switch parameter := parameter.(type) {
+	case *pkg_ast.ByteValue:
+		value, err := strconv.ParseUint(parameter.Value, 0, 16)
+		if err != nil {
+			return nil, diagnostic.Errorf(diagnostic.InvalidNumber, span, "'%s' is not a value from 0x0000 to 0xFFFF", parameter.Value)
+		}
+		if kind == kernel.Byte {
+			if value > 0xFF {
+				return nil, diagnostic.Errorf(diagnostic.ParameterKind, span, "%s does not fit in a byte", parameter.Value)
+			}
+			return []byte{OperandConstant, byte(value)}, nil
+		}
+		return []byte{OperandWord, byte(value >> 8), byte(value)}, nil
+	case *pkg_ast.MemoryLocation:
+		address, exists := g.variableAddressMap[parameter.Address]
+		if !exists {
+			value, err := strconv.ParseUint(parameter.Address, 0, 16)
+			if err != nil {
+				return nil, diagnostic.Errorf(diagnostic.UndefinedVariable, span, "variable '%s' is not declared, parameters must be declared variables or constants", parameter.Address)
+			}
+			address = uint16(value)
+		}
+		word := g.words[parameter.Address]
+		switch {
+		case kind == kernel.Address:
+			return []byte{OperandWord, byte(address >> 8), byte(address)}, nil
+		case kind == kernel.Word && word:
+			return []byte{OperandWordMemory, byte(address >> 8), byte(address)}, nil
+		case kind == kernel.Word:
+			return nil, diagnostic.Errorf(diagnostic.ParameterKind, span, "'%s' holds a byte, expected a word", parameter.Address)
+		case word:
+			return nil, diagnostic.Errorf(diagnostic.ParameterKind, span, "'%s' holds a word, expected a byte", parameter.Address)
+		}
+		return []byte{OperandMemory, byte(address >> 8), byte(address)}, nil
+	default:
+		return nil, diagnostic.Errorf(diagnostic.Internal, span, "unexpected parameter type: %T", parameter)
+	}
+}
+
+// generateOperandsOrReport generates the operands of an expression, recording any error in the diagnostics
+func (g *generator) generateOperandsOrReport(expr pkg_ast.Expression) []byte {
+	operands, err := g.generateExpressionOperands(expr)
+	if err != nil {
+		g.diagnostics.AddError(err)
+	}
+	return operands
 }
 
-// GenerateBytecode generates bytecode from a given program. It starts with a start address and processes each block in the program.
-// Returns a slice of Bytecode objects representing the bytecode for the program and an error if any issue occurs.
+// generateBlockOperands generates the operands of a block record: the length prefix followed by
+// the statements of the block enclosed in braces
+func (g *generator) generateBlockOperands(block *pkg_ast.Block) []byte {
+	// Statements follow the opcode, the length prefix and the opening brace of the record
+	const statementsOffset = 3
+	blockOperands := []byte{pkg_token.OP_LBRACE}
+	for _, stmt := range block.Statements {
+		blockOperands = append(blockOperands, g.generateStatementOperands(stmt, statementsOffset+len(blockOperands))...)
+	}
+	blockOperands = append(blockOperands, pkg_token.OP_RBRACE)
+	nOperands := int16(len(blockOperands))
+	return append([]byte{byte((nOperands >> 8) & 0xFF), byte(nOperands & 0xFF)}, blockOperands...)
+}
+
+// GenerateBytecode generates bytecode from a given program using the default options.
+// Returns a slice of Bytecode objects representing the bytecode for the program. When semantic errors are found,
+// the returned error is a diagnostic.List holding all of them; warnings are discarded.
 func GenerateBytecode(program *pkg_ast.Program) ([]Bytecode, error) {
+	bytecodes, diagnostics := GenerateBytecodeWithOptions(program, DefaultOptions())
+	return bytecodes, diagnostics.Err()
+}
+
+// GenerateBytecodeWithOptions generates bytecode from a given program. It starts with a start address and processes each block in the program.
+// Blocks declared without an address are placed automatically in the code region configured by options.
+// Returns the bytecode for the program and every diagnostic reported while generating it. The bytecode is nil
+// when the diagnostics contain errors.
+func GenerateBytecodeWithOptions(program *pkg_ast.Program, options Options) ([]Bytecode, diagnostic.List) {
 	var bytecodeList []Bytecode
+	g := &generator{
+		variableAddressMap: make(map[string]uint16, len(program.Variables)),
+		words:              make(map[string]bool),
+		kernel:             options.Kernel,
+	}
+	if g.kernel == nil {
+		g.kernel = kernel.Default()
+	}
 
+	for _, varDecl := range program.Variables {
+		address, err := parseAddress(varDecl.Address, varDecl.Span, "variable address")
+		if err != nil {
+			g.diagnostics.AddError(err)
+			continue
+		}
+		g.variableAddressMap[varDecl.Name] = address
+		if varDecl.IsWord() {
+			g.words[varDecl.Name] = true
+		}
+	}
+	g.collectSymbols(program.Blocks)
+
+	// The size of a block does not depend on the addresses it refers to, so every block is
+	// sized with a throwaway generator before the blocks without an address are placed
+	sizer := &generator{
+		variableAddressMap: g.variableAddressMap,
+		words:              g.words,
+		blockAddresses:     g.blockAddresses,
+		labels:             g.labels,
+		kernel:             g.kernel,
+	}
+	blockSizes := make(map[*pkg_ast.Block]int, len(program.Blocks))
+	for _, block := range program.Blocks {
+		blockSizes[block] = 1 + len(sizer.generateBlockOperands(block))
+	}
+	var reserved []Interval
 	if program.Start != nil {
-		startAddress, err := strconv.ParseInt(program.Start.Address, 0, 16)
+		reserved = append(reserved, startVectorInterval)
+	}
+	g.checkBlockEnds(program.Blocks, blockSizes)
+	g.placeBlocks(program.Blocks, blockSizes, reserved, options)
+	g.indexBlockStarts(program.Blocks)
+	if program.Start == nil && len(program.Blocks) > 0 {
+		g.diagnostics.Add(diagnostic.Warningf(diagnostic.MissingStart, program.Blocks[0].Span, "program declares blocks but no 'start' directive, the kernel has no entry point"))
+	}
+
+	intervalManager := NewIntervalManager()
+	if program.Start != nil {
+		startAddress, err := g.resolveTarget(program.Start.Address, program.Start.Label, program.Start.Span, "start address")
 		if err != nil {
-			return nil, fmt.Errorf("failed to convert start address '%s' to integer: %v", program.Start.Address, err)
+			g.diagnostics.AddError(err)
 		}
 		startBytecode := Bytecode{
 			Address:  0x0000,
 			Opcode:   pkg_token.OP_START,
 			Operands: []byte{byte((startAddress >> 8) & 0xFF), byte(startAddress & 0xFF), pkg_token.OP_EOF},
 		}
+		intervalManager.AddInterval(startVectorInterval.Start, startVectorInterval.End)
 		bytecodeList = append(bytecodeList, startBytecode)
 	}
-	variableAddressMap := make(map[string]uint16, len(program.Variables))
-	for _, varDecl := range program.Variables {
-		address, err := strconv.ParseInt(varDecl.Address, 0, 16)
-		if err != nil {
-			return nil, fmt.Errorf("failed to convert variable address '%s' to integer: %v", varDecl.Address, err)
-		}
-		variableAddressMap[varDecl.Name] = uint16(address)
-	}
-	intervalManager := NewIntervalManager()
+	var placedBlocks []placedBlock
 	for _, block := range program.Blocks {
-		blockOperands := []byte{pkg_token.OP_LBRACE}
-		for _, stmt := range block.Statements {
-			stmtOperands, err := generateStatementOperands(stmt, variableAddressMap)
-			if err != nil {
-				return nil, err
-			}
-			blockOperands = append(blockOperands, stmtOperands...)
+		g.lines = nil
+		blockOperands := g.generateBlockOperands(block)
+		blockAddress, placed := g.blockAddresses[block]
+		if !placed {
+			continue
 		}
-		blockOperands = append(blockOperands, pkg_token.OP_RBRACE)
-		blockAddress, err := strconv.ParseInt(block.Address, 0, 16)
-		if err != nil {
-			return nil, fmt.Errorf("failed to convert block address '%s' to integer: %v", block.Address, err)
+		for i := range g.lines {
+			g.lines[i].Address += blockAddress
 		}
-		nOperands := int16(len(blockOperands))
-		blockOperands = append([]byte{byte((nOperands >> 8) & 0xFF), byte(nOperands & 0xFF)}, blockOperands...)
 		blockBytecode := Bytecode{
-			Address:  uint16(blockAddress),
+			Address:  blockAddress,
 			Opcode:   pkg_token.OP_BLOCK,
 			Operands: blockOperands,
+			Block:    block,
+			Lines:    g.lines,
+		}
+		// checkBlockEnds left unplaced the blocks that would wrap around
+		blockAddressEnd := uint16(int(blockAddress) + len(blockBytecode.Operands))
+		if err := intervalManager.AddInterval(blockAddress, blockAddressEnd); err != nil {
+			overlap := diagnostic.Errorf(diagnostic.BlockOverlap, block.Span, "%v", err)
+			if program.Start != nil && blockAddress <= startVectorInterval.End {
+				overlap.Notef("%s: the start vector occupies 0x%04X - 0x%04X", program.Start.Start, startVectorInterval.Start, startVectorInterval.End)
+			}
+			for _, placed := range placedBlocks {
+				if !(blockAddressEnd < placed.interval.Start || blockAddress > placed.interval.End) {
+					overlap.Notef("%s: overlapping block declared here", placed.block.Start)
+				}
+			}
+			g.diagnostics.Add(overlap)
+			continue
 		}
-		blockAddressEnd := uint16(blockAddress) + uint16(len(blockBytecode.Operands))
-		if err := intervalManager.AddInterval(uint16(blockAddress), blockAddressEnd); err != nil {
-			return nil, err
-		}
+		placedBlocks = append(placedBlocks, placedBlock{interval: Interval{Start: blockAddress, End: blockAddressEnd}, block: block})
 		bytecodeList = append(bytecodeList, blockBytecode)
 	}
 
-	// intervalManager.PrintIntervals()
-
-	return bytecodeList, nil
+	if g.diagnostics.HasErrors() {
+		return nil, g.diagnostics
+	}
+	return bytecodeList, g.diagnostics
 }
 
 //////////////////////////////////////////////////////
@@ -0,0 +1,191 @@
+package bytecode
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	pkg_ast "cyone/internal/ast"
+	pkg_token "cyone/internal/token"
+)
+
+// Value is the value of an expression operand: a byte, or a 16-bit word when Word is set
+type Value struct {
+	Bits uint16
+	Word bool
+}
+
+// String formats a value as a hexadecimal literal of its size, such as 0x07 or 0x0100
+func (v Value) String() string {
+	if v.Word {
+		return fmt.Sprintf("0x%04X", v.Bits)
+	}
+	return fmt.Sprintf("0x%02X", v.Bits)
+}
+
+// unsigned is the type of the byte and word values computed by operators
+type unsigned interface {
+	~uint8 | ~uint16
+}
+
+// EvaluateBinaryValue applies a binary operator opcode to two values, following the semantics
+// documented in docs/bytecode.md. It is shared by constant folding in the compiler, the virtual
+// machine and the debugger, so they always agree. The operation is on words when either operand
+// is a word, the byte operand being zero-extended, and on bytes otherwise. Comparisons and
+// logical operators always yield a byte.
+func EvaluateBinaryValue(opcode byte, left, right Value) (Value, error) {
+	if left.Word || right.Word {
+		bits, err := evaluateBinary(opcode, left.Bits, right.Bits)
+		return Value{Bits: bits, Word: !Boolean(opcode)}, err
+	}
+	bits, err := evaluateBinary(opcode, byte(left.Bits), byte(right.Bits))
+	return Value{Bits: uint16(bits)}, err
+}
+
+// EvaluateUnaryValue applies a unary operator opcode to a value of either size. '!' always
+// yields a byte.
+func EvaluateUnaryValue(opcode byte, operand Value) (Value, error) {
+	if operand.Word {
+		bits, err := evaluateUnary(opcode, operand.Bits)
+		return Value{Bits: bits, Word: !Boolean(opcode)}, err
+	}
+	bits, err := evaluateUnary(opcode, byte(operand.Bits))
+	return Value{Bits: uint16(bits)}, err
+}
+
+// Boolean reports whether an operator opcode yields a truth value, which is a byte whatever the
+// size of its operands
+func Boolean(opcode byte) bool {
+	switch opcode {
+	case pkg_token.OP_EQ, pkg_token.OP_NOT_EQ, pkg_token.OP_GT, pkg_token.OP_LT, pkg_token.OP_GT_EQ, pkg_token.OP_LT_EQ,
+		pkg_token.OP_AND, pkg_token.OP_OR, pkg_token.OP_BANG:
+		return true
+	default:
+		return false
+	}
+}
+
+// evaluateBinary applies a binary operator opcode to two values of the same size
+func evaluateBinary[T unsigned](opcode byte, left, right T) (T, error) {
+	switch opcode {
+	case pkg_token.OP_PLUS:
+		return left + right, nil
+	case pkg_token.OP_MINUS:
+		return left - right, nil
+	case pkg_token.OP_ASTERISK:
+		return left * right, nil
+	case pkg_token.OP_SLASH:
+		if right == 0 {
+			return ^T(0), nil
+		}
+		return left / right, nil
+	case pkg_token.OP_MOD:
+		if right == 0 {
+			return left, nil
+		}
+		return left % right, nil
+	case pkg_token.OP_EQ:
+		return truth[T](left == right), nil
+	case pkg_token.OP_NOT_EQ:
+		return truth[T](left != right), nil
+	case pkg_token.OP_GT:
+		return truth[T](left > right), nil
+	case pkg_token.OP_LT:
+		return truth[T](left < right), nil
+	case pkg_token.OP_GT_EQ:
+		return truth[T](left >= right), nil
+	case pkg_token.OP_LT_EQ:
+		return truth[T](left <= right), nil
+	case pkg_token.OP_AMPERSAND:
+		return left & right, nil
+	case pkg_token.OP_PIPE:
+		return left | right, nil
+	case pkg_token.OP_CARET:
+		return left ^ right, nil
+	case pkg_token.OP_SHL:
+		return left << right, nil
+	case pkg_token.OP_SHR:
+		return left >> right, nil
+	case pkg_token.OP_AND:
+		return truth[T](left != 0 && right != 0), nil
+	case pkg_token.OP_OR:
+		return truth[T](left != 0 || right != 0), nil
+	default:
+		return 0, fmt.Errorf("unknown binary operator opcode 0x%02X", opcode)
+	}
+}
+
+// evaluateUnary applies a unary operator opcode to a value
+func evaluateUnary[T unsigned](opcode byte, operand T) (T, error) {
+	switch opcode {
+	case pkg_token.OP_MINUS:
+		return -operand, nil
+	case pkg_token.OP_BANG:
+		return truth[T](operand == 0), nil
+	case pkg_token.OP_TILDE:
+		return ^operand, nil
+	default:
+		return 0, fmt.Errorf("unknown unary operator opcode 0x%02X", opcode)
+	}
+}
+
+// truth converts a boolean to the value used for truth values
+func truth[T unsigned](b bool) T {
+	if b {
+		return 0x01
+	}
+	return 0x00
+}
+
+// ParseConstant converts a constant literal to a value. A literal written with more than two
+// hexadecimal digits, such as 0x0010, is a word, the others are bytes.
+func ParseConstant(literal string) (Value, error) {
+	value, err := strconv.ParseUint(literal, 0, 16)
+	if errors.Is(err, strconv.ErrRange) {
+		return Value{}, fmt.Errorf("constant %s does not fit in a word, the largest word is 0xFFFF", literal)
+	}
+	if err != nil {
+		return Value{}, fmt.Errorf("invalid constant '%s'", literal)
+	}
+	digits := strings.TrimPrefix(strings.ToLower(literal), "0x")
+	return Value{Bits: uint16(value), Word: len(digits) > 2 || value > 0xFF}, nil
+}
+
+// constantValue folds an expression made only of constants and operators.
+// It reports false when the expression depends on memory or cannot be folded.
+func constantValue(expr pkg_ast.Expression) (Value, bool) {
+	switch expr := expr.(type) {
+	case *pkg_ast.Constant:
+		value, err := ParseConstant(expr.Value)
+		return value, err == nil
+	case *pkg_ast.UnaryExpression:
+		operand, ok := constantValue(expr.Expression)
+		if !ok {
+			return Value{}, false
+		}
+		opcode, err := OperatorOpcode(expr.Operator, expr.Span)
+		if err != nil {
+			return Value{}, false
+		}
+		value, err := EvaluateUnaryValue(opcode, operand)
+		return value, err == nil
+	case *pkg_ast.BinaryExpression:
+		left, ok := constantValue(expr.LeftExpression)
+		if !ok {
+			return Value{}, false
+		}
+		right, ok := constantValue(expr.RightExpression)
+		if !ok {
+			return Value{}, false
+		}
+		opcode, err := OperatorOpcode(expr.Operator, expr.Span)
+		if err != nil {
+			return Value{}, false
+		}
+		value, err := EvaluateBinaryValue(opcode, left, right)
+		return value, err == nil
+	default:
+		return Value{}, false
+	}
+}
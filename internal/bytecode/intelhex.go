@@ -0,0 +1,70 @@
+package bytecode
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Intel HEX record types supported by the parser
+const (
+	recordData       byte = 0x00
+	recordEndOfFile  byte = 0x01
+	recordHeaderSize      = 4 // Byte count, address and record type
+)
+
+// Record is a data record read from an Intel HEX file
+type Record struct {
+	Address uint16
+	Data    []byte
+}
+
+// ParseIntelHex reads the data records of an Intel HEX file, verifying the checksum of every
+// line. Reading stops at the end-of-file record, which must be present.
+func ParseIntelHex(r io.Reader) ([]Record, error) {
+	var records []Record
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if !strings.HasPrefix(text, ":") {
+			return nil, fmt.Errorf("line %d: record does not start with ':'", line)
+		}
+		data, err := hex.DecodeString(text[1:])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid hexadecimal data: %v", line, err)
+		}
+		if len(data) < recordHeaderSize+1 {
+			return nil, fmt.Errorf("line %d: record is too short", line)
+		}
+		count := int(data[0])
+		if len(data) != recordHeaderSize+count+1 {
+			return nil, fmt.Errorf("line %d: byte count 0x%02X does not match the record length", line, count)
+		}
+		var sum byte
+		for _, b := range data {
+			sum += b
+		}
+		if sum != 0 {
+			checksum := data[len(data)-1]
+			return nil, fmt.Errorf("line %d: checksum mismatch: record has 0x%02X, expected 0x%02X", line, checksum, checksum-sum)
+		}
+		address := uint16(data[1])<<8 | uint16(data[2])
+		switch recordType := data[3]; recordType {
+		case recordData:
+			records = append(records, Record{Address: address, Data: data[recordHeaderSize : recordHeaderSize+count]})
+		case recordEndOfFile:
+			return records, nil
+		default:
+			return nil, fmt.Errorf("line %d: unsupported record type 0x%02X", line, recordType)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("missing end-of-file record")
+}
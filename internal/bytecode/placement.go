@@ -0,0 +1,184 @@
+package bytecode
+
+import (
+	"fmt"
+	"sort"
+
+	pkg_ast "cyone/internal/ast"
+	"cyone/internal/diagnostic"
+	"cyone/internal/kernel"
+)
+
+// Placement selects how blocks without an explicit address are placed in the code region
+type Placement int
+
+const (
+	FirstFit Placement = iota // Blocks are placed in declaration order, each one in the first gap large enough
+	Packed                    // Largest blocks are placed first, each one in the smallest gap large enough
+)
+
+func (p Placement) String() string {
+	switch p {
+	case FirstFit:
+		return "first-fit"
+	case Packed:
+		return "packed"
+	default:
+		return fmt.Sprintf("placement(%d)", int(p))
+	}
+}
+
+// ParsePlacement converts a placement name, as accepted on the command line, to a Placement
+func ParsePlacement(name string) (Placement, error) {
+	switch name {
+	case "first-fit":
+		return FirstFit, nil
+	case "packed":
+		return Packed, nil
+	default:
+		return 0, fmt.Errorf("unknown placement '%s', expected 'first-fit' or 'packed'", name)
+	}
+}
+
+// Options configures bytecode generation
+type Options struct {
+	CodeStart uint16        // First code address available for blocks without an explicit address
+	CodeEnd   uint16        // Last code address available for blocks without an explicit address
+	Placement Placement     // Strategy used to place blocks without an explicit address
+	Kernel    *kernel.Table // Functions that programs can call, the reference kernel when nil
+}
+
+// DefaultOptions returns the options used by GenerateBytecode: the code region spans from
+// 0x0100, right after the start vector area, to the end of the address space, and programs
+// call the functions of the reference kernel
+func DefaultOptions() Options {
+	return Options{
+		CodeStart: 0x0100,
+		CodeEnd:   0xFFFF,
+		Placement: FirstFit,
+		Kernel:    kernel.Default(),
+	}
+}
+
+// gap is a free range of code addresses, both ends inclusive. Ends are kept as int so that
+// a gap reaching 0xFFFF can be shrunk without overflowing.
+type gap struct {
+	start int
+	end   int
+}
+
+func (g gap) size() int {
+	return g.end - g.start + 1
+}
+
+// checkBlockEnds reports the blocks with an explicit address whose record extends past the end
+// of code memory. They are left unplaced, like blocks that do not fit in the code region.
+func (g *generator) checkBlockEnds(blocks []*pkg_ast.Block, sizes map[*pkg_ast.Block]int) {
+	for _, block := range blocks {
+		address, placed := g.blockAddresses[block]
+		if !placed {
+			continue
+		}
+		if end := int(address) + sizes[block] - 1; end > 0xFFFF {
+			g.diagnostics.Add(diagnostic.Errorf(diagnostic.CodeRegionOverflow, block.Span, "block at 0x%04X (%d bytes) extends past 0xFFFF, the end of code memory", address, sizes[block]).
+				Notef("the block would end at 0x%X", end))
+			delete(g.blockAddresses, block)
+		}
+	}
+}
+
+// placeBlocks assigns an address to every block that was declared without one. Blocks with an
+// explicit address are fixed placements: the free gaps of the code region are what remains
+// once they, and the start vector, have been carved out. sizes holds the size in bytes of the
+// record of every block, opcode included.
+func (g *generator) placeBlocks(blocks []*pkg_ast.Block, sizes map[*pkg_ast.Block]int, reserved []Interval, options Options) {
+	var pending []*pkg_ast.Block
+	for _, block := range blocks {
+		if block.Address == "" {
+			pending = append(pending, block)
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	occupied := append([]Interval(nil), reserved...)
+	for _, block := range blocks {
+		if address, placed := g.blockAddresses[block]; placed {
+			end := int(address) + sizes[block] - 1
+			occupied = append(occupied, Interval{Start: address, End: uint16(min(end, 0xFFFF))})
+		}
+	}
+	gaps := freeGaps(int(options.CodeStart), int(options.CodeEnd), occupied)
+
+	if options.Placement == Packed {
+		sort.SliceStable(pending, func(i, j int) bool {
+			return sizes[pending[i]] > sizes[pending[j]]
+		})
+	}
+
+	for _, block := range pending {
+		size := sizes[block]
+		chosen := -1
+		for i, free := range gaps {
+			if free.size() < size {
+				continue
+			}
+			if chosen == -1 {
+				chosen = i
+				if options.Placement == FirstFit {
+					break
+				}
+			} else if free.size() < gaps[chosen].size() {
+				chosen = i
+			}
+		}
+		if chosen == -1 {
+			overflow := diagnostic.Errorf(diagnostic.CodeRegionOverflow, block.Span, "block '%s' (%d bytes) does not fit in the code region 0x%04X - 0x%04X", block.Name, size, options.CodeStart, options.CodeEnd)
+			largest := 0
+			for _, free := range gaps {
+				if free.size() > largest {
+					largest = free.size()
+				}
+			}
+			overflow.Notef("the largest free range left in the code region is %d bytes", largest)
+			g.diagnostics.Add(overflow)
+			continue
+		}
+		g.blockAddresses[block] = uint16(gaps[chosen].start)
+		gaps[chosen].start += size
+		if gaps[chosen].size() <= 0 {
+			gaps = append(gaps[:chosen], gaps[chosen+1:]...)
+		}
+	}
+}
+
+// freeGaps returns the ranges of [start, end] not covered by any of the occupied intervals, in address order
+func freeGaps(start, end int, occupied []Interval) []gap {
+	sort.Slice(occupied, func(i, j int) bool {
+		return occupied[i].Start < occupied[j].Start
+	})
+	var gaps []gap
+	next := start
+	for _, interval := range occupied {
+		if int(interval.Start) > next {
+			gaps = append(gaps, gap{start: next, end: min(int(interval.Start)-1, end)})
+		}
+		if int(interval.End)+1 > next {
+			next = int(interval.End) + 1
+		}
+		if next > end {
+			break
+		}
+	}
+	if next <= end {
+		gaps = append(gaps, gap{start: next, end: end})
+	}
+	var valid []gap
+	for _, free := range gaps {
+		if free.size() > 0 {
+			valid = append(valid, free)
+		}
+	}
+	return valid
+}
@@ -0,0 +1,178 @@
+package bytecode
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"cyone/internal/diagnostic"
+	"cyone/internal/lexer"
+	"cyone/internal/parser"
+)
+
+// generate parses a source and generates its bytecode with options
+func generate(t *testing.T, source string, options Options) ([]Bytecode, diagnostic.List) {
+	t.Helper()
+	tokens, diagnostics := lexer.NewFileLexer("test.cyo", source).Tokenize()
+	p := parser.NewParser(tokens)
+	program, _ := p.Parse()
+	diagnostics.Add(p.Diagnostics()...)
+	if diagnostics.HasErrors() {
+		t.Fatalf("source does not parse: %v", diagnostics)
+	}
+	return GenerateBytecodeWithOptions(program, options)
+}
+
+// block returns a block declaration holding n assignments, whose record takes 5 + 7n bytes
+func block(declaration string, n int) string {
+	return declaration + " {" + strings.Repeat(" x = 0x01;", n) + " }\n"
+}
+
+// addresses returns the address of every block record by block name, or by address for
+// blocks without a name
+func addresses(bytecodes []Bytecode) map[string]uint16 {
+	result := make(map[string]uint16)
+	for _, bc := range bytecodes {
+		if bc.Block != nil {
+			name := bc.Block.Name
+			if name == "" {
+				name = bc.Block.Address
+			}
+			result[name] = bc.Address
+		}
+	}
+	return result
+}
+
+func TestPlaceBlocks(t *testing.T) {
+	// The fixed block leaves a gap of 30 bytes before it and one of 13 bytes after it. With
+	// first-fit, the small block takes the first gap and the large one no longer fits anywhere;
+	// packed placement puts the large block first, in the smallest gap that holds it.
+	gaps := "loc x at 0x0000;\n" +
+		block("block small", 1) +
+		block("block large", 3) +
+		block("block 0x011E", 1)
+	region := Options{CodeStart: 0x0100, CodeEnd: 0x0136}
+
+	tests := []struct {
+		name      string
+		source    string
+		options   Options
+		expected  map[string]uint16
+		overflows []string
+	}{
+		{
+			name:     "first-fit in declaration order",
+			source:   "loc x at 0x0000;\nstart at a;\n" + block("block a", 1) + block("block b", 2) + block("block c", 1),
+			options:  Options{CodeStart: 0x0100, CodeEnd: 0xFFFF, Placement: FirstFit},
+			expected: map[string]uint16{"a": 0x0100, "b": 0x010C, "c": 0x011F},
+		},
+		{
+			name: "first-fit around fixed blocks",
+			source: "loc x at 0x0000;\n" + block("block a", 2) + block("block 0x0108", 1) +
+				block("block b", 0) + block("block c", 1),
+			options:  Options{CodeStart: 0x0100, CodeEnd: 0xFFFF, Placement: FirstFit},
+			expected: map[string]uint16{"0x0108": 0x0108, "b": 0x0100, "a": 0x0114, "c": 0x0127},
+		},
+		{
+			name:      "first-fit runs out of room",
+			source:    gaps,
+			options:   Options{CodeStart: region.CodeStart, CodeEnd: region.CodeEnd, Placement: FirstFit},
+			overflows: []string{"large"},
+		},
+		{
+			name:     "packed places the largest blocks first in the smallest gap",
+			source:   gaps,
+			options:  Options{CodeStart: region.CodeStart, CodeEnd: region.CodeEnd, Placement: Packed},
+			expected: map[string]uint16{"large": 0x0100, "small": 0x012A, "0x011E": 0x011E},
+		},
+		{
+			name:     "start vector is reserved",
+			source:   "loc x at 0x0000;\nstart at a;\n" + block("block a", 1),
+			options:  Options{CodeStart: 0x0000, CodeEnd: 0x00FF, Placement: FirstFit},
+			expected: map[string]uint16{"a": 0x0004},
+		},
+		{
+			name:      "block larger than the region",
+			source:    "loc x at 0x0000;\n" + block("block a", 3),
+			options:   Options{CodeStart: 0x0100, CodeEnd: 0x010F, Placement: Packed},
+			overflows: []string{"a"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bytecodes, diagnostics := generate(t, test.source, test.options)
+			var overflows []string
+			for _, d := range diagnostics {
+				if d.Severity != diagnostic.Error {
+					continue
+				}
+				if d.Code != diagnostic.CodeRegionOverflow {
+					t.Fatalf("unexpected diagnostic %s", d)
+				}
+				overflows = append(overflows, strings.Split(d.Message, "'")[1])
+			}
+			if fmt.Sprint(overflows) != fmt.Sprint(test.overflows) {
+				t.Fatalf("blocks %v overflow, expected %v", overflows, test.overflows)
+			}
+			if test.overflows != nil {
+				return
+			}
+			if got := addresses(bytecodes); fmt.Sprint(got) != fmt.Sprint(test.expected) {
+				t.Errorf("blocks placed at %v, expected %v", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestBlockPastEndOfMemory(t *testing.T) {
+	tests := []struct {
+		address string
+		fits    bool
+	}{
+		{"0xFFF4", true},
+		{"0xFFF5", false},
+		{"0xFFF8", false},
+	}
+	for _, test := range tests {
+		t.Run(test.address, func(t *testing.T) {
+			_, diagnostics := generate(t, "loc x at 0x0000;\n"+block("block "+test.address, 1), DefaultOptions())
+			errors := 0
+			for _, d := range diagnostics {
+				if d.Severity == diagnostic.Error {
+					errors++
+					if d.Code != diagnostic.CodeRegionOverflow || !strings.Contains(d.Message, "extends past 0xFFFF") {
+						t.Errorf("unexpected diagnostic %s", d)
+					}
+				}
+			}
+			if fits := errors == 0; fits != test.fits {
+				t.Errorf("block fits: %v, expected %v", fits, test.fits)
+			}
+		})
+	}
+}
+
+func TestParsePlacement(t *testing.T) {
+	for _, placement := range []Placement{FirstFit, Packed} {
+		parsed, err := ParsePlacement(placement.String())
+		if err != nil || parsed != placement {
+			t.Errorf("%s parses as %s, %v", placement, parsed, err)
+		}
+	}
+	if _, err := ParsePlacement("best-fit"); err == nil {
+		t.Errorf("unknown placement accepted")
+	}
+}
+
+// TestBlockSize checks the size of the records produced by the block helper, which the
+// placement tests rely on
+func TestBlockSize(t *testing.T) {
+	bytecodes, diagnostics := generate(t, "loc x at 0x0000;\n"+block("block 0x0100", 2), DefaultOptions())
+	if diagnostics.HasErrors() || len(bytecodes) != 1 {
+		t.Fatalf("got %d records: %v", len(bytecodes), diagnostics)
+	}
+	if size := 1 + len(bytecodes[0].Operands); size != 5+7*2 {
+		t.Errorf("block record takes %d bytes, expected %d", size, 5+7*2)
+	}
+}
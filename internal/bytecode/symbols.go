@@ -0,0 +1,108 @@
+package bytecode
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	pkg_ast "cyone/internal/ast"
+	"cyone/internal/diagnostic"
+	pkg_token "cyone/internal/token"
+)
+
+// collectSymbols is the symbol pass run before generating statements. It records the address
+// of every block declared with an explicit address and the block declared for every label,
+// reporting duplicated labels and invalid addresses.
+func (g *generator) collectSymbols(blocks []*pkg_ast.Block) {
+	g.blockAddresses = make(map[*pkg_ast.Block]uint16, len(blocks))
+	g.labels = make(map[string]*pkg_ast.Block)
+	for _, block := range blocks {
+		if block.Name != "" {
+			if previous, exists := g.labels[block.Name]; exists {
+				g.diagnostics.Add(diagnostic.Errorf(diagnostic.DuplicateLabel, block.Span, "label '%s' is already declared", block.Name).
+					Notef("%s: previous declaration of '%s'", previous.Start, block.Name))
+			} else {
+				g.labels[block.Name] = block
+			}
+		}
+
+		if block.Address == "" {
+			// Placed automatically once the size of every block is known
+			continue
+		}
+		address, err := parseAddress(block.Address, block.Span, "block address")
+		if err != nil {
+			g.diagnostics.AddError(err)
+			continue
+		}
+		g.blockAddresses[block] = address
+	}
+}
+
+// resolveTarget returns the code address of a 'goto' or 'start' target, given either as an
+// address literal or as a block label. An address literal must be the start of a block.
+func (g *generator) resolveTarget(address, label string, span pkg_token.Span, what string) (uint16, error) {
+	if label == "" {
+		target, err := parseAddress(address, span, what)
+		if err != nil {
+			return 0, err
+		}
+		if _, exists := g.blockStarts[target]; !exists {
+			return target, g.invalidTarget(target, span, what)
+		}
+		return target, nil
+	}
+	block, exists := g.labels[label]
+	if !exists {
+		return 0, diagnostic.Errorf(diagnostic.UndefinedLabel, span, "label '%s' is not declared by any block", label)
+	}
+	blockAddress, placed := g.blockAddresses[block]
+	if !placed {
+		// The block itself has already been reported
+		return 0, nil
+	}
+	return blockAddress, nil
+}
+
+// indexBlockStarts records which block starts at each placed address, for target validation.
+// When blocks share an address, the first one declared is kept.
+func (g *generator) indexBlockStarts(blocks []*pkg_ast.Block) {
+	g.blockStarts = make(map[uint16]*pkg_ast.Block, len(g.blockAddresses))
+	for _, block := range blocks {
+		address, placed := g.blockAddresses[block]
+		if _, exists := g.blockStarts[address]; placed && !exists {
+			g.blockStarts[address] = block
+		}
+	}
+}
+
+// invalidTarget reports a 'goto' or 'start' address that is not the start of any block,
+// listing the nearest block addresses below and above it
+func (g *generator) invalidTarget(target uint16, span pkg_token.Span, what string) *diagnostic.Diagnostic {
+	invalid := diagnostic.Errorf(diagnostic.InvalidTarget, span, "%s 0x%04X is not the start of any block", what, target)
+	addresses := make([]int, 0, len(g.blockStarts))
+	for address := range g.blockStarts {
+		addresses = append(addresses, int(address))
+	}
+	if len(addresses) == 0 {
+		return invalid.Notef("the program does not declare any block")
+	}
+	sort.Ints(addresses)
+	index := sort.SearchInts(addresses, int(target))
+	var nearest []string
+	if index > 0 {
+		nearest = append(nearest, g.describeBlockStart(uint16(addresses[index-1])))
+	}
+	if index < len(addresses) {
+		nearest = append(nearest, g.describeBlockStart(uint16(addresses[index])))
+	}
+	return invalid.Notef("nearest valid block addresses: %s", strings.Join(nearest, ", "))
+}
+
+// describeBlockStart formats a block address followed by the block label, if any
+func (g *generator) describeBlockStart(address uint16) string {
+	if block := g.blockStarts[address]; block.Name != "" {
+		return fmt.Sprintf("0x%04X (%s)", address, block.Name)
+	}
+	return fmt.Sprintf("0x%04X", address)
+}
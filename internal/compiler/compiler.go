@@ -0,0 +1,36 @@
+// Package compiler runs the whole compilation pipeline, from source text to bytecode, for the
+// tools that need more than the Intel HEX output of the command-line compiler.
+package compiler
+
+import (
+	"cyone/internal/ast"
+	"cyone/internal/bytecode"
+	"cyone/internal/diagnostic"
+	"cyone/internal/lexer"
+	"cyone/internal/parser"
+)
+
+// Compile lexes, parses and generates the bytecode of a source file. The program is returned
+// even when it has syntax errors, and may be partial; the bytecode is nil unless compilation
+// succeeded. The diagnostics are sorted by location.
+func Compile(filename, source string, options bytecode.Options) (*ast.Program, []bytecode.Bytecode, diagnostic.List) {
+	// Initialize the lexer
+	lex := lexer.NewFileLexer(filename, source)
+	tokens, diagnostics := lex.Tokenize()
+
+	// Initialize the parser
+	par := parser.NewParser(tokens)
+	program, _ := par.Parse()
+	diagnostics.Add(par.Diagnostics()...)
+
+	// Semantic errors are only meaningful once the program is syntactically valid
+	var bytecodes []bytecode.Bytecode
+	if !diagnostics.HasErrors() {
+		var generationDiagnostics diagnostic.List
+		bytecodes, generationDiagnostics = bytecode.GenerateBytecodeWithOptions(program, options)
+		diagnostics.Add(generationDiagnostics...)
+	}
+
+	diagnostics.Sort()
+	return program, bytecodes, diagnostics
+}
@@ -0,0 +1,168 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"cyone/internal/bytecode"
+	"cyone/internal/diagnostic"
+	"cyone/internal/kernel"
+)
+
+// locations returns the location and the code of every diagnostic, in the reported order
+func locations(diagnostics diagnostic.List) []string {
+	result := make([]string, len(diagnostics))
+	for i, d := range diagnostics {
+		result[i] = fmt.Sprintf("%d:%d %s", d.Span.Start.Line, d.Span.Start.Column, d.Code)
+	}
+	return result
+}
+
+func TestCompileDiagnostics(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		expected []string
+	}{
+		{
+			name: "syntax errors in every construct",
+			source: "loc x at 0x0000;\n" +
+				"loc y 0x0001;\n" +
+				"block 0x0100 {\n" +
+				"    x = ;\n" +
+				"    y = 0x01;\n" +
+				"    z = 0x02 $;\n" +
+				"}\n" +
+				"block 0x0200 { goto ; }\n",
+			expected: []string{"2:7 E201", "4:9 E200", "6:14 E100", "8:21 E201"},
+		},
+		{
+			name: "semantic errors sorted by location",
+			source: "loc x at 0x0000;\n" +
+				"start at 0x0300;\n" +
+				"block 0x0100 {\n" +
+				"    z = 0x01;\n" +
+				"    x = y + 0x01;\n" +
+				"    call NOPE (0x01);\n" +
+				"    x = x / 0x00;\n" +
+				"}\n" +
+				"block 0x0100 { goto missing; }\n",
+			expected: []string{"2:1 E311", "4:5 E300", "5:9 E300", "6:5 E301", "7:13 E307", "9:1 E302", "9:16 E309"},
+		},
+		{
+			name: "errors and warnings on one line",
+			source: "loc x at 0x0000;\n" +
+				"block 0x0100 { a = b + c; }\n",
+			expected: []string{"2:1 W300", "2:16 E300", "2:20 E300"},
+		},
+		{
+			name: "semantic errors wait for valid syntax",
+			source: "loc x at 0x0000;\n" +
+				"block 0x0100 { z = 0x01; x = ; }\n",
+			expected: []string{"2:30 E200"},
+		},
+		{
+			name:     "valid program",
+			source:   "loc x at 0x0000;\nstart at 0x0100;\nblock 0x0100 { x = 0x01; }\n",
+			expected: []string{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, bytecodes, diagnostics := Compile("test.cyo", test.source, bytecode.DefaultOptions())
+			got := locations(diagnostics)
+			if fmt.Sprint(got) != fmt.Sprint(test.expected) {
+				for _, d := range diagnostics {
+					t.Log(d.String())
+				}
+				t.Fatalf("got diagnostics %v, expected %v", got, test.expected)
+			}
+			if diagnostics.HasErrors() != (bytecodes == nil) {
+				t.Errorf("bytecode is returned only when compilation succeeds")
+			}
+			for _, d := range diagnostics {
+				if d.Span.Start.Filename != "test.cyo" {
+					t.Errorf("%s is not located in test.cyo", d)
+				}
+			}
+		})
+	}
+}
+
+func TestDiagnosticNotes(t *testing.T) {
+	source := "start at 0x0104;\nblock 0x0100 { goto 0x0100; }\nblock 0x0200 { goto 0x0100; }\n"
+	_, _, diagnostics := Compile("test.cyo", source, bytecode.DefaultOptions())
+	if len(diagnostics) != 1 {
+		t.Fatalf("got %d diagnostics, expected 1", len(diagnostics))
+	}
+	expected := "test.cyo:1:1: error[E311]: start address 0x0104 is not the start of any block\n" +
+		"    note: nearest valid block addresses: 0x0100, 0x0200"
+	if got := diagnostics[0].String(); got != expected {
+		t.Errorf("got\n%s\nexpected\n%s", got, expected)
+	}
+}
+
+// testKernel extends the reference kernel with a function that returns a byte and one that
+// takes address and word parameters
+func testKernel(t *testing.T) *kernel.Table {
+	t.Helper()
+	table, err := kernel.New("test", append(kernel.Default().Functions(),
+		&kernel.Function{Name: "READ_ADC", Opcode: 0x10, Parameters: []kernel.Parameter{{Name: "channel", Kind: kernel.Byte}}, Returns: kernel.Byte},
+		&kernel.Function{Name: "FILL", Opcode: 0x20, Parameters: []kernel.Parameter{{Name: "buffer", Kind: kernel.Address}, {Name: "count", Kind: kernel.Word}, {Name: "value", Kind: kernel.Byte}}},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+// TestStatementDiagnostics checks the code, the span and the message of the diagnostics of
+// calls and of byte and word values. Statements start on line 5, column 5.
+func TestStatementDiagnostics(t *testing.T) {
+	tests := []struct {
+		name      string
+		statement string
+		expected  []string
+	}{
+		// Calls checked against the kernel signature
+		{"call with every kind of parameter", "call FILL (0x0200, 0x0100, b); call FILL (b, w, 0xFF);", nil},
+		{"too many parameters", "call SET_COLOR (0x01, 0x02);", []string{"5:27-5:31 E312 SET_COLOR (index: byte) expects 1 parameters, got 2"}},
+		{"too few parameters", "call DRAW_CIRCLE (0x01);", []string{"5:5-5:29 E312 DRAW_CIRCLE (x: byte, y: byte, radius: byte) expects 3 parameters, got 1"}},
+		{"word constant for a byte", "call SET_COLOR (0x0100);", []string{"5:21-5:27 E313 parameter 1 of SET_COLOR (index: byte): 0x0100 does not fit in a byte"}},
+		{"byte variable for a word", "call FILL (b, b, 0x00);", []string{"5:19-5:20 E313 parameter 2 of FILL (buffer: address, count: word, value: byte): 'b' holds a byte, expected a word"}},
+		{"word variable for a byte", "call FILL (b, w, w);", []string{"5:22-5:23 E313 parameter 3 of FILL (buffer: address, count: word, value: byte): 'w' holds a word, expected a byte"}},
+		{"undeclared parameter", "call SET_COLOR (z);", []string{"5:21-5:22 E300 parameter 1 of SET_COLOR (index: byte): variable 'z' is not declared, parameters must be declared variables or constants"}},
+		{"unknown function", "call NOPE (0x01);", []string{"5:5-5:22 E301 function 'NOPE' is not provided by the test kernel"}},
+
+		// Calls inside expressions
+		{"call in an expression", "b = call READ_ADC (0x01) + 0x01; w = w + call READ_ADC (b);", nil},
+		{"call without a value", "b = call SET_COLOR (0x01);", []string{"5:9-5:30 E314 SET_COLOR (index: byte) returns no value, it can only be called as a statement"}},
+		{"unknown function in an expression", "b = b + call NOPE (0x01);", []string{"5:13-5:29 E301 function 'NOPE' is not provided by the test kernel"}},
+		{"parameters of a call in an expression", "b = call READ_ADC (0x01, 0x02);", []string{"5:30-5:34 E312 READ_ADC (channel: byte) -> byte expects 1 parameters, got 2"}},
+		{"word parameter of a call in an expression", "b = call READ_ADC (w);", []string{"5:24-5:25 E313 parameter 1 of READ_ADC (channel: byte) -> byte: 'w' holds a word, expected a byte"}},
+
+		// Byte and word values
+		{"byte and word values", "b = 0xFF; b = 0x00FF; w = 0x0100; w = w + b; mem[0x0020] = 0x0FF; b = w > 0x0100;", nil},
+		{"word constant in a byte", "b = 0x0100;", []string{"5:9-5:15 E303 constant 0x0100 does not fit in a byte, 'b' holds a byte"}},
+		{"word expression in a byte", "b = w;", []string{"5:9-5:10 E315 'b' holds a byte, but the expression is a word"}},
+		{"word expression in memory", "mem[0x0020] = w + 0x01;", []string{"5:19-5:27 E315 mem[0x0020] holds a byte, but the expression is a word"}},
+		{"constant larger than a word", "w = 0x10000;", []string{"5:9-5:16 E303 constant 0x10000 does not fit in a word, the largest word is 0xFFFF"}},
+		{"constant larger than a word in an expression", "b = b + 0x10000;", []string{"5:13-5:20 E303 constant 0x10000 does not fit in a word, the largest word is 0xFFFF"}},
+	}
+	options := bytecode.DefaultOptions()
+	options.Kernel = testKernel(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := "loc b at 0x0000;\nloc w at 0x0010 : word;\nstart at 0x0100;\nblock 0x0100 {\n    " + test.statement + "\n}\n"
+			_, _, diagnostics := Compile("test.cyo", source, options)
+			var got []string
+			for _, d := range diagnostics {
+				got = append(got, fmt.Sprintf("%d:%d-%d:%d %s %s", d.Span.Start.Line, d.Span.Start.Column, d.Span.End.Line, d.Span.End.Column, d.Code, d.Message))
+			}
+			if strings.Join(got, "\n") != strings.Join(test.expected, "\n") {
+				t.Errorf("got diagnostics\n%s\nexpected\n%s", strings.Join(got, "\n"), strings.Join(test.expected, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,361 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"cyone/internal/bytecode"
+	"cyone/internal/compiler"
+	"cyone/internal/debugger"
+	"cyone/internal/vm"
+)
+
+// DefaultMaxSteps limits the statements executed by a continue request when the launch
+// configuration does not set maxSteps, so that a program looping forever does not block the
+// server, which cannot be paused while running
+const DefaultMaxSteps = 1000000
+
+// threadID identifies the single thread of a Cyone program
+const threadID = 1
+
+// References of the variable scopes
+const (
+	variablesReference = 1
+	machineReference   = 2
+)
+
+// Server answers the requests of a debugging session
+type Server struct {
+	in      *bufio.Reader
+	out     io.Writer
+	seq     int
+	options bytecode.Options
+
+	session     *debugger.Session
+	path        string
+	stopOnEntry bool
+	lines       []int // Lines of the breakpoints requested by the editor
+}
+
+// NewServer returns a server reading requests from in and writing responses and events to out.
+// Programs are compiled with options.
+func NewServer(in io.Reader, out io.Writer, options bytecode.Options) *Server {
+	return &Server{in: bufio.NewReader(in), out: out, options: options}
+}
+
+// Serve answers requests until the editor disconnects or closes the input
+func (s *Server) Serve() error {
+	for {
+		req, err := readMessage(s.in)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		body, handleErr := s.handle(req)
+		res := response{Type: "response", RequestSeq: req.Seq, Success: handleErr == nil, Command: req.Command, Body: body}
+		if handleErr != nil {
+			res.Message = handleErr.Error()
+		}
+		if err := s.send(&res); err != nil {
+			return err
+		}
+		if handleErr == nil {
+			if err := s.after(req.Command); err != nil {
+				return err
+			}
+		}
+		if req.Command == "disconnect" {
+			return nil
+		}
+	}
+}
+
+// send writes a response or an event with the next sequence number
+func (s *Server) send(message interface{}) error {
+	s.seq++
+	switch message := message.(type) {
+	case *response:
+		message.Seq = s.seq
+	case *event:
+		message.Seq = s.seq
+	}
+	return writeMessage(s.out, message)
+}
+
+// sendEvent writes an event
+func (s *Server) sendEvent(name string, body interface{}) error {
+	return s.send(&event{Type: "event", Event: name, Body: body})
+}
+
+// Write sends program output, such as the kernel calls logged by the stubs, to the editor
+func (s *Server) Write(p []byte) (int, error) {
+	if err := s.sendEvent("output", outputEvent{Category: "stdout", Output: string(p)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// handle answers a request and returns the body of the response
+func (s *Server) handle(req *request) (interface{}, error) {
+	switch req.Command {
+	case "initialize":
+		return capabilities{SupportsConfigurationDoneRequest: true, SupportsReadMemoryRequest: true, SupportsEvaluateForHovers: true}, nil
+	case "launch":
+		var args launchArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return nil, s.launch(args)
+	case "setBreakpoints":
+		var args setBreakpointsArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		s.lines = s.lines[:0]
+		for _, b := range args.Breakpoints {
+			s.lines = append(s.lines, b.Line)
+		}
+		return map[string]interface{}{"breakpoints": s.setBreakpoints()}, nil
+	case "configurationDone", "disconnect":
+		return nil, nil
+	case "threads":
+		return map[string]interface{}{"threads": []thread{{ID: threadID, Name: "main"}}}, nil
+	case "stackTrace":
+		frames := s.stackTrace()
+		return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}, nil
+	case "scopes":
+		return map[string]interface{}{"scopes": []scope{
+			{Name: "Variables", VariablesReference: variablesReference},
+			{Name: "Machine", VariablesReference: machineReference},
+		}}, nil
+	case "variables":
+		var args struct {
+			VariablesReference int `json:"variablesReference"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"variables": s.variables(args.VariablesReference)}, nil
+	case "continue", "next", "stepIn", "stepOut":
+		if s.session == nil || s.session.Machine().Halted {
+			return nil, fmt.Errorf("the program is not running")
+		}
+		if req.Command == "continue" {
+			return map[string]interface{}{"allThreadsContinued": true}, nil
+		}
+		return nil, nil
+	case "evaluate":
+		var args evaluateArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		if s.session == nil {
+			return nil, fmt.Errorf("the program is not running")
+		}
+		value, err := s.session.Evaluate(args.Expression)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"result": fmt.Sprintf("%s (%d)", value, value.Bits), "variablesReference": 0}, nil
+	case "readMemory":
+		var args readMemoryArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return s.readMemory(args)
+	default:
+		return nil, fmt.Errorf("unsupported request '%s'", req.Command)
+	}
+}
+
+// after sends the events that follow the response to a request
+func (s *Server) after(command string) error {
+	switch command {
+	case "launch":
+		return s.sendEvent("initialized", nil)
+	case "configurationDone":
+		if s.session == nil {
+			return nil
+		}
+		if s.stopOnEntry {
+			return s.sendEvent("stopped", stoppedEvent{Reason: "entry", ThreadID: threadID, AllThreadsStopped: true})
+		}
+		return s.resume(s.session.Continue)
+	case "continue":
+		return s.resume(s.session.Continue)
+	case "next", "stepIn", "stepOut":
+		// Statements are the smallest unit of execution and there are no functions to step
+		// over or out of, so every step request executes a single statement
+		return s.resume(s.session.Step)
+	}
+	return nil
+}
+
+// launch compiles the program and loads it on a new debugging session
+func (s *Server) launch(args launchArguments) error {
+	source, err := os.ReadFile(args.Program)
+	if err != nil {
+		return err
+	}
+	program, bytecodes, diagnostics := compiler.Compile(args.Program, string(source), s.options)
+	for _, d := range diagnostics {
+		if err := s.sendEvent("output", outputEvent{Category: "stderr", Output: d.String() + "\n"}); err != nil {
+			return err
+		}
+	}
+	if diagnostics.HasErrors() {
+		return fmt.Errorf("%s: compilation failed", args.Program)
+	}
+	s.session, err = debugger.NewSession(program, bytecodes, vm.NewLoggingRegistry(s, s.options.Kernel))
+	if err != nil {
+		return err
+	}
+	s.session.Machine().MaxSteps = args.MaxSteps
+	if args.MaxSteps == 0 {
+		s.session.Machine().MaxSteps = DefaultMaxSteps
+	}
+	s.path = args.Program
+	s.stopOnEntry = args.StopOnEntry
+	s.setBreakpoints()
+	return nil
+}
+
+// setBreakpoints applies the breakpoints requested by the editor to the session
+func (s *Server) setBreakpoints() []breakpoint {
+	breakpoints := make([]breakpoint, 0, len(s.lines))
+	if s.session != nil {
+		s.session.ClearLineBreakpoints()
+	}
+	for _, line := range s.lines {
+		if s.session == nil {
+			// Verified once the program is launched
+			breakpoints = append(breakpoints, breakpoint{Line: line})
+			continue
+		}
+		actual, err := s.session.BreakAtLine(line)
+		if err != nil {
+			breakpoints = append(breakpoints, breakpoint{Line: line, Message: err.Error()})
+			continue
+		}
+		breakpoints = append(breakpoints, breakpoint{Verified: true, Line: actual})
+	}
+	return breakpoints
+}
+
+// resume runs the program with a step or continue function of the session and reports
+// where it stopped
+func (s *Server) resume(run func() (debugger.Stop, error)) error {
+	stop, err := run()
+	if err != nil {
+		if err := s.sendEvent("output", outputEvent{Category: "stderr", Output: err.Error() + "\n"}); err != nil {
+			return err
+		}
+		return s.terminate(1)
+	}
+	switch stop.Reason {
+	case debugger.StopHalted:
+		return s.terminate(0)
+	case debugger.StopWatchpoint:
+		description := fmt.Sprintf("mem[0x%04X] changed from 0x%02X to 0x%02X", stop.WatchAddress, stop.OldValue, stop.NewValue)
+		return s.sendEvent("stopped", stoppedEvent{Reason: "data breakpoint", Description: description, ThreadID: threadID, AllThreadsStopped: true})
+	case debugger.StopBreakpoint:
+		return s.sendEvent("stopped", stoppedEvent{Reason: "breakpoint", ThreadID: threadID, AllThreadsStopped: true})
+	default:
+		return s.sendEvent("stopped", stoppedEvent{Reason: "step", ThreadID: threadID, AllThreadsStopped: true})
+	}
+}
+
+// terminate reports the end of the program
+func (s *Server) terminate(exitCode int) error {
+	if err := s.sendEvent("exited", map[string]int{"exitCode": exitCode}); err != nil {
+		return err
+	}
+	return s.sendEvent("terminated", nil)
+}
+
+// stackTrace returns a single frame for the next statement of the program
+func (s *Server) stackTrace() []stackFrame {
+	if s.session == nil || s.session.Machine().Halted {
+		return []stackFrame{}
+	}
+	machine := s.session.Machine()
+	frame := stackFrame{
+		ID:                          1,
+		Name:                        "block " + s.session.BlockName(machine.Block),
+		Source:                      &source{Name: filepath.Base(s.path), Path: s.path},
+		InstructionPointerReference: fmt.Sprintf("0x%04X", machine.PC),
+	}
+	if line, found := s.session.Location(machine.PC); found {
+		frame.Line = line.Span.Start.Line
+		frame.Column = line.Span.Start.Column
+	}
+	return []stackFrame{frame}
+}
+
+// variables returns the content of a scope
+func (s *Server) variables(reference int) []variable {
+	variables := []variable{}
+	if s.session == nil {
+		return variables
+	}
+	switch reference {
+	case variablesReference:
+		for _, v := range s.session.Variables() {
+			declaration := fmt.Sprintf("loc at 0x%04X", v.Address)
+			if v.Value.Word {
+				declaration += " : word"
+			}
+			variables = append(variables, variable{
+				Name:            v.Name,
+				Value:           v.Value.String(),
+				Type:            declaration,
+				EvaluateName:    v.Name,
+				MemoryReference: fmt.Sprintf("0x%04X", v.Address),
+			})
+		}
+	case machineReference:
+		machine := s.session.Machine()
+		variables = append(variables,
+			variable{Name: "pc", Value: fmt.Sprintf("0x%04X", machine.PC)},
+			variable{Name: "block", Value: fmt.Sprintf("0x%04X", machine.Block)},
+			variable{Name: "steps", Value: strconv.Itoa(machine.Steps)},
+		)
+	}
+	return variables
+}
+
+// readMemory returns a range of data memory encoded in base64
+func (s *Server) readMemory(args readMemoryArguments) (interface{}, error) {
+	if s.session == nil {
+		return nil, fmt.Errorf("the program is not running")
+	}
+	base, err := strconv.ParseUint(args.MemoryReference, 0, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid memory reference '%s'", args.MemoryReference)
+	}
+	if args.Count < 0 {
+		return nil, fmt.Errorf("invalid count %d", args.Count)
+	}
+	start := int(base) + args.Offset
+	if start < 0 || start >= vm.MemorySize {
+		return map[string]interface{}{"address": fmt.Sprintf("0x%04X", base), "unreadableBytes": args.Count}, nil
+	}
+	end := start + args.Count
+	if end > vm.MemorySize {
+		end = vm.MemorySize
+	}
+	return map[string]interface{}{
+		"address":         fmt.Sprintf("0x%04X", start),
+		"data":            base64.StdEncoding.EncodeToString(s.session.Machine().Memory[start:end]),
+		"unreadableBytes": args.Count - (end - start),
+	}, nil
+}
@@ -0,0 +1,243 @@
+package dap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"cyone/internal/bytecode"
+)
+
+// testSource calls SET_COLOR with n, from 0x02 down to 0x01, in the block loop
+const testSource = `loc x at 0x0000;
+loc n at 0x0001;
+start at main;
+block main {
+    x = 0x01;
+    n = 0x02;
+    goto loop;
+}
+block loop {
+    call SET_COLOR (n);
+    n = n - 0x01;
+    if (n != 0x00) {
+        goto loop;
+    }
+}
+`
+
+// step is a request of a scripted session and the messages expected in return: the response
+// then the events that follow it
+type step struct {
+	command   string
+	arguments interface{}
+	expected  []string
+}
+
+// summary returns the request sequence number of a response, or 0 for an event, and the
+// message as its kind, its command or event name and its body in JSON. Failed responses end
+// with their error message instead. The path of the program is shortened to test.cyo.
+func summary(t *testing.T, body []byte, path string) (int, string) {
+	t.Helper()
+	var m struct {
+		Type       string          `json:"type"`
+		RequestSeq int             `json:"request_seq"`
+		Success    bool            `json:"success"`
+		Command    string          `json:"command"`
+		Message    string          `json:"message"`
+		Event      string          `json:"event"`
+		Body       json.RawMessage `json:"body"`
+	}
+	if err := json.Unmarshal(body, &m); err != nil {
+		t.Fatal(err)
+	}
+	content := strings.ReplaceAll(string(m.Body), path, "test.cyo")
+	switch {
+	case m.Type == "event":
+		return 0, strings.TrimSpace("event " + m.Event + " " + content)
+	case !m.Success:
+		return m.RequestSeq, fmt.Sprintf("response %s failed: %s", m.Command, strings.ReplaceAll(m.Message, path, "test.cyo"))
+	default:
+		return m.RequestSeq, strings.TrimSpace("response " + m.Command + " " + content)
+	}
+}
+
+// play sends the requests of a script to a server and checks the messages it writes back
+func play(t *testing.T, script []step) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.cyo")
+	if err := os.WriteFile(path, []byte(testSource), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var in, out bytes.Buffer
+	for i, s := range script {
+		arguments, err := json.Marshal(s.arguments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		arguments = bytes.ReplaceAll(arguments, []byte("$PROGRAM"), []byte(path))
+		if err := writeMessage(&in, request{Seq: i + 1, Type: "request", Command: s.command, Arguments: arguments}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := NewServer(&in, &out, bytecode.DefaultOptions()).Serve(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make([][]string, len(script))
+	current := -1
+	r := bufio.NewReader(&out)
+	for {
+		header, err := textproto.NewReader(r).ReadMIMEHeader()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		length, _ := strconv.Atoi(header.Get("Content-Length"))
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r, body); err != nil {
+			t.Fatal(err)
+		}
+		seq, message := summary(t, body, path)
+		if seq != 0 {
+			current = seq - 1
+		}
+		if current < 0 || current >= len(script) {
+			t.Fatalf("unexpected message %s", message)
+		}
+		got[current] = append(got[current], message)
+	}
+	for i, s := range script {
+		if strings.Join(got[i], "\n") != strings.Join(s.expected, "\n") {
+			t.Errorf("request %d %s gives\n%s\nexpected\n%s", i+1, s.command, strings.Join(got[i], "\n"), strings.Join(s.expected, "\n"))
+		}
+	}
+}
+
+func TestSession(t *testing.T) {
+	play(t, []step{
+		{"initialize", map[string]string{"adapterID": "cyone"}, []string{
+			`response initialize {"supportsConfigurationDoneRequest":true,"supportsReadMemoryRequest":true,"supportsEvaluateForHovers":true}`,
+		}},
+		{"launch", map[string]string{"program": "$PROGRAM"}, []string{
+			"response launch",
+			"event initialized",
+		}},
+		{"setBreakpoints", setBreakpointsArguments{Source: source{Path: "$PROGRAM"}, Breakpoints: []sourceBreakpoint{{Line: 8}, {Line: 11}, {Line: 20}}}, []string{
+			`response setBreakpoints {"breakpoints":[{"verified":true,"line":10},{"verified":true,"line":11},{"verified":false,"line":20,"message":"no statement at or after line 20"}]}`,
+		}},
+		{"configurationDone", nil, []string{
+			"response configurationDone",
+			`event stopped {"reason":"breakpoint","threadId":1,"allThreadsStopped":true}`,
+		}},
+		{"threads", nil, []string{
+			`response threads {"threads":[{"id":1,"name":"main"}]}`,
+		}},
+		{"stackTrace", map[string]int{"threadId": 1}, []string{
+			`response stackTrace {"stackFrames":[{"id":1,"name":"block loop","source":{"name":"test.cyo","path":"test.cyo"},"line":10,"column":5,"instructionPointerReference":"0x011B"}],"totalFrames":1}`,
+		}},
+		{"continue", map[string]int{"threadId": 1}, []string{
+			`response continue {"allThreadsContinued":true}`,
+			`event output {"category":"stdout","output":"SET_COLOR(0x02)\n"}`,
+			`event stopped {"reason":"breakpoint","threadId":1,"allThreadsStopped":true}`,
+		}},
+		{"scopes", map[string]int{"frameId": 1}, []string{
+			`response scopes {"scopes":[{"name":"Variables","variablesReference":1,"expensive":false},{"name":"Machine","variablesReference":2,"expensive":false}]}`,
+		}},
+		{"variables", map[string]int{"variablesReference": 1}, []string{
+			`response variables {"variables":[` +
+				`{"name":"x","value":"0x01","type":"loc at 0x0000","evaluateName":"x","memoryReference":"0x0000","variablesReference":0},` +
+				`{"name":"n","value":"0x02","type":"loc at 0x0001","evaluateName":"n","memoryReference":"0x0001","variablesReference":0}]}`,
+		}},
+		{"variables", map[string]int{"variablesReference": 2}, []string{
+			`response variables {"variables":[{"name":"pc","value":"0x0123","variablesReference":0},{"name":"block","value":"0x0117","variablesReference":0},{"name":"steps","value":"4","variablesReference":0}]}`,
+		}},
+		{"readMemory", readMemoryArguments{MemoryReference: "0x0000", Count: 3}, []string{
+			`response readMemory {"address":"0x0000","data":"AQIA","unreadableBytes":0}`,
+		}},
+		{"readMemory", readMemoryArguments{MemoryReference: "0xFFFF", Offset: -1, Count: 4}, []string{
+			`response readMemory {"address":"0xFFFE","data":"AAA=","unreadableBytes":2}`,
+		}},
+		{"readMemory", readMemoryArguments{MemoryReference: "0x0000", Count: -1}, []string{
+			"response readMemory failed: invalid count -1",
+		}},
+		{"evaluate", evaluateArguments{Expression: "n + x * 0x10"}, []string{
+			`response evaluate {"result":"0x12 (18)","variablesReference":0}`,
+		}},
+		{"evaluate", evaluateArguments{Expression: "y"}, []string{
+			"response evaluate failed: unknown variable 'y'",
+		}},
+		{"next", map[string]int{"threadId": 1}, []string{
+			"response next",
+			`event stopped {"reason":"step","threadId":1,"allThreadsStopped":true}`,
+		}},
+		{"setBreakpoints", setBreakpointsArguments{Source: source{Path: "$PROGRAM"}}, []string{
+			`response setBreakpoints {"breakpoints":[]}`,
+		}},
+		{"continue", map[string]int{"threadId": 1}, []string{
+			`response continue {"allThreadsContinued":true}`,
+			`event output {"category":"stdout","output":"SET_COLOR(0x01)\n"}`,
+			`event exited {"exitCode":0}`,
+			"event terminated",
+		}},
+		{"stackTrace", map[string]int{"threadId": 1}, []string{
+			`response stackTrace {"stackFrames":[],"totalFrames":0}`,
+		}},
+		{"continue", map[string]int{"threadId": 1}, []string{
+			"response continue failed: the program is not running",
+		}},
+		{"pause", map[string]int{"threadId": 1}, []string{
+			"response pause failed: unsupported request 'pause'",
+		}},
+		{"disconnect", nil, []string{
+			"response disconnect",
+		}},
+	})
+}
+
+func TestStopOnEntry(t *testing.T) {
+	play(t, []step{
+		{"launch", map[string]interface{}{"program": "$PROGRAM", "stopOnEntry": true}, []string{
+			"response launch",
+			"event initialized",
+		}},
+		{"configurationDone", nil, []string{
+			"response configurationDone",
+			`event stopped {"reason":"entry","threadId":1,"allThreadsStopped":true}`,
+		}},
+		{"stepIn", map[string]int{"threadId": 1}, []string{
+			"response stepIn",
+			`event stopped {"reason":"step","threadId":1,"allThreadsStopped":true}`,
+		}},
+		{"variables", map[string]int{"variablesReference": 1}, []string{
+			`response variables {"variables":[` +
+				`{"name":"x","value":"0x01","type":"loc at 0x0000","evaluateName":"x","memoryReference":"0x0000","variablesReference":0},` +
+				`{"name":"n","value":"0x00","type":"loc at 0x0001","evaluateName":"n","memoryReference":"0x0001","variablesReference":0}]}`,
+		}},
+	})
+}
+
+func TestLaunchErrors(t *testing.T) {
+	play(t, []step{
+		{"launch", map[string]string{"program": "$PROGRAM.missing"}, []string{
+			"response launch failed: open test.cyo.missing: no such file or directory",
+		}},
+		{"evaluate", evaluateArguments{Expression: "x"}, []string{
+			"response evaluate failed: the program is not running",
+		}},
+		{"readMemory", readMemoryArguments{MemoryReference: "0x0000", Count: 1}, []string{
+			"response readMemory failed: the program is not running",
+		}},
+	})
+}
@@ -0,0 +1,405 @@
+// Package debugger controls the execution of a program on the reference virtual machine with
+// breakpoints, watchpoints and source-level information. It is shared by the interactive
+// debugger and the Debug Adapter Protocol server of the command-line tool.
+package debugger
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"cyone/internal/ast"
+	"cyone/internal/bytecode"
+	"cyone/internal/lexer"
+	"cyone/internal/parser"
+	"cyone/internal/vm"
+)
+
+// historySize is the number of block entries kept for the backtrace
+const historySize = 64
+
+// StopReason tells why execution stopped
+type StopReason int
+
+const (
+	StopStep       StopReason = iota // A single statement was executed
+	StopBreakpoint                   // Execution reached a breakpoint
+	StopWatchpoint                   // A watched memory address changed
+	StopHalted                       // The program ended
+)
+
+func (r StopReason) String() string {
+	switch r {
+	case StopStep:
+		return "step"
+	case StopBreakpoint:
+		return "breakpoint"
+	case StopWatchpoint:
+		return "watchpoint"
+	case StopHalted:
+		return "halted"
+	default:
+		return "unknown"
+	}
+}
+
+// Stop describes the state of the session after a step or a continue
+type Stop struct {
+	Reason  StopReason
+	Address uint16 // Address of the next statement
+
+	// Watched memory change that caused a StopWatchpoint
+	WatchAddress       uint16
+	OldValue, NewValue byte
+}
+
+// Jump is an entry of the block history: the statement at From entered the block at Block.
+// The first block is entered from the start vector at 0x0000.
+type Jump struct {
+	From  uint16
+	Block uint16
+	Step  int
+}
+
+// Variable is a declared variable and its current value
+type Variable struct {
+	Name    string
+	Address uint16
+	Value   bytecode.Value
+}
+
+// Session is a program being debugged
+type Session struct {
+	machine   *vm.Machine
+	program   *ast.Program
+	variables map[string]uint16
+	words     map[string]bool // Variables declared as words
+	labels    map[string]uint16
+	blocks    map[uint16]*ast.Block
+	lines     []bytecode.Line // Sorted by address
+
+	lineBreakpoints  map[uint16]bool
+	blockBreakpoints map[uint16]bool
+	watchpoints      map[uint16]byte // Last value seen at each watched address
+
+	history  []Jump
+	current  uint16 // Address of the statement being executed
+	blockHit bool   // Whether the last step entered a block with a breakpoint
+}
+
+// NewSession loads a compiled program on a new machine and stops before its first statement
+func NewSession(program *ast.Program, bytecodes []bytecode.Bytecode, kernel vm.Kernel) (*Session, error) {
+	s := &Session{
+		machine:          vm.NewMachine(kernel),
+		program:          program,
+		variables:        make(map[string]uint16, len(program.Variables)),
+		words:            make(map[string]bool),
+		labels:           make(map[string]uint16),
+		blocks:           make(map[uint16]*ast.Block),
+		lineBreakpoints:  make(map[uint16]bool),
+		blockBreakpoints: make(map[uint16]bool),
+		watchpoints:      make(map[uint16]byte),
+	}
+	for _, variable := range program.Variables {
+		address, err := strconv.ParseUint(variable.Address, 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address of variable '%s': %v", variable.Name, err)
+		}
+		s.variables[variable.Name] = uint16(address)
+		if variable.IsWord() {
+			s.words[variable.Name] = true
+		}
+	}
+	for _, bc := range bytecodes {
+		if bc.Block == nil {
+			continue
+		}
+		s.blocks[bc.Address] = bc.Block
+		if bc.Block.Name != "" {
+			s.labels[bc.Block.Name] = bc.Address
+		}
+		s.lines = append(s.lines, bc.Lines...)
+	}
+	sort.Slice(s.lines, func(i, j int) bool { return s.lines[i].Address < s.lines[j].Address })
+
+	s.machine.LoadBytecode(bytecodes)
+	s.machine.OnBlockEnter = s.enterBlock
+	if err := s.machine.Start(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Machine returns the machine running the program
+func (s *Session) Machine() *vm.Machine {
+	return s.machine
+}
+
+// enterBlock records a block entry in the history
+func (s *Session) enterBlock(m *vm.Machine) error {
+	s.history = append(s.history, Jump{From: s.current, Block: m.Block, Step: m.Steps})
+	if len(s.history) > historySize {
+		s.history = s.history[len(s.history)-historySize:]
+	}
+	if s.blockBreakpoints[m.Block] {
+		s.blockHit = true
+	}
+	return nil
+}
+
+// Step executes a single statement
+func (s *Session) Step() (Stop, error) {
+	if err := s.step(); err != nil {
+		return Stop{}, err
+	}
+	if stop, stopped := s.check(); stopped {
+		return stop, nil
+	}
+	return Stop{Reason: StopStep, Address: s.machine.PC}, nil
+}
+
+// Continue executes statements until a breakpoint or watchpoint is hit or the program ends
+func (s *Session) Continue() (Stop, error) {
+	for {
+		if err := s.step(); err != nil {
+			return Stop{}, err
+		}
+		if stop, stopped := s.check(); stopped {
+			return stop, nil
+		}
+	}
+}
+
+// step executes the next statement of the machine. A halted machine stays halted: the last
+// statement may have stopped on a watchpoint before the halt is reported.
+func (s *Session) step() error {
+	if s.machine.Halted {
+		return nil
+	}
+	s.current = s.machine.PC
+	s.blockHit = false
+	return s.machine.Step()
+}
+
+// check reports whether execution must stop after a step
+func (s *Session) check() (Stop, bool) {
+	watched := make([]int, 0, len(s.watchpoints))
+	for address := range s.watchpoints {
+		watched = append(watched, int(address))
+	}
+	sort.Ints(watched)
+	for _, w := range watched {
+		address := uint16(w)
+		old, value := s.watchpoints[address], s.machine.Memory[address]
+		if old != value {
+			s.watchpoints[address] = value
+			return Stop{Reason: StopWatchpoint, Address: s.machine.PC, WatchAddress: address, OldValue: old, NewValue: value}, true
+		}
+	}
+	if s.machine.Halted {
+		return Stop{Reason: StopHalted, Address: s.machine.PC}, true
+	}
+	if s.blockHit || s.lineBreakpoints[s.machine.PC] {
+		return Stop{Reason: StopBreakpoint, Address: s.machine.PC}, true
+	}
+	return Stop{}, false
+}
+
+// BreakAtLine sets a breakpoint on the statements that start on a source line. When no
+// statement starts on that line, the next line holding a statement is used instead.
+// Returns the line where the breakpoint was set.
+func (s *Session) BreakAtLine(line int) (int, error) {
+	target := 0
+	for _, l := range s.lines {
+		if statementLine := l.Span.Start.Line; statementLine >= line && (target == 0 || statementLine < target) {
+			target = statementLine
+		}
+	}
+	if target == 0 {
+		return 0, fmt.Errorf("no statement at or after line %d", line)
+	}
+	for _, l := range s.lines {
+		if l.Span.Start.Line == target {
+			s.lineBreakpoints[l.Address] = true
+		}
+	}
+	return target, nil
+}
+
+// BreakAtBlock sets a breakpoint on the entry of the block at address
+func (s *Session) BreakAtBlock(address uint16) error {
+	if _, exists := s.blocks[address]; !exists {
+		return fmt.Errorf("no block starts at 0x%04X", address)
+	}
+	s.blockBreakpoints[address] = true
+	return nil
+}
+
+// ClearBreakpoints removes every line and block breakpoint
+func (s *Session) ClearBreakpoints() {
+	s.lineBreakpoints = make(map[uint16]bool)
+	s.blockBreakpoints = make(map[uint16]bool)
+}
+
+// ClearLineBreakpoints removes every line breakpoint and keeps the block breakpoints
+func (s *Session) ClearLineBreakpoints() {
+	s.lineBreakpoints = make(map[uint16]bool)
+}
+
+// Watch stops execution whenever the byte at a data memory address changes
+func (s *Session) Watch(address uint16) {
+	s.watchpoints[address] = s.machine.Memory[address]
+}
+
+// BlockAddress returns the address of the block with a label
+func (s *Session) BlockAddress(label string) (uint16, bool) {
+	address, exists := s.labels[label]
+	return address, exists
+}
+
+// BlockName returns the label of the block at address, or its address when it has no label
+func (s *Session) BlockName(address uint16) string {
+	if block, exists := s.blocks[address]; exists && block.Name != "" {
+		return block.Name
+	}
+	return fmt.Sprintf("0x%04X", address)
+}
+
+// Location returns the source location of the statement at address
+func (s *Session) Location(address uint16) (bytecode.Line, bool) {
+	index := sort.Search(len(s.lines), func(i int) bool { return s.lines[i].Address >= address })
+	if index < len(s.lines) && s.lines[index].Address == address {
+		return s.lines[index], true
+	}
+	return bytecode.Line{}, false
+}
+
+// Backtrace returns the most recent block entries, the latest first
+func (s *Session) Backtrace() []Jump {
+	backtrace := make([]Jump, len(s.history))
+	for i, jump := range s.history {
+		backtrace[len(s.history)-1-i] = jump
+	}
+	return backtrace
+}
+
+// Variables returns the declared variables with their current values, in declaration order
+func (s *Session) Variables() []Variable {
+	variables := make([]Variable, 0, len(s.program.Variables))
+	for _, declaration := range s.program.Variables {
+		address := s.variables[declaration.Name]
+		variables = append(variables, Variable{Name: declaration.Name, Address: address, Value: s.variableValue(declaration.Name)})
+	}
+	return variables
+}
+
+// variableValue reads the current value of a declared variable
+func (s *Session) variableValue(name string) bytecode.Value {
+	address := s.variables[name]
+	if s.words[name] {
+		return bytecode.Value{Bits: uint16(s.machine.Memory[address])<<8 | uint16(s.machine.Memory[address+1]), Word: true}
+	}
+	return bytecode.Value{Bits: uint16(s.machine.Memory[address])}
+}
+
+// Evaluate computes the value of an expression written in Cyone syntax, such as "x",
+// "mem[0x0006]" or "x + y * 0x02", on the current memory
+func (s *Session) Evaluate(text string) (bytecode.Value, error) {
+	expression, err := parseExpression(text)
+	if err != nil {
+		return bytecode.Value{}, err
+	}
+	return s.evaluate(expression)
+}
+
+// ResolveAddress returns the data memory address designated by a variable name, a memory
+// access such as "mem[0x0006]" or an address literal. It reports whether the address holds a
+// word variable, whose low byte follows at the next address.
+func (s *Session) ResolveAddress(text string) (uint16, bool, error) {
+	expression, err := parseExpression(text)
+	if err != nil {
+		return 0, false, err
+	}
+	switch expression := expression.(type) {
+	case *ast.Variable:
+		address, exists := s.variables[expression.Name]
+		if !exists {
+			return 0, false, fmt.Errorf("unknown variable '%s'", expression.Name)
+		}
+		return address, s.words[expression.Name], nil
+	case *ast.MemoryLocation:
+		address, err := parseAddress(expression.Address)
+		return address, false, err
+	case *ast.Constant:
+		address, err := parseAddress(expression.Value)
+		return address, false, err
+	default:
+		return 0, false, fmt.Errorf("'%s' is not a memory address", text)
+	}
+}
+
+// evaluate computes the value of an expression tree with the operator semantics of the kernel
+func (s *Session) evaluate(expression ast.Expression) (bytecode.Value, error) {
+	switch expression := expression.(type) {
+	case *ast.Variable:
+		if _, exists := s.variables[expression.Name]; !exists {
+			return bytecode.Value{}, fmt.Errorf("unknown variable '%s'", expression.Name)
+		}
+		return s.variableValue(expression.Name), nil
+	case *ast.MemoryLocation:
+		address, err := parseAddress(expression.Address)
+		if err != nil {
+			return bytecode.Value{}, err
+		}
+		return bytecode.Value{Bits: uint16(s.machine.Memory[address])}, nil
+	case *ast.Constant:
+		return bytecode.ParseConstant(expression.Value)
+	case *ast.UnaryExpression:
+		operand, err := s.evaluate(expression.Expression)
+		if err != nil {
+			return bytecode.Value{}, err
+		}
+		opcode, err := bytecode.OperatorOpcode(expression.Operator, expression.Span)
+		if err != nil {
+			return bytecode.Value{}, err
+		}
+		return bytecode.EvaluateUnaryValue(opcode, operand)
+	case *ast.BinaryExpression:
+		left, err := s.evaluate(expression.LeftExpression)
+		if err != nil {
+			return bytecode.Value{}, err
+		}
+		right, err := s.evaluate(expression.RightExpression)
+		if err != nil {
+			return bytecode.Value{}, err
+		}
+		opcode, err := bytecode.OperatorOpcode(expression.Operator, expression.Span)
+		if err != nil {
+			return bytecode.Value{}, err
+		}
+		return bytecode.EvaluateBinaryValue(opcode, left, right)
+	case *ast.CallExpression:
+		// Evaluating a call would run the kernel function outside of the program
+		return bytecode.Value{}, fmt.Errorf("cannot evaluate call to %s, kernel functions only run from the program", expression.FunctionName)
+	default:
+		return bytecode.Value{}, fmt.Errorf("unsupported expression %T", expression)
+	}
+}
+
+// parseExpression parses an expression typed by the user
+func parseExpression(text string) (ast.Expression, error) {
+	tokens, diagnostics := lexer.NewLexer(text).Tokenize()
+	if err := diagnostics.Err(); err != nil {
+		return nil, err
+	}
+	return parser.NewParser(tokens).ParseExpression()
+}
+
+// parseAddress converts an address literal to a 16-bit address
+func parseAddress(literal string) (uint16, error) {
+	address, err := strconv.ParseUint(literal, 0, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid address '%s': %v", literal, err)
+	}
+	return uint16(address), nil
+}
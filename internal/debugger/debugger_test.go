@@ -0,0 +1,320 @@
+package debugger
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"cyone/internal/bytecode"
+	"cyone/internal/compiler"
+	"cyone/internal/vm"
+)
+
+// testSource counts n down from 0x03 in the block loop, adding 0x0100 to w on every turn
+const testSource = `loc x at 0x0000;
+loc n at 0x0001;
+loc w at 0x0010 : word;
+start at main;
+block main {
+    x = 0x01;
+    n = 0x03;
+    goto loop;
+}
+block loop {
+    n = n - 0x01;
+    w = w + 0x0100;
+    if (n == 0x00) {
+        goto done;
+    }
+    goto loop;
+}
+block done {
+    x = 0x02;
+}
+`
+
+// newSession compiles testSource and starts a session on it
+func newSession(t *testing.T) *Session {
+	t.Helper()
+	program, bytecodes, diagnostics := compiler.Compile("test.cyo", testSource, bytecode.DefaultOptions())
+	if diagnostics.HasErrors() {
+		t.Fatalf("source does not compile: %v", diagnostics)
+	}
+	s, err := NewSession(program, bytecodes, vm.NewRegistry(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Machine().MaxSteps = 1000
+	return s
+}
+
+// describe returns the reason of a stop and the source line of the next statement, followed
+// by the memory change for watchpoints
+func describe(s *Session, stop Stop) string {
+	text := stop.Reason.String()
+	if line, exists := s.Location(stop.Address); exists && stop.Reason != StopHalted {
+		text += fmt.Sprintf(" %d", line.Span.Start.Line)
+	}
+	if stop.Reason == StopWatchpoint {
+		text += fmt.Sprintf(" 0x%04X %02X->%02X", stop.WatchAddress, stop.OldValue, stop.NewValue)
+	}
+	return text
+}
+
+// continueToEnd continues until the program halts and describes every stop
+func continueToEnd(t *testing.T, s *Session) []string {
+	t.Helper()
+	var stops []string
+	for len(stops) < 20 {
+		stop, err := s.Continue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stops = append(stops, describe(s, stop))
+		if stop.Reason == StopHalted {
+			break
+		}
+	}
+	return stops
+}
+
+func TestBreakpoints(t *testing.T) {
+	tests := []struct {
+		name     string
+		setup    func(s *Session) error
+		expected []string
+	}{
+		{
+			name:     "no breakpoint",
+			setup:    func(s *Session) error { return nil },
+			expected: []string{"halted"},
+		},
+		{
+			name:     "line in a loop",
+			setup:    func(s *Session) error { _, err := s.BreakAtLine(11); return err },
+			expected: []string{"breakpoint 11", "breakpoint 11", "breakpoint 11", "halted"},
+		},
+		{
+			name:     "line skipped until the branch is taken",
+			setup:    func(s *Session) error { _, err := s.BreakAtLine(14); return err },
+			expected: []string{"breakpoint 14", "halted"},
+		},
+		{
+			name:     "line skipped once the branch is taken",
+			setup:    func(s *Session) error { _, err := s.BreakAtLine(16); return err },
+			expected: []string{"breakpoint 16", "breakpoint 16", "halted"},
+		},
+		{
+			name:     "line never reached after the first statement",
+			setup:    func(s *Session) error { _, err := s.BreakAtLine(6); return err },
+			expected: []string{"halted"},
+		},
+		{
+			name: "block entry",
+			setup: func(s *Session) error {
+				address, _ := s.BlockAddress("done")
+				return s.BreakAtBlock(address)
+			},
+			expected: []string{"breakpoint 19", "halted"},
+		},
+		{
+			name: "block entered by a loop",
+			setup: func(s *Session) error {
+				address, _ := s.BlockAddress("loop")
+				return s.BreakAtBlock(address)
+			},
+			expected: []string{"breakpoint 11", "breakpoint 11", "breakpoint 11", "halted"},
+		},
+		{
+			name: "cleared breakpoints",
+			setup: func(s *Session) error {
+				address, _ := s.BlockAddress("loop")
+				s.BreakAtLine(12)
+				s.BreakAtBlock(address)
+				s.ClearBreakpoints()
+				return nil
+			},
+			expected: []string{"halted"},
+		},
+		{
+			name: "cleared line breakpoints keep block breakpoints",
+			setup: func(s *Session) error {
+				address, _ := s.BlockAddress("done")
+				s.BreakAtLine(12)
+				s.BreakAtBlock(address)
+				s.ClearLineBreakpoints()
+				return nil
+			},
+			expected: []string{"breakpoint 19", "halted"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newSession(t)
+			if err := test.setup(s); err != nil {
+				t.Fatal(err)
+			}
+			if got := continueToEnd(t, s); strings.Join(got, ", ") != strings.Join(test.expected, ", ") {
+				t.Errorf("got stops %v, expected %v", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestBreakAtLine(t *testing.T) {
+	tests := []struct {
+		line     int
+		expected int
+		err      string
+	}{
+		{6, 6, ""},
+		{1, 6, ""},
+		{9, 11, ""},
+		{13, 13, ""},
+		{20, 0, "no statement at or after line 20"},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.line), func(t *testing.T) {
+			line, err := newSession(t).BreakAtLine(test.line)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("got error %v, expected %q", err, test.err)
+				}
+				return
+			}
+			if err != nil || line != test.expected {
+				t.Errorf("breakpoint set on line %d, %v, expected line %d", line, err, test.expected)
+			}
+		})
+	}
+	if err := newSession(t).BreakAtBlock(0x0001); err == nil {
+		t.Errorf("breakpoint accepted on an address that does not start a block")
+	}
+}
+
+func TestWatchpoints(t *testing.T) {
+	tests := []struct {
+		name     string
+		watch    string
+		expected []string
+	}{
+		{
+			name:     "byte variable",
+			watch:    "n",
+			expected: []string{"watchpoint 8 0x0001 00->03", "watchpoint 12 0x0001 03->02", "watchpoint 12 0x0001 02->01", "watchpoint 12 0x0001 01->00", "halted"},
+		},
+		{
+			name:     "memory location",
+			watch:    "mem[0x0000]",
+			expected: []string{"watchpoint 7 0x0000 00->01", "watchpoint 0x0000 01->02", "halted"},
+		},
+		{
+			name:     "high byte of a word variable",
+			watch:    "w",
+			expected: []string{"watchpoint 13 0x0010 00->01", "watchpoint 13 0x0010 01->02", "watchpoint 13 0x0010 02->03", "halted"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newSession(t)
+			address, _, err := s.ResolveAddress(test.watch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.Watch(address)
+			if got := continueToEnd(t, s); strings.Join(got, ", ") != strings.Join(test.expected, ", ") {
+				t.Errorf("got stops %v, expected %v", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestStep(t *testing.T) {
+	s := newSession(t)
+	var lines []int
+	for {
+		stop, err := s.Step()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stop.Reason == StopHalted {
+			break
+		}
+		if stop.Reason != StopStep {
+			t.Fatalf("step stopped on %s", stop.Reason)
+		}
+		line, exists := s.Location(stop.Address)
+		if !exists {
+			t.Fatalf("no statement at 0x%04X", stop.Address)
+		}
+		lines = append(lines, line.Span.Start.Line)
+	}
+	expected := "[7 8 11 12 13 16 11 12 13 16 11 12 13 14 19]"
+	if got := fmt.Sprint(lines); got != expected {
+		t.Errorf("stepped through lines %s, expected %s", got, expected)
+	}
+}
+
+func TestBacktrace(t *testing.T) {
+	s := newSession(t)
+	continueToEnd(t, s)
+	var blocks []string
+	for _, jump := range s.Backtrace() {
+		from := "start"
+		if line, exists := s.Location(jump.From); exists {
+			from = fmt.Sprint(line.Span.Start.Line)
+		}
+		blocks = append(blocks, fmt.Sprintf("%s from %s", s.BlockName(jump.Block), from))
+	}
+	expected := "[done from 14 loop from 16 loop from 16 loop from 8 main from start]"
+	if got := fmt.Sprint(blocks); got != expected {
+		t.Errorf("got backtrace %s, expected %s", got, expected)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		expression string
+		expected   string
+	}{
+		{"x", "0x02"},
+		{"n", "0x00"},
+		{"w", "0x0300"},
+		{"mem[0x0010]", "0x03"},
+		{"x + n * 0x02", "0x02"},
+		{"w + x", "0x0302"},
+		{"(x << 0x02) | 0x01", "0x09"},
+		{"!n && x", "0x01"},
+		{"y", "unknown variable 'y'"},
+		{"x +", "reached end of input"},
+		{"x x", "unexpected token after expression: x"},
+		{"call READ_ADC (0x01)", "cannot evaluate call to READ_ADC, kernel functions only run from the program"},
+	}
+	s := newSession(t)
+	continueToEnd(t, s)
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			value, err := s.Evaluate(test.expression)
+			got := value.String()
+			if err != nil {
+				got = err.Error()
+			}
+			if !strings.HasSuffix(got, test.expected) {
+				t.Errorf("got %s, expected %s", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestVariables(t *testing.T) {
+	s := newSession(t)
+	continueToEnd(t, s)
+	var variables []string
+	for _, v := range s.Variables() {
+		variables = append(variables, fmt.Sprintf("%s@0x%04X=%s", v.Name, v.Address, v.Value))
+	}
+	expected := "[x@0x0000=0x02 n@0x0001=0x00 w@0x0010=0x0300]"
+	if got := fmt.Sprint(variables); got != expected {
+		t.Errorf("got variables %s, expected %s", got, expected)
+	}
+}
@@ -0,0 +1,169 @@
+package diagnostic
+
+import (
+	"cyone/internal/token"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Severity represents how serious a diagnostic is
+type Severity int
+
+const (
+	Error   Severity = iota // The program cannot be compiled
+	Warning                 // The program compiles but is probably wrong
+	Note                    // Additional information
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	case Note:
+		return "note"
+	default:
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+}
+
+// Diagnostic codes, grouped by the stage that reports them
+const (
+	// Lexical analysis
+	IllegalCharacter = "E100" // Character that cannot start any token
+
+	// Syntax analysis
+	UnexpectedToken = "E200" // Token that cannot appear at this point
+	ExpectedToken   = "E201" // A specific token was required but not found
+	UnexpectedEOF   = "E202" // Input ended in the middle of a construct
+
+	// Semantic analysis
+	UndefinedVariable    = "E300" // Variable used without a 'loc' declaration
+	UnknownFunction      = "E301" // Kernel function not present in the function table
+	BlockOverlap         = "E302" // Two blocks occupy the same code addresses
+	InvalidNumber        = "E303" // Literal that cannot be converted to a number
+	InvalidOperator      = "E304" // Operator without a bytecode encoding
+	InvalidMemoryAddress = "E305" // Memory address that is not a constant
+	ExpressionTooLong    = "E306" // Expression whose encoding exceeds a length field
+	DivisionByZero       = "E307" // Division or modulo by an expression that is always zero
+	DuplicateLabel       = "E308" // Two blocks declared with the same label
+	UndefinedLabel       = "E309" // Label used by 'goto' or 'start' without a matching block
+	CodeRegionOverflow   = "E310" // Block that does not fit in the code region
+	InvalidTarget        = "E311" // 'goto' or 'start' address that is not the start of a block
+	ParameterCount       = "E312" // Call with more or fewer parameters than the function signature
+	ParameterKind        = "E313" // Call parameter that does not match the kind of the signature
+	NoReturnValue        = "E314" // Call used as an operand of a function that returns nothing
+	WordToByte           = "E315" // Word expression stored in a byte variable or memory location
+	Internal             = "E900" // Unexpected failure in the compiler itself
+
+	// Semantic warnings
+	MissingStart = "W300" // Program with blocks but no 'start' directive
+)
+
+// Diagnostic represents a single message reported about the source code
+type Diagnostic struct {
+	Severity Severity
+	Code     string
+	Span     token.Span
+	Message  string
+	Notes    []string
+}
+
+// Error returns the diagnostic formatted as a single line, so a Diagnostic can be used as an error
+func (d *Diagnostic) Error() string {
+	if d.Code == "" {
+		return fmt.Sprintf("%s: %s: %s", d.Span.Start, d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%s: %s[%s]: %s", d.Span.Start, d.Severity, d.Code, d.Message)
+}
+
+// String returns the diagnostic followed by its notes, one per line
+func (d *Diagnostic) String() string {
+	var builder strings.Builder
+	builder.WriteString(d.Error())
+	for _, note := range d.Notes {
+		builder.WriteString("\n    note: ")
+		builder.WriteString(note)
+	}
+	return builder.String()
+}
+
+// Notef appends a note to the diagnostic and returns it
+func (d *Diagnostic) Notef(format string, args ...interface{}) *Diagnostic {
+	d.Notes = append(d.Notes, fmt.Sprintf(format, args...))
+	return d
+}
+
+// Errorf creates a new error diagnostic
+func Errorf(code string, span token.Span, format string, args ...interface{}) *Diagnostic {
+	return &Diagnostic{Severity: Error, Code: code, Span: span, Message: fmt.Sprintf(format, args...)}
+}
+
+// Warningf creates a new warning diagnostic
+func Warningf(code string, span token.Span, format string, args ...interface{}) *Diagnostic {
+	return &Diagnostic{Severity: Warning, Code: code, Span: span, Message: fmt.Sprintf(format, args...)}
+}
+
+// List is a collection of diagnostics; a List with errors can be returned as an error
+type List []*Diagnostic
+
+// Add appends diagnostics to the list
+func (l *List) Add(diagnostics ...*Diagnostic) {
+	*l = append(*l, diagnostics...)
+}
+
+// AddError appends an error to the list, converting it to a diagnostic when needed
+func (l *List) AddError(err error) {
+	switch err := err.(type) {
+	case nil:
+	case *Diagnostic:
+		l.Add(err)
+	case List:
+		l.Add(err...)
+	default:
+		l.Add(&Diagnostic{Severity: Error, Code: Internal, Message: err.Error()})
+	}
+}
+
+// HasErrors reports whether the list contains at least one error diagnostic
+func (l List) HasErrors() bool {
+	for _, d := range l {
+		if d.Severity == Error {
+			return true
+		}
+	}
+	return false
+}
+
+// Sort orders the diagnostics by file, line and column, keeping the report order for equal positions
+func (l List) Sort() {
+	sort.SliceStable(l, func(i, j int) bool {
+		a, b := l[i].Span.Start, l[j].Span.Start
+		if a.Filename != b.Filename {
+			return a.Filename < b.Filename
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+}
+
+// Err returns the list as an error if it contains errors, or nil otherwise
+func (l List) Err() error {
+	if !l.HasErrors() {
+		return nil
+	}
+	return l
+}
+
+// Error returns all diagnostics, one per line
+func (l List) Error() string {
+	lines := make([]string, len(l))
+	for i, d := range l {
+		lines[i] = d.String()
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,368 @@
+package disasm
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"cyone/internal/ast"
+	"cyone/internal/bytecode"
+	"cyone/internal/kernel"
+	"cyone/internal/token"
+)
+
+// Symbols names the variables and the blocks of a decompiled program by address, and gives the
+// source location of its statements. Words holds the addresses of the variables that hold
+// words. Kernel names the functions invoked by calls, it is the reference kernel when nil.
+type Symbols struct {
+	Variables map[uint16]string
+	Words     map[uint16]bool
+	Blocks    map[uint16]string
+	Lines     map[uint16]token.Position
+	Kernel    *kernel.Table
+}
+
+// variable returns the name of the variable of a given size stored at an address
+func (s *Symbols) variable(address uint16, word bool) (string, bool) {
+	name, exists := s.Variables[address]
+	return name, exists && s.Words[address] == word
+}
+
+// function returns the kernel function with an opcode
+func (s *Symbols) function(opcode byte) (*kernel.Function, bool) {
+	table := s.Kernel
+	if table == nil {
+		table = kernel.Default()
+	}
+	return table.Opcode(opcode)
+}
+
+// functionName returns the name of a kernel function opcode
+func (s *Symbols) functionName(opcode byte) (string, bool) {
+	if f, exists := s.function(opcode); exists {
+		return f.Name, true
+	}
+	return "", false
+}
+
+// parameterKind returns the kind of a parameter of a kernel function, or an empty kind when the
+// function or the parameter is unknown
+func (s *Symbols) parameterKind(opcode byte, index int) kernel.Kind {
+	if f, exists := s.function(opcode); exists && index < len(f.Parameters) {
+		return f.Parameters[index].Kind
+	}
+	return ""
+}
+
+// parameters converts the parameters of a call to a kernel function. Word operands passed to
+// address parameters are given as the variable stored at that address when it has a name.
+func (s *Symbols) parameters(opcode byte, operands []*Operand) []ast.Expression {
+	parameters := make([]ast.Expression, len(operands))
+	for i, operand := range operands {
+		switch operand.Tag {
+		case bytecode.OperandConstant:
+			parameters[i] = &ast.ByteValue{Value: fmt.Sprintf("0x%02X", operand.Value)}
+		case bytecode.OperandWord:
+			if name, exists := s.Variables[operand.Value]; exists && s.parameterKind(opcode, i) == kernel.Address {
+				parameters[i] = &ast.MemoryLocation{Address: name}
+			} else {
+				parameters[i] = &ast.ByteValue{Value: fmt.Sprintf("0x%04X", operand.Value)}
+			}
+		case bytecode.OperandMemory, bytecode.OperandWordMemory:
+			if name, exists := s.variable(operand.Address, operand.Tag == bytecode.OperandWordMemory); exists {
+				parameters[i] = &ast.MemoryLocation{Address: name}
+			} else {
+				parameters[i] = &ast.MemoryLocation{Address: fmt.Sprintf("0x%04X", operand.Address)}
+			}
+		default:
+			parameters[i] = operand.Expression(s)
+		}
+	}
+	return parameters
+}
+
+// Decompile reconstructs a program from an image. Every data address read or written by the
+// program is declared as a variable, named after symbols when given and after its address
+// otherwise, such as v_0006. Addresses read or written as words are declared as word variables.
+// Blocks keep their addresses and get the labels found in symbols. Symbols may be nil.
+func Decompile(img *Image, symbols *Symbols) (*ast.Program, error) {
+	if symbols == nil {
+		symbols = &Symbols{}
+	}
+	d := &decompiler{symbols: symbols, variables: make(map[uint16]string), words: make(map[uint16]bool), taken: make(map[string]bool)}
+	for _, name := range symbols.Variables {
+		d.taken[name] = true
+	}
+	for _, name := range symbols.Blocks {
+		d.taken[name] = true
+	}
+	d.names = &Symbols{Variables: d.variables, Words: d.words, Kernel: symbols.Kernel}
+	program := &ast.Program{}
+	for _, record := range img.Records {
+		if record.Err != nil {
+			return nil, fmt.Errorf("record at 0x%04X: %v", record.Address, record.Err)
+		}
+		for _, statement := range record.Statements {
+			d.collect(statement)
+		}
+	}
+
+	addresses := make([]int, 0, len(d.variables))
+	for address := range d.variables {
+		addresses = append(addresses, int(address))
+	}
+	sort.Ints(addresses)
+	for _, address := range addresses {
+		declaration := &ast.VariableDeclaration{Name: d.variables[uint16(address)], Address: fmt.Sprintf("0x%04X", address)}
+		if d.words[uint16(address)] {
+			declaration.Type = "word"
+		}
+		program.Variables = append(program.Variables, declaration)
+	}
+
+	for _, record := range img.Records {
+		switch record.Opcode {
+		case token.OP_START:
+			start := &ast.StartBlock{}
+			start.Label, start.Address = d.target(record.Target)
+			program.Start = start
+		case token.OP_BLOCK:
+			statements, err := d.statements(record.Statements)
+			if err != nil {
+				return nil, err
+			}
+			program.Blocks = append(program.Blocks, &ast.Block{
+				Name:       symbols.Blocks[record.Address],
+				Address:    fmt.Sprintf("0x%04X", record.Address),
+				Statements: statements,
+			})
+		}
+	}
+	return program, nil
+}
+
+// Compare reports the first difference between the records of two images
+func (img *Image) Compare(other *Image) error {
+	for i := 0; i < len(img.Records) || i < len(other.Records); i++ {
+		if i >= len(img.Records) {
+			return fmt.Errorf("unexpected record at 0x%04X", other.Records[i].Address)
+		}
+		if i >= len(other.Records) {
+			return fmt.Errorf("missing record at 0x%04X", img.Records[i].Address)
+		}
+		expected, actual := img.Records[i], other.Records[i]
+		if expected.Address != actual.Address {
+			return fmt.Errorf("record at 0x%04X found at 0x%04X", expected.Address, actual.Address)
+		}
+		if bytes.Equal(expected.Bytes, actual.Bytes) {
+			continue
+		}
+		for offset := 0; ; offset++ {
+			if offset >= len(expected.Bytes) || offset >= len(actual.Bytes) {
+				return fmt.Errorf("record at 0x%04X is %d bytes long instead of %d", expected.Address, len(actual.Bytes), len(expected.Bytes))
+			}
+			if expected.Bytes[offset] != actual.Bytes[offset] {
+				address := int(expected.Address) + offset
+				return fmt.Errorf("0x%04X: found 0x%02X instead of 0x%02X", address, actual.Bytes[offset], expected.Bytes[offset])
+			}
+		}
+	}
+	return nil
+}
+
+// decompiler converts decoded statements to syntax tree nodes
+type decompiler struct {
+	symbols   *Symbols
+	variables map[uint16]string // Name of every data address used by the program
+	words     map[uint16]bool   // Addresses used as words
+	names     *Symbols          // Symbols naming every data address used by the program
+	taken     map[string]bool   // Names given by symbols or already generated
+}
+
+// collect declares the data addresses used by a statement and the statements nested in it
+func (d *decompiler) collect(statement *Statement) {
+	if statement.Opcode == token.OP_IDENTIFIER {
+		d.declare(statement.Target, statement.Word)
+	}
+	d.collectParameters(statement.Function, statement.Parameters)
+	operands := append([]*Operand{statement.Operand}, statement.Parameters...)
+	for len(operands) > 0 {
+		operand := operands[0]
+		operands = operands[1:]
+		if operand == nil {
+			continue
+		}
+		switch operand.Tag {
+		case bytecode.OperandMemory, bytecode.OperandWordMemory:
+			d.declare(operand.Address, operand.Tag == bytecode.OperandWordMemory)
+		case bytecode.OperandCall:
+			d.collectParameters(operand.Function, operand.Parameters)
+			operands = append(operands, operand.Parameters...)
+		}
+		operands = append(operands, operand.Left, operand.Right)
+	}
+	for _, branch := range []*Branch{statement.Then, statement.Else, statement.Body} {
+		if branch != nil {
+			for _, nested := range branch.Statements {
+				d.collect(nested)
+			}
+		}
+	}
+}
+
+// collectParameters declares the variables passed to the address parameters of a call, so that
+// they are named in the call
+func (d *decompiler) collectParameters(function byte, parameters []*Operand) {
+	for i, parameter := range parameters {
+		if _, exists := d.symbols.Variables[parameter.Value]; exists && parameter.Tag == bytecode.OperandWord && d.symbols.parameterKind(function, i) == kernel.Address {
+			d.declare(parameter.Value, d.symbols.Words[parameter.Value])
+		}
+	}
+}
+
+// declare names a data address. An address used both as a byte and as a word is declared as a
+// word variable, and its bytes are accessed with mem[...]. A generated name that is already
+// given by symbols gets a numbered suffix.
+func (d *decompiler) declare(address uint16, word bool) {
+	if word {
+		d.words[address] = true
+	}
+	if _, exists := d.variables[address]; exists {
+		return
+	}
+	name, exists := d.symbols.Variables[address]
+	if !exists {
+		name = fmt.Sprintf("v_%04X", address)
+		for suffix := 1; d.taken[name]; suffix++ {
+			name = fmt.Sprintf("v_%04X_%d", address, suffix)
+		}
+		d.taken[name] = true
+	}
+	d.variables[address] = name
+}
+
+// target returns the label of the block at a code address, or the address itself when the
+// block has no label
+func (d *decompiler) target(address uint16) (string, string) {
+	if name, exists := d.symbols.Blocks[address]; exists {
+		return name, ""
+	}
+	return "", fmt.Sprintf("0x%04X", address)
+}
+
+// statements converts a list of statements
+func (d *decompiler) statements(statements []*Statement) ([]ast.Statement, error) {
+	converted := make([]ast.Statement, 0, len(statements))
+	for _, statement := range statements {
+		s, err := d.statement(statement)
+		if err != nil {
+			return nil, err
+		}
+		converted = append(converted, s)
+	}
+	return converted, nil
+}
+
+// statement converts a statement and the statements nested in it
+func (d *decompiler) statement(statement *Statement) (ast.Statement, error) {
+	switch statement.Opcode {
+	case token.OP_IDENTIFIER:
+		expression, err := d.expression(statement.Operand, statement.Address)
+		if err != nil {
+			return nil, err
+		}
+		if name, exists := d.names.variable(statement.Target, statement.Word); exists {
+			return &ast.Assignment{VariableName: name, Expression: expression}, nil
+		}
+		return &ast.MemoryAssignment{MemoryAddress: &ast.Constant{Value: fmt.Sprintf("0x%04X", statement.Target)}, Value: expression}, nil
+	case token.OP_GOTO:
+		g := &ast.Goto{}
+		g.Label, g.Address = d.target(statement.Target)
+		return g, nil
+	case token.OP_CALL:
+		name, err := d.call(statement.Function, statement.Parameters, statement.Address)
+		if err != nil {
+			return nil, err
+		}
+		return &ast.Call{FunctionName: name, Parameters: d.names.parameters(statement.Function, statement.Parameters)}, nil
+	case token.OP_WHILE:
+		condition, err := d.expression(statement.Operand, statement.Address)
+		if err != nil {
+			return nil, err
+		}
+		body, err := d.statements(statement.Body.Statements)
+		if err != nil {
+			return nil, err
+		}
+		return &ast.WhileStatement{ConditionExpression: condition, Body: &ast.Block{Statements: body}}, nil
+	case token.OP_IF:
+		condition, err := d.expression(statement.Operand, statement.Address)
+		if err != nil {
+			return nil, err
+		}
+		then, err := d.statements(statement.Then.Statements)
+		if err != nil {
+			return nil, err
+		}
+		otherwise, err := d.statements(statement.Else.Statements)
+		if err != nil {
+			return nil, err
+		}
+		s := &ast.IfStatement{ConditionExpression: condition, ThenBlock: &ast.Block{Statements: then}}
+		// An if alone in an else branch is the encoding of 'else if'
+		if elseIf, isIf := singleIf(otherwise); isIf {
+			s.ElseIf = elseIf
+		} else if len(otherwise) > 0 {
+			s.ElseBlock = &ast.Block{Statements: otherwise}
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("0x%04X: unknown statement opcode 0x%02X", statement.Address, statement.Opcode)
+	}
+}
+
+// expression converts the operand of the statement at address, checking that the calls it
+// makes can be written in source code
+func (d *decompiler) expression(operand *Operand, address uint16) (ast.Expression, error) {
+	operands := []*Operand{operand}
+	for len(operands) > 0 {
+		operand := operands[0]
+		operands = operands[1:]
+		if operand == nil {
+			continue
+		}
+		if operand.Tag == bytecode.OperandCall {
+			if _, err := d.call(operand.Function, operand.Parameters, address); err != nil {
+				return nil, err
+			}
+		}
+		operands = append(operands, operand.Left, operand.Right)
+	}
+	return operand.Expression(d.names), nil
+}
+
+// call returns the name of the kernel function called by the statement at address, checking
+// that its parameters can be written in source code
+func (d *decompiler) call(function byte, parameters []*Operand, address uint16) (string, error) {
+	name, exists := d.symbols.functionName(function)
+	if !exists {
+		return "", fmt.Errorf("0x%04X: unknown kernel function 0x%02X", address, function)
+	}
+	for _, parameter := range parameters {
+		switch parameter.Tag {
+		case bytecode.OperandConstant, bytecode.OperandWord, bytecode.OperandMemory, bytecode.OperandWordMemory:
+		default:
+			return "", fmt.Errorf("0x%04X: parameters of '%s' can only be variables and constants in source code", address, name)
+		}
+	}
+	return name, nil
+}
+
+// singleIf returns the if statement of a list made of it alone
+func singleIf(statements []ast.Statement) (*ast.IfStatement, bool) {
+	if len(statements) != 1 {
+		return nil, false
+	}
+	s, isIf := statements[0].(*ast.IfStatement)
+	return s, isIf
+}
@@ -0,0 +1,225 @@
+package disasm_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"cyone/internal/bytecode"
+	"cyone/internal/compiler"
+	"cyone/internal/disasm"
+	"cyone/internal/format"
+	"cyone/internal/kernel"
+	"cyone/internal/symbols"
+)
+
+// testKernel extends the reference kernel with functions that return values and take address
+// and word parameters
+func testKernel(t *testing.T) *kernel.Table {
+	t.Helper()
+	functions := kernel.Default().Functions()
+	functions = append(functions,
+		&kernel.Function{Name: "READ_ADC", Opcode: 0x10, Parameters: []kernel.Parameter{{Name: "channel", Kind: kernel.Byte}}, Returns: kernel.Byte},
+		&kernel.Function{Name: "FILL", Opcode: 0x20, Parameters: []kernel.Parameter{{Name: "buffer", Kind: kernel.Address}, {Name: "count", Kind: kernel.Word}, {Name: "value", Kind: kernel.Byte}}},
+	)
+	table, err := kernel.New("test", functions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+var roundTripPrograms = []struct {
+	name   string
+	source string
+}{
+	{"operators", `
+loc a at 0x0000;
+loc b at 0x0001;
+loc r at 0x0002;
+start at 0x0100;
+block 0x0100 {
+    r = a + b - a * b / 0x03 % 0x05;
+    r = (a & b) | (a ^ b) << 0x01 >> 0x02;
+    r = -a + !b + ~a;
+    r = a == b || a != b && a > b;
+    r = a < b && a >= b || a <= b;
+    mem[0x0010] = mem[0x0011] + 0x01;
+}
+`},
+	{"branches", `
+loc i at 0x0000;
+loc s at 0x0001;
+start at 0x0100;
+block 0x0100 {
+    i = 0x00;
+    while (i < 0x05) {
+        s = s + i;
+        i = i + 0x01;
+    }
+    if (s == 0x0A) {
+        s = 0x01;
+    } else if (s == 0x0B) {
+        s = 0x02;
+    } else {
+        s = 0x03;
+    }
+    if (i) {
+        goto 0x0200;
+    }
+}
+block 0x0200 {
+    i = 0x00;
+}
+`},
+	{"blocks", `
+loc x at 0x0000;
+start at main;
+block main {
+    x = 0x01;
+    goto loop;
+}
+block loop at 0x0300 {
+    x = x + 0x01;
+    if (x < 0x10) {
+        goto loop;
+    }
+    goto done;
+}
+block done {
+    x = 0x00;
+}
+block 0x0400 {
+    goto main;
+}
+`},
+	{"words", `
+loc x at 0x0000;
+loc ptr at 0x0010 : word;
+loc sum at 0x0012 : word;
+start at main;
+block main {
+    x = 0xFF;
+    ptr = 0x1234;
+    sum = sum + x;
+    ptr = ptr + 0x0100;
+    x = ptr == 0x1234;
+    x = 0x0005;
+    mem[0x0004] = ptr > 0x1000;
+}
+`},
+	{"calls", `
+loc x at 0x0000;
+loc buf at 0x0040;
+loc count at 0x0042 : word;
+start at main;
+block main {
+    x = call READ_ADC (0x02);
+    x = call READ_ADC (x) + 0x01;
+    if (call READ_ADC (0x01) == 0x01 && x) {
+        call SET_COLOR (x);
+    }
+    call FILL (buf, 0x0020, 0x07);
+    call FILL (0x0050, count, x);
+    call DRAW_RECTANGLE (0x10, 0x10, x, 0x05);
+}
+`},
+}
+
+// compile compiles a source and fails the test on any error diagnostic
+func compile(t *testing.T, source string, options bytecode.Options) (*symbols.File, []bytecode.Bytecode) {
+	t.Helper()
+	program, bytecodes, diagnostics := compiler.Compile("test.cyo", source, options)
+	if diagnostics.HasErrors() {
+		for _, d := range diagnostics {
+			t.Log(d.String())
+		}
+		t.Fatalf("source does not compile:\n%s", source)
+	}
+	return symbols.New("test.cyo", program, bytecodes), bytecodes
+}
+
+// hex returns the Intel HEX image of bytecode
+func hex(bytecodes []bytecode.Bytecode) string {
+	return strings.Join(bytecode.GenerateIntelHex(bytecodes), "\n") + "\n"
+}
+
+func TestDecompileRoundTrip(t *testing.T) {
+	options := bytecode.DefaultOptions()
+	options.Kernel = testKernel(t)
+	for _, test := range roundTripPrograms {
+		for _, named := range []bool{false, true} {
+			name := test.name
+			if named {
+				name += "/symbols"
+			}
+			t.Run(name, func(t *testing.T) {
+				file, bytecodes := compile(t, test.source, options)
+				image, err := disasm.ReadIntelHex(strings.NewReader(hex(bytecodes)))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				names := &disasm.Symbols{}
+				if named {
+					var buffer bytes.Buffer
+					if err := file.Write(&buffer); err != nil {
+						t.Fatal(err)
+					}
+					read, err := symbols.Read(&buffer)
+					if err != nil {
+						t.Fatal(err)
+					}
+					names = read.Disasm()
+				}
+				names.Kernel = options.Kernel
+
+				program, err := disasm.Decompile(image, names)
+				if err != nil {
+					t.Fatal(err)
+				}
+				source := format.Program(program)
+				_, recompiled := compile(t, source, options)
+				other, err := disasm.ReadIntelHex(strings.NewReader(hex(recompiled)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := image.Compare(other); err != nil {
+					t.Fatalf("%v in reconstructed source:\n%s", err, source)
+				}
+			})
+		}
+	}
+}
+
+// TestDecompileNameCollision checks that generated names do not reuse the names of symbols
+func TestDecompileNameCollision(t *testing.T) {
+	options := bytecode.DefaultOptions()
+	file, bytecodes := compile(t, `
+loc v_0002 at 0x0005;
+start at main;
+block main {
+    v_0002 = 0x01;
+    mem[0x0002] = 0x02;
+}
+`, options)
+	names := file.Disasm()
+	names.Kernel = options.Kernel
+	image := disasm.DecodeBytecode(bytecodes)
+	program, err := disasm.Decompile(image, names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, variable := range program.Variables {
+		if seen[variable.Name] {
+			t.Fatalf("variable %s is declared twice", variable.Name)
+		}
+		seen[variable.Name] = true
+	}
+	source := format.Program(program)
+	_, recompiled := compile(t, source, options)
+	if err := image.Compare(disasm.DecodeBytecode(recompiled)); err != nil {
+		t.Fatalf("%v in reconstructed source:\n%s", err, source)
+	}
+}
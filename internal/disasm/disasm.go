@@ -0,0 +1,459 @@
+// Package disasm decodes program images, read from Intel HEX files or produced by the compiler,
+// back into start vector and block records, statements and expression operands, following the
+// format documented in docs/bytecode.md. Decoded images can be printed as an annotated listing.
+package disasm
+
+import (
+	"fmt"
+	"io"
+
+	"cyone/internal/bytecode"
+	"cyone/internal/token"
+)
+
+// memorySize is the size of the code memory
+const memorySize = 0x10000
+
+// Image is a decoded program image
+type Image struct {
+	Records []*Record // In address order
+}
+
+// Record is a start vector or a block found in code memory. Bytes that do not start a known
+// record are kept as a record with an error, up to the end of the contiguous data holding them.
+type Record struct {
+	Address    uint16
+	Opcode     byte         // OP_START or OP_BLOCK
+	Bytes      []byte       // Raw bytes of the record, starting with the opcode
+	Target     uint16       // First block of a start vector
+	Statements []*Statement // Statements of a block
+	Err        error        // Error found while decoding, the statements are then incomplete
+}
+
+// Statement is a statement of a block
+type Statement struct {
+	Address    uint16
+	Length     int
+	Opcode     byte       // OP_IDENTIFIER for assignments, OP_IF, OP_WHILE, OP_GOTO or OP_CALL
+	Target     uint16     // Data address written by an assignment, or code address of a goto
+	Word       bool       // Whether an assignment stores a word
+	Function   byte       // Kernel function of a call
+	Operand    *Operand   // Value of an assignment, or condition of an if or a while
+	Parameters []*Operand // Parameters of a call
+	Then, Else *Branch    // Branches of an if
+	Body       *Branch    // Body of a while
+}
+
+// Branch is a list of statements between braces, inside an if or a while
+type Branch struct {
+	Address    uint16 // Branch marker of an if, or opening brace of a while
+	Statements []*Statement
+	Close      uint16 // Closing brace
+}
+
+// Operand is an expression operand
+type Operand struct {
+	Tag         byte   // One of the bytecode.Operand* tags
+	Address     uint16 // Data address read by a memory or word memory operand
+	Value       uint16 // Value of a constant or word operand
+	Operator    byte   // Operator opcode of binary, unary and logical operands
+	Left, Right *Operand
+	Function    byte       // Kernel function of a call operand
+	Parameters  []*Operand // Parameters of a call operand
+	Length      int        // Length in bytes of the operand, including nested operands
+}
+
+// ReadIntelHex reads an Intel HEX file, verifying the checksum of every line, and decodes the
+// image it holds
+func ReadIntelHex(r io.Reader) (*Image, error) {
+	records, err := bytecode.ParseIntelHex(r)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(records), nil
+}
+
+// Decode reconstructs the records of an image from the data records of an Intel HEX file
+func Decode(records []bytecode.Record) *Image {
+	d := &decoder{}
+	for _, record := range records {
+		for i, b := range record.Data {
+			address := int(record.Address) + i
+			if address < memorySize {
+				d.code[address] = b
+				d.loaded[address] = true
+			}
+		}
+	}
+	return d.decode()
+}
+
+// DecodeBytecode reconstructs the records of an image produced by the compiler
+func DecodeBytecode(bytecodes []bytecode.Bytecode) *Image {
+	records := make([]bytecode.Record, 0, len(bytecodes))
+	for _, bc := range bytecodes {
+		records = append(records, bytecode.Record{Address: bc.Address, Data: append([]byte{bc.Opcode}, bc.Operands...)})
+	}
+	return Decode(records)
+}
+
+// Block returns the block record that starts at address
+func (img *Image) Block(address uint16) (*Record, bool) {
+	for _, record := range img.Records {
+		if record.Opcode == token.OP_BLOCK && record.Address == address && record.Err == nil {
+			return record, true
+		}
+	}
+	return nil, false
+}
+
+// Start returns the start vector of the image
+func (img *Image) Start() (*Record, bool) {
+	for _, record := range img.Records {
+		if record.Opcode == token.OP_START && record.Err == nil {
+			return record, true
+		}
+	}
+	return nil, false
+}
+
+// decoder reads records from code memory
+type decoder struct {
+	code   [memorySize]byte
+	loaded [memorySize]bool
+}
+
+// decode finds the records of every contiguous run of loaded code memory
+func (d *decoder) decode() *Image {
+	img := &Image{}
+	for pc := 0; pc < memorySize; {
+		if !d.loaded[pc] {
+			pc++
+			continue
+		}
+		record := d.record(pc)
+		img.Records = append(img.Records, record)
+		pc += len(record.Bytes)
+	}
+	return img
+}
+
+// record decodes the record that starts at pc
+func (d *decoder) record(pc int) *Record {
+	record := &Record{Address: uint16(pc), Opcode: d.code[pc]}
+	switch record.Opcode {
+	case token.OP_START:
+		record.Bytes = d.contiguous(pc, pc+4)
+		if pc != 0x0000 {
+			record.Err = fmt.Errorf("start vector found at 0x%04X instead of 0x0000", pc)
+			return record
+		}
+		record.Target, record.Err = d.word(pc + 1)
+		if record.Err == nil {
+			record.Err = d.expect(pc+3, token.OP_EOF)
+		}
+	case token.OP_BLOCK:
+		length, err := d.word(pc + 1)
+		if err != nil {
+			record.Bytes, record.Err = d.contiguous(pc, memorySize), err
+			return record
+		}
+		end := pc + 3 + int(length)
+		record.Bytes = d.contiguous(pc, end)
+		if len(record.Bytes) != end-pc {
+			record.Err = fmt.Errorf("length prefix 0x%04X runs past the end of the image", length)
+			return record
+		}
+		if record.Err = d.expect(pc+3, token.OP_LBRACE); record.Err != nil {
+			return record
+		}
+		var close int
+		record.Statements, close, record.Err = d.statements(pc+4, end-1)
+		if record.Err == nil && close != end-1 {
+			record.Err = fmt.Errorf("0x%04X: block closes at 0x%04X but its length prefix ends it at 0x%04X", close, close, end-1)
+		}
+	default:
+		record.Bytes = d.contiguous(pc, memorySize)
+		record.Err = fmt.Errorf("0x%04X: unknown record opcode 0x%02X", pc, record.Opcode)
+	}
+	return record
+}
+
+// contiguous returns the loaded bytes from start up to end, stopping at the first byte that
+// was not loaded
+func (d *decoder) contiguous(start, end int) []byte {
+	stop := start
+	for stop < end && stop < memorySize && d.loaded[stop] {
+		stop++
+	}
+	return d.code[start:stop]
+}
+
+// statements decodes statements up to the closing brace that ends them, which must not come
+// after limit, and returns its address
+func (d *decoder) statements(pc, limit int) ([]*Statement, int, error) {
+	var statements []*Statement
+	for {
+		opcode, err := d.byte(pc)
+		if err != nil {
+			return statements, pc, err
+		}
+		if opcode == token.OP_RBRACE {
+			return statements, pc, nil
+		}
+		if pc >= limit {
+			return statements, pc, fmt.Errorf("0x%04X: missing closing brace", pc)
+		}
+		statement, err := d.statement(pc, limit)
+		if err != nil {
+			return statements, pc, err
+		}
+		statements = append(statements, statement)
+		pc += statement.Length
+	}
+}
+
+// statement decodes the statement at pc
+func (d *decoder) statement(pc, limit int) (*Statement, error) {
+	statement := &Statement{Address: uint16(pc), Opcode: d.code[pc]}
+	next := pc + 1
+	var err error
+	switch statement.Opcode {
+	case token.OP_IDENTIFIER:
+		if statement.Target, err = d.word(next); err != nil {
+			return nil, err
+		}
+		next += 2
+		// A colon before the assignment marks the store of a word
+		if b, err := d.byte(next); err == nil && b == token.OP_COLON {
+			statement.Word = true
+			next++
+		}
+		if err := d.expect(next, token.OP_ASSIGN); err != nil {
+			return nil, err
+		}
+		if statement.Operand, err = d.operand(next + 1); err != nil {
+			return nil, err
+		}
+		next += 1 + statement.Operand.Length
+		if err := d.expect(next, token.OP_EOF); err != nil {
+			return nil, err
+		}
+		next++
+	case token.OP_IF:
+		if statement.Operand, next, err = d.condition(next); err != nil {
+			return nil, err
+		}
+		if statement.Then, err = d.branch(next, bytecode.BranchThen, limit); err != nil {
+			return nil, err
+		}
+		if statement.Else, err = d.branch(int(statement.Then.Close)+1, bytecode.BranchElse, limit); err != nil {
+			return nil, err
+		}
+		next = int(statement.Else.Close) + 1
+	case token.OP_WHILE:
+		if statement.Operand, next, err = d.condition(next); err != nil {
+			return nil, err
+		}
+		if err := d.expect(next, token.OP_LBRACE); err != nil {
+			return nil, err
+		}
+		statement.Body = &Branch{Address: uint16(next)}
+		var close int
+		if statement.Body.Statements, close, err = d.statements(next+1, limit); err != nil {
+			return nil, err
+		}
+		statement.Body.Close = uint16(close)
+		next = close + 1
+	case token.OP_GOTO:
+		if statement.Target, err = d.word(next); err != nil {
+			return nil, err
+		}
+		if err := d.expect(next+2, token.OP_EOF); err != nil {
+			return nil, err
+		}
+		next += 3
+	case token.OP_CALL:
+		if statement.Function, err = d.byte(next); err != nil {
+			return nil, err
+		}
+		if statement.Parameters, next, err = d.parameters(next + 1); err != nil {
+			return nil, err
+		}
+		if err := d.expect(next+1, token.OP_EOF); err != nil {
+			return nil, err
+		}
+		next += 2
+	default:
+		return nil, fmt.Errorf("0x%04X: unknown statement opcode 0x%02X", pc, statement.Opcode)
+	}
+	statement.Length = next - pc
+	return statement, nil
+}
+
+// condition decodes a parenthesized condition and returns the address that follows it
+func (d *decoder) condition(pc int) (*Operand, int, error) {
+	if err := d.expect(pc, token.OP_LPAREN); err != nil {
+		return nil, 0, err
+	}
+	condition, err := d.operand(pc + 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	next := pc + 1 + condition.Length
+	if err := d.expect(next, token.OP_RPAREN); err != nil {
+		return nil, 0, err
+	}
+	return condition, next + 1, nil
+}
+
+// parameters decodes the parenthesized parameters of a call and returns the address of the
+// closing parenthesis
+func (d *decoder) parameters(pc int) ([]*Operand, int, error) {
+	if err := d.expect(pc, token.OP_LPAREN); err != nil {
+		return nil, 0, err
+	}
+	var parameters []*Operand
+	next := pc + 1
+	for {
+		b, err := d.byte(next)
+		if err != nil {
+			return nil, 0, err
+		}
+		if b == token.OP_RPAREN {
+			return parameters, next, nil
+		}
+		parameter, err := d.operand(next)
+		if err != nil {
+			return nil, 0, err
+		}
+		parameters = append(parameters, parameter)
+		next += parameter.Length
+	}
+}
+
+// branch decodes a branch of an if: its marker, its opening brace and its statements
+func (d *decoder) branch(pc int, marker byte, limit int) (*Branch, error) {
+	if err := d.expect(pc, marker); err != nil {
+		return nil, err
+	}
+	if err := d.expect(pc+1, token.OP_LBRACE); err != nil {
+		return nil, err
+	}
+	statements, close, err := d.statements(pc+2, limit)
+	if err != nil {
+		return nil, err
+	}
+	return &Branch{Address: uint16(pc), Statements: statements, Close: uint16(close)}, nil
+}
+
+// operand decodes the expression operand at pc and its nested operands
+func (d *decoder) operand(pc int) (*Operand, error) {
+	tag, err := d.byte(pc)
+	if err != nil {
+		return nil, err
+	}
+	operand := &Operand{Tag: tag}
+	next := pc + 1
+	switch tag {
+	case bytecode.OperandMemory, bytecode.OperandWordMemory:
+		if operand.Address, err = d.word(next); err != nil {
+			return nil, err
+		}
+		next += 2
+	case bytecode.OperandConstant:
+		value, err := d.byte(next)
+		if err != nil {
+			return nil, err
+		}
+		operand.Value = uint16(value)
+		next++
+	case bytecode.OperandWord:
+		if operand.Value, err = d.word(next); err != nil {
+			return nil, err
+		}
+		next += 2
+	case bytecode.OperandBinary, bytecode.OperandLogical:
+		if operand.Operator, err = d.byte(next); err != nil {
+			return nil, err
+		}
+		logical := operand.Operator == token.OP_AND || operand.Operator == token.OP_OR
+		if _, err := bytecode.EvaluateBinaryValue(operand.Operator, bytecode.Value{}, bytecode.Value{}); err != nil || logical != (tag == bytecode.OperandLogical) {
+			return nil, fmt.Errorf("0x%04X: invalid operator 0x%02X for operand tag 0x%02X", next, operand.Operator, tag)
+		}
+		if operand.Left, err = d.operand(next + 1); err != nil {
+			return nil, err
+		}
+		next += 1 + operand.Left.Length
+		var length byte
+		if tag == bytecode.OperandLogical {
+			if length, err = d.byte(next); err != nil {
+				return nil, err
+			}
+			next++
+		}
+		if operand.Right, err = d.operand(next); err != nil {
+			return nil, err
+		}
+		if tag == bytecode.OperandLogical && int(length) != operand.Right.Length {
+			return nil, fmt.Errorf("0x%04X: right operand length is 0x%02X but the operand is %d bytes long", next-1, length, operand.Right.Length)
+		}
+		next += operand.Right.Length
+	case bytecode.OperandUnary:
+		if operand.Operator, err = d.byte(next); err != nil {
+			return nil, err
+		}
+		if _, err := bytecode.EvaluateUnaryValue(operand.Operator, bytecode.Value{}); err != nil {
+			return nil, fmt.Errorf("0x%04X: %v", next, err)
+		}
+		if operand.Left, err = d.operand(next + 1); err != nil {
+			return nil, err
+		}
+		next += 1 + operand.Left.Length
+	case bytecode.OperandCall:
+		if operand.Function, err = d.byte(next); err != nil {
+			return nil, err
+		}
+		if operand.Parameters, next, err = d.parameters(next + 1); err != nil {
+			return nil, err
+		}
+		next++
+	default:
+		return nil, fmt.Errorf("0x%04X: unknown operand tag 0x%02X", pc, tag)
+	}
+	operand.Length = next - pc
+	return operand, nil
+}
+
+// byte reads the byte at pc
+func (d *decoder) byte(pc int) (byte, error) {
+	if pc >= memorySize || !d.loaded[pc] {
+		return 0, fmt.Errorf("0x%04X: read past the end of the image", pc)
+	}
+	return d.code[pc], nil
+}
+
+// word reads the big-endian word at pc
+func (d *decoder) word(pc int) (uint16, error) {
+	high, err := d.byte(pc)
+	if err != nil {
+		return 0, err
+	}
+	low, err := d.byte(pc + 1)
+	if err != nil {
+		return 0, err
+	}
+	return uint16(high)<<8 | uint16(low), nil
+}
+
+// expect checks that the byte at pc holds an expected value
+func (d *decoder) expect(pc int, expected byte) error {
+	b, err := d.byte(pc)
+	if err != nil {
+		return err
+	}
+	if b != expected {
+		return fmt.Errorf("0x%04X: expected 0x%02X, found 0x%02X", pc, expected, b)
+	}
+	return nil
+}
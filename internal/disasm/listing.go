@@ -0,0 +1,202 @@
+package disasm
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"cyone/internal/ast"
+	"cyone/internal/bytecode"
+	"cyone/internal/format"
+	"cyone/internal/token"
+)
+
+// bytesPerLine is the number of raw bytes printed on each line of a listing
+const bytesPerLine = 8
+
+// Expression converts an operand to an expression. Memory operands whose address is named in
+// symbols by a variable of the same size become variables, the others become memory locations.
+// Call operands are named after the kernel of symbols, or after their opcode when the kernel
+// does not provide them.
+func (o *Operand) Expression(symbols *Symbols) ast.Expression {
+	switch o.Tag {
+	case bytecode.OperandMemory, bytecode.OperandWordMemory:
+		if name, exists := symbols.variable(o.Address, o.Tag == bytecode.OperandWordMemory); exists {
+			return &ast.Variable{Name: name}
+		}
+		return &ast.MemoryLocation{Address: fmt.Sprintf("0x%04X", o.Address)}
+	case bytecode.OperandConstant:
+		return &ast.Constant{Value: fmt.Sprintf("0x%02X", o.Value)}
+	case bytecode.OperandWord:
+		return &ast.Constant{Value: fmt.Sprintf("0x%04X", o.Value)}
+	case bytecode.OperandUnary:
+		symbol, _ := bytecode.OperatorSymbol(o.Operator)
+		return &ast.UnaryExpression{Operator: symbol, Expression: o.Left.Expression(symbols)}
+	case bytecode.OperandCall:
+		name, exists := symbols.functionName(o.Function)
+		if !exists {
+			name = fmt.Sprintf("0x%02X", o.Function)
+		}
+		return &ast.CallExpression{FunctionName: name, Parameters: symbols.parameters(o.Function, o.Parameters)}
+	default:
+		symbol, _ := bytecode.OperatorSymbol(o.Operator)
+		return &ast.BinaryExpression{LeftExpression: o.Left.Expression(symbols), Operator: symbol, RightExpression: o.Right.Expression(symbols)}
+	}
+}
+
+// WriteListing prints every record of the image with the address and the raw bytes of each
+// part, and the decoded statements in source syntax. Symbols, when not nil, name variables and
+// blocks and give the source location of statements.
+func (img *Image) WriteListing(w io.Writer, symbols *Symbols) error {
+	if symbols == nil {
+		symbols = &Symbols{}
+	}
+	l := &listing{image: img, symbols: symbols}
+	for i, record := range img.Records {
+		if i > 0 {
+			l.b.WriteString("\n")
+		}
+		l.record(record)
+	}
+	_, err := io.WriteString(w, l.b.String())
+	return err
+}
+
+// listing accumulates the lines of a listing
+type listing struct {
+	image   *Image
+	symbols *Symbols
+	current *Record // Record being printed
+	b       strings.Builder
+	depth   int
+}
+
+// line prints the raw bytes between two addresses of the current record and a text, wrapping
+// the bytes over several lines when needed
+func (l *listing) line(from, to uint16, text string) {
+	data := l.current.Bytes[from-l.current.Address : to-l.current.Address]
+	for first := true; first || len(data) > 0; first = false {
+		n := min(len(data), bytesPerLine)
+		raw := make([]string, n)
+		for i, b := range data[:n] {
+			raw[i] = fmt.Sprintf("%02X", b)
+		}
+		line := fmt.Sprintf("%04X  %-*s", from, bytesPerLine*3-1, strings.Join(raw, " "))
+		if first && text != "" {
+			line += "  " + strings.Repeat("    ", l.depth) + text
+		}
+		l.b.WriteString(strings.TrimRight(line, " ") + "\n")
+		data, from = data[n:], from+uint16(n)
+	}
+}
+
+// comment prints a line holding only a comment
+func (l *listing) comment(message string, args ...interface{}) {
+	fmt.Fprintf(&l.b, "; "+message+"\n", args...)
+}
+
+// record prints a record
+func (l *listing) record(record *Record) {
+	l.current = record
+	end := int(record.Address) + len(record.Bytes)
+	switch record.Opcode {
+	case token.OP_START:
+		l.comment("start vector")
+		if record.Err != nil {
+			break
+		}
+		l.line(record.Address, record.Address+4, fmt.Sprintf("start at 0x%04X%s", record.Target, l.target(record.Target)))
+		return
+	case token.OP_BLOCK:
+		if name, exists := l.symbols.Blocks[record.Address]; exists {
+			l.comment("block %s 0x%04X - 0x%04X (%d bytes)", name, record.Address, end-1, len(record.Bytes))
+		} else {
+			l.comment("block 0x%04X - 0x%04X (%d bytes)", record.Address, end-1, len(record.Bytes))
+		}
+		if len(record.Bytes) < 4 {
+			break
+		}
+		l.line(record.Address, record.Address+3, fmt.Sprintf("block 0x%04X, length 0x%04X", record.Address, len(record.Bytes)-3))
+		l.line(record.Address+3, record.Address+4, "{")
+		l.depth++
+		next := record.Address + 4
+		for _, statement := range record.Statements {
+			l.statement(statement)
+			next = statement.Address + uint16(statement.Length)
+		}
+		l.depth--
+		if record.Err == nil {
+			l.line(next, next+1, "}")
+			return
+		}
+		l.comment("error: %v", record.Err)
+		l.line(next, uint16(end), "")
+		return
+	}
+	if record.Err != nil {
+		l.comment("error: %v", record.Err)
+	}
+	l.line(record.Address, uint16(end), "")
+}
+
+// statement prints a statement, and the statements nested in it one level deeper
+func (l *listing) statement(statement *Statement) {
+	end := statement.Address + uint16(statement.Length)
+	if position, exists := l.symbols.Lines[statement.Address]; exists {
+		l.comment("%s", position)
+	}
+	switch statement.Opcode {
+	case token.OP_IDENTIFIER:
+		if name, exists := l.symbols.variable(statement.Target, statement.Word); exists {
+			l.line(statement.Address, end, fmt.Sprintf("%s = %s", name, l.expression(statement.Operand)))
+		} else if statement.Word {
+			l.line(statement.Address, end, fmt.Sprintf("mem[0x%04X] = %s  ; word", statement.Target, l.expression(statement.Operand)))
+		} else {
+			l.line(statement.Address, end, fmt.Sprintf("mem[0x%04X] = %s", statement.Target, l.expression(statement.Operand)))
+		}
+	case token.OP_GOTO:
+		l.line(statement.Address, end, fmt.Sprintf("goto 0x%04X%s", statement.Target, l.target(statement.Target)))
+	case token.OP_CALL:
+		call := &Operand{Tag: bytecode.OperandCall, Function: statement.Function, Parameters: statement.Parameters}
+		l.line(statement.Address, end, l.expression(call))
+	case token.OP_IF:
+		l.line(statement.Address, statement.Then.Address, fmt.Sprintf("if (%s)", l.expression(statement.Operand)))
+		l.branch(statement.Then, "then {")
+		l.branch(statement.Else, "else {")
+	case token.OP_WHILE:
+		l.line(statement.Address, statement.Body.Address, fmt.Sprintf("while (%s)", l.expression(statement.Operand)))
+		l.branch(statement.Body, "{")
+	}
+}
+
+// branch prints the opening of a branch, its statements and its closing brace
+func (l *listing) branch(branch *Branch, opening string) {
+	first := branch.Close
+	if len(branch.Statements) > 0 {
+		first = branch.Statements[0].Address
+	}
+	l.line(branch.Address, first, opening)
+	l.depth++
+	for _, statement := range branch.Statements {
+		l.statement(statement)
+	}
+	l.depth--
+	l.line(branch.Close, branch.Close+1, "}")
+}
+
+// expression prints an operand in source syntax
+func (l *listing) expression(operand *Operand) string {
+	return format.Expression(operand.Expression(l.symbols))
+}
+
+// target annotates a code address with the label of the block it starts, or as not starting a
+// block of the image
+func (l *listing) target(address uint16) string {
+	if _, exists := l.image.Block(address); !exists {
+		return "  ; not the start of a block"
+	}
+	if name, exists := l.symbols.Blocks[address]; exists {
+		return "  ; " + name
+	}
+	return ""
+}
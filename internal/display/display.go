@@ -0,0 +1,258 @@
+// Package display simulates the screen driven by the DRAW_* kernel functions. It draws on an
+// in-memory paletted framebuffer that can be saved as PNG, so the output of a program can be
+// reviewed without hardware.
+package display
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strconv"
+	"strings"
+
+	"cyone/internal/vm"
+)
+
+// Default size of the framebuffer in pixels
+const (
+	DefaultWidth  = 128
+	DefaultHeight = 64
+)
+
+// MaxSize is the largest width and height of the framebuffer. The coordinates passed to the
+// drawing functions are bytes, so larger displays could not be drawn on.
+const MaxSize = 256
+
+// DefaultPalette returns the colors selected by SET_COLOR when no palette is configured:
+// black, white, red, green, blue, yellow, cyan and magenta
+func DefaultPalette() color.Palette {
+	return color.Palette{
+		color.RGBA{0x00, 0x00, 0x00, 0xFF},
+		color.RGBA{0xFF, 0xFF, 0xFF, 0xFF},
+		color.RGBA{0xFF, 0x00, 0x00, 0xFF},
+		color.RGBA{0x00, 0xFF, 0x00, 0xFF},
+		color.RGBA{0x00, 0x00, 0xFF, 0xFF},
+		color.RGBA{0xFF, 0xFF, 0x00, 0xFF},
+		color.RGBA{0x00, 0xFF, 0xFF, 0xFF},
+		color.RGBA{0xFF, 0x00, 0xFF, 0xFF},
+	}
+}
+
+// ParsePalette converts a comma-separated list of "#RRGGBB" colors to a palette
+func ParsePalette(list string) (color.Palette, error) {
+	var palette color.Palette
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimPrefix(strings.TrimSpace(entry), "#")
+		value, err := strconv.ParseUint(entry, 16, 24)
+		if err != nil || len(entry) != 6 {
+			return nil, fmt.Errorf("invalid color '%s', expected #RRGGBB", entry)
+		}
+		palette = append(palette, color.RGBA{byte(value >> 16), byte(value >> 8), byte(value), 0xFF})
+	}
+	if len(palette) > 256 {
+		return nil, fmt.Errorf("palette has %d colors, the maximum is 256", len(palette))
+	}
+	return palette, nil
+}
+
+// ParseSize converts a size written as "WIDTHxHEIGHT", such as "128x64", to pixels. Both
+// dimensions range from 1 to MaxSize.
+func ParseSize(size string) (int, int, error) {
+	width, height, found := strings.Cut(size, "x")
+	if !found {
+		return 0, 0, fmt.Errorf("invalid display size '%s', expected WIDTHxHEIGHT", size)
+	}
+	w, err := strconv.Atoi(width)
+	if err != nil || w <= 0 {
+		return 0, 0, fmt.Errorf("invalid display width '%s'", width)
+	}
+	if w > MaxSize {
+		return 0, 0, fmt.Errorf("display width %d is larger than the maximum of %d", w, MaxSize)
+	}
+	h, err := strconv.Atoi(height)
+	if err != nil || h <= 0 {
+		return 0, 0, fmt.Errorf("invalid display height '%s'", height)
+	}
+	if h > MaxSize {
+		return 0, 0, fmt.Errorf("display height %d is larger than the maximum of %d", h, MaxSize)
+	}
+	return w, h, nil
+}
+
+// Display is a framebuffer where every pixel is an index in the palette
+type Display struct {
+	Image *image.Paletted
+	Color uint8 // Palette index used by the drawing functions
+
+	dirty bool
+}
+
+// New returns a display of the given size cleared to the first color of the palette. Drawing
+// uses the second color until SET_COLOR selects another one.
+func New(width, height int, palette color.Palette) *Display {
+	d := &Display{Image: image.NewPaletted(image.Rect(0, 0, width, height), palette)}
+	if len(palette) > 1 {
+		d.Color = 1
+	}
+	return d
+}
+
+// Register installs the DRAW_LINE, DRAW_CIRCLE, SET_COLOR and DRAW_RECTANGLE handlers of the
+// display on a registry
+func (d *Display) Register(r *vm.Registry) error {
+	handlers := map[string]vm.Function{
+		"DRAW_LINE":      d.callDrawLine,
+		"DRAW_CIRCLE":    d.callDrawCircle,
+		"SET_COLOR":      d.callSetColor,
+		"DRAW_RECTANGLE": d.callDrawRectangle,
+	}
+	for name, handler := range handlers {
+		if err := r.Register(name, handler); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Dirty reports whether anything was drawn since the last snapshot
+func (d *Display) Dirty() bool {
+	return d.dirty
+}
+
+// WritePNG encodes the framebuffer as a PNG image
+func (d *Display) WritePNG(w io.Writer) error {
+	d.dirty = false
+	return png.Encode(w, d.Image)
+}
+
+// SetColor selects the palette index used by the drawing functions
+func (d *Display) SetColor(index uint8) error {
+	if int(index) >= len(d.Image.Palette) {
+		return fmt.Errorf("color 0x%02X is outside the palette of %d colors", index, len(d.Image.Palette))
+	}
+	d.Color = index
+	return nil
+}
+
+// DrawLine draws a line between two points with Bresenham's algorithm
+func (d *Display) DrawLine(x0, y0, x1, y1 int) {
+	dx, sx := abs(x1-x0), sign(x1-x0)
+	dy, sy := -abs(y1-y0), sign(y1-y0)
+	err := dx + dy
+	for {
+		d.plot(x0, y0)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * err
+		if e2 >= dy {
+			err += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			err += dx
+			y0 += sy
+		}
+	}
+}
+
+// DrawCircle draws the outline of a circle with the midpoint algorithm
+func (d *Display) DrawCircle(cx, cy, radius int) {
+	x, y := radius, 0
+	err := 1 - radius
+	for x >= y {
+		for _, p := range [][2]int{{x, y}, {y, x}, {-y, x}, {-x, y}, {-x, -y}, {-y, -x}, {y, -x}, {x, -y}} {
+			d.plot(cx+p[0], cy+p[1])
+		}
+		y++
+		if err < 0 {
+			err += 2*y + 1
+		} else {
+			x--
+			err += 2*(y-x) + 1
+		}
+	}
+}
+
+// DrawRectangle draws the outline of a rectangle whose top-left corner is at x, y
+func (d *Display) DrawRectangle(x, y, width, height int) {
+	if width == 0 || height == 0 {
+		return
+	}
+	right, bottom := x+width-1, y+height-1
+	d.DrawLine(x, y, right, y)
+	d.DrawLine(x, bottom, right, bottom)
+	d.DrawLine(x, y, x, bottom)
+	d.DrawLine(right, y, right, bottom)
+}
+
+// plot sets a pixel to the current color, ignoring pixels outside the framebuffer
+func (d *Display) plot(x, y int) {
+	if !(image.Point{x, y}.In(d.Image.Rect)) {
+		return
+	}
+	d.Image.SetColorIndex(x, y, d.Color)
+	d.dirty = true
+}
+
+// callDrawLine handles DRAW_LINE (x0, y0, x1, y1)
+func (d *Display) callDrawLine(m *vm.Machine, args []byte) (byte, error) {
+	if err := checkArgs("DRAW_LINE", args, 4); err != nil {
+		return 0, err
+	}
+	d.DrawLine(int(args[0]), int(args[1]), int(args[2]), int(args[3]))
+	return 0, nil
+}
+
+// callDrawCircle handles DRAW_CIRCLE (x, y, radius)
+func (d *Display) callDrawCircle(m *vm.Machine, args []byte) (byte, error) {
+	if err := checkArgs("DRAW_CIRCLE", args, 3); err != nil {
+		return 0, err
+	}
+	d.DrawCircle(int(args[0]), int(args[1]), int(args[2]))
+	return 0, nil
+}
+
+// callSetColor handles SET_COLOR (index)
+func (d *Display) callSetColor(m *vm.Machine, args []byte) (byte, error) {
+	if err := checkArgs("SET_COLOR", args, 1); err != nil {
+		return 0, err
+	}
+	return 0, d.SetColor(args[0])
+}
+
+// callDrawRectangle handles DRAW_RECTANGLE (x, y, width, height)
+func (d *Display) callDrawRectangle(m *vm.Machine, args []byte) (byte, error) {
+	if err := checkArgs("DRAW_RECTANGLE", args, 4); err != nil {
+		return 0, err
+	}
+	d.DrawRectangle(int(args[0]), int(args[1]), int(args[2]), int(args[3]))
+	return 0, nil
+}
+
+// checkArgs returns an error unless a function received the expected number of arguments
+func checkArgs(name string, args []byte, expected int) error {
+	if len(args) != expected {
+		return fmt.Errorf("%s expects %d arguments, got %d", name, expected, len(args))
+	}
+	return nil
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func sign(x int) int {
+	switch {
+	case x < 0:
+		return -1
+	case x > 0:
+		return 1
+	}
+	return 0
+}
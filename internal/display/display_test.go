@@ -0,0 +1,321 @@
+package display
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+
+	"cyone/internal/bytecode"
+	"cyone/internal/compiler"
+	"cyone/internal/vm"
+)
+
+// draw runs statements on a display of the given size with the default palette
+func draw(t *testing.T, width, height int, statements string) (*Display, error) {
+	t.Helper()
+	source := "start at 0x0100;\nblock 0x0100 {\n" + statements + "\n}\n"
+	_, bytecodes, diagnostics := compiler.Compile("test.cyo", source, bytecode.DefaultOptions())
+	if diagnostics.HasErrors() {
+		t.Fatalf("source does not compile: %v", diagnostics)
+	}
+	d := New(width, height, DefaultPalette())
+	registry := vm.NewRegistry(nil)
+	if err := d.Register(registry); err != nil {
+		t.Fatal(err)
+	}
+	m := vm.NewMachine(registry)
+	m.MaxSteps = 1000
+	m.LoadBytecode(bytecodes)
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return d, m.Run()
+}
+
+// pixels returns the framebuffer as one string per row, '.' for the background color and the
+// palette index for the others
+func pixels(d *Display) []string {
+	bounds := d.Image.Bounds()
+	rows := make([]string, bounds.Dy())
+	for y := range rows {
+		var row strings.Builder
+		for x := 0; x < bounds.Dx(); x++ {
+			if index := d.Image.ColorIndexAt(x, y); index == 0 {
+				row.WriteByte('.')
+			} else {
+				row.WriteByte("0123456789ABCDEF"[index])
+			}
+		}
+		rows[y] = row.String()
+	}
+	return rows
+}
+
+func TestDraw(t *testing.T) {
+	tests := []struct {
+		name       string
+		statements string
+		expected   []string
+	}{
+		{
+			name:       "horizontal and vertical lines",
+			statements: "call DRAW_LINE (0x01, 0x01, 0x05, 0x01); call DRAW_LINE (0x00, 0x05, 0x00, 0x03);",
+			expected: []string{
+				".......",
+				".11111.",
+				".......",
+				"1......",
+				"1......",
+				"1......",
+				".......",
+			},
+		},
+		{
+			name:       "diagonal lines in both directions",
+			statements: "call DRAW_LINE (0x00, 0x00, 0x06, 0x06); call DRAW_LINE (0x06, 0x00, 0x00, 0x03);",
+			expected: []string{
+				"1.....1",
+				".1..11.",
+				"..11...",
+				"11.1...",
+				"....1..",
+				".....1.",
+				"......1",
+			},
+		},
+		{
+			name:       "single point line",
+			statements: "call DRAW_LINE (0x03, 0x03, 0x03, 0x03);",
+			expected: []string{
+				".......",
+				".......",
+				".......",
+				"...1...",
+				".......",
+				".......",
+				".......",
+			},
+		},
+		{
+			name:       "circle",
+			statements: "call DRAW_CIRCLE (0x03, 0x03, 0x02);",
+			expected: []string{
+				".......",
+				"..111..",
+				".1...1.",
+				".1...1.",
+				".1...1.",
+				"..111..",
+				".......",
+			},
+		},
+		{
+			name:       "circle of radius zero",
+			statements: "call DRAW_CIRCLE (0x01, 0x05, 0x00);",
+			expected: []string{
+				".......",
+				".......",
+				".......",
+				".......",
+				".......",
+				".1.....",
+				".......",
+			},
+		},
+		{
+			name:       "rectangle",
+			statements: "call DRAW_RECTANGLE (0x01, 0x02, 0x05, 0x03);",
+			expected: []string{
+				".......",
+				".......",
+				".11111.",
+				".1...1.",
+				".11111.",
+				".......",
+				".......",
+			},
+		},
+		{
+			name:       "rectangle of one pixel",
+			statements: "call DRAW_RECTANGLE (0x02, 0x02, 0x01, 0x01);",
+			expected: []string{
+				".......",
+				".......",
+				"..1....",
+				".......",
+				".......",
+				".......",
+				".......",
+			},
+		},
+		{
+			name:       "zero-size rectangles",
+			statements: "call DRAW_RECTANGLE (0x01, 0x01, 0x00, 0x03); call DRAW_RECTANGLE (0x01, 0x01, 0x03, 0x00);",
+			expected: []string{
+				".......",
+				".......",
+				".......",
+				".......",
+				".......",
+				".......",
+				".......",
+			},
+		},
+		{
+			name: "colors, the background color erases",
+			statements: "call SET_COLOR (0x02); call DRAW_LINE (0x00, 0x00, 0x06, 0x00);" +
+				"call SET_COLOR (0x07); call DRAW_LINE (0x00, 0x06, 0x06, 0x06);" +
+				"call SET_COLOR (0x00); call DRAW_LINE (0x03, 0x00, 0x03, 0x06);",
+			expected: []string{
+				"222.222",
+				".......",
+				".......",
+				".......",
+				".......",
+				".......",
+				"777.777",
+			},
+		},
+		{
+			name: "clipping at the edges",
+			statements: "call DRAW_LINE (0x04, 0x00, 0xFF, 0x00); call DRAW_CIRCLE (0x00, 0x06, 0x02);" +
+				"call DRAW_RECTANGLE (0x05, 0x03, 0x0A, 0x0A); call DRAW_LINE (0x10, 0x10, 0xFF, 0xFF);",
+			expected: []string{
+				"....111",
+				".......",
+				".......",
+				".....11",
+				"11...1.",
+				"..1..1.",
+				"..1..1.",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d, err := draw(t, 7, 7, test.statements)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := pixels(d)
+			if strings.Join(got, "\n") != strings.Join(test.expected, "\n") {
+				t.Errorf("got\n%s\nexpected\n%s", strings.Join(got, "\n"), strings.Join(test.expected, "\n"))
+			}
+		})
+	}
+}
+
+func TestSetColorOutsidePalette(t *testing.T) {
+	_, err := draw(t, 4, 4, "call SET_COLOR (0x08);")
+	if err == nil || !strings.Contains(err.Error(), "color 0x08 is outside the palette of 8 colors") {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestWritePNG(t *testing.T) {
+	d, err := draw(t, 5, 3, "call SET_COLOR (0x03); call DRAW_LINE (0x00, 0x01, 0x04, 0x01);")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.Dirty() {
+		t.Errorf("display is not dirty after drawing")
+	}
+	var buffer bytes.Buffer
+	if err := d.WritePNG(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	if d.Dirty() {
+		t.Errorf("display is still dirty after a snapshot")
+	}
+	decoded, err := png.Decode(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := decoded.Bounds().Size(); size.X != 5 || size.Y != 3 {
+		t.Fatalf("image is %dx%d, expected 5x3", size.X, size.Y)
+	}
+	palette := DefaultPalette()
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 5; x++ {
+			expected := palette[0]
+			if y == 1 {
+				expected = palette[3]
+			}
+			if got := color.RGBAModel.Convert(decoded.At(x, y)); got != expected {
+				t.Errorf("pixel %d,%d is %v, expected %v", x, y, got, expected)
+			}
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		size          string
+		width, height int
+		err           string
+	}{
+		{"128x64", 128, 64, ""},
+		{"1x256", 1, 256, ""},
+		{"128", 0, 0, "invalid display size '128', expected WIDTHxHEIGHT"},
+		{"x64", 0, 0, "invalid display width ''"},
+		{"0x64", 0, 0, "invalid display width '0'"},
+		{"-1x64", 0, 0, "invalid display width '-1'"},
+		{"128x", 0, 0, "invalid display height ''"},
+		{"128x0", 0, 0, "invalid display height '0'"},
+		{"257x64", 0, 0, "display width 257 is larger than the maximum of 256"},
+		{"128x1000", 0, 0, "display height 1000 is larger than the maximum of 256"},
+	}
+	for _, test := range tests {
+		t.Run(test.size, func(t *testing.T) {
+			width, height, err := ParseSize(test.size)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("got error %v, expected %q", err, test.err)
+				}
+				return
+			}
+			if err != nil || width != test.width || height != test.height {
+				t.Errorf("got %dx%d, %v, expected %dx%d", width, height, err, test.width, test.height)
+			}
+		})
+	}
+}
+
+func TestParsePalette(t *testing.T) {
+	palette, err := ParsePalette("#000000, #FF8000,1a2b3c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := color.Palette{
+		color.RGBA{0x00, 0x00, 0x00, 0xFF},
+		color.RGBA{0xFF, 0x80, 0x00, 0xFF},
+		color.RGBA{0x1A, 0x2B, 0x3C, 0xFF},
+	}
+	if len(palette) != len(expected) {
+		t.Fatalf("got %d colors, expected %d", len(palette), len(expected))
+	}
+	for i := range expected {
+		if palette[i] != expected[i] {
+			t.Errorf("color %d is %v, expected %v", i, palette[i], expected[i])
+		}
+	}
+
+	errors := []struct {
+		list string
+		err  string
+	}{
+		{"", "invalid color '', expected #RRGGBB"},
+		{"#FFF", "invalid color 'FFF', expected #RRGGBB"},
+		{"#000000,#GG0000", "invalid color 'GG0000', expected #RRGGBB"},
+		{"#00000000", "invalid color '00000000', expected #RRGGBB"},
+		{"#000000,", "invalid color '', expected #RRGGBB"},
+		{strings.Repeat("#000000,", 256) + "#000000", "palette has 257 colors, the maximum is 256"},
+	}
+	for _, test := range errors {
+		if _, err := ParsePalette(test.list); err == nil || err.Error() != test.err {
+			t.Errorf("palette %.20q gives error %v, expected %q", test.list, err, test.err)
+		}
+	}
+}
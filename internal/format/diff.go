@@ -0,0 +1,126 @@
+package format
+
+import (
+	"fmt"
+	"strings"
+)
+
+// context is the number of unchanged lines shown around each change of a diff
+const context = 3
+
+// edit is an operation turning the old lines into the new ones
+type edit struct {
+	kind byte // ' ' keeps a line, '-' removes it, '+' inserts it
+	text string
+}
+
+// Diff returns the differences between two texts in the unified format, or an empty string when
+// they are equal
+func Diff(oldName, old, newName, new string) string {
+	if old == new {
+		return ""
+	}
+	edits := diffLines(splitLines(old), splitLines(new))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+	oldLine, newLine := 1, 1
+	for start := 0; start < len(edits); {
+		// Find the next change and the end of the hunk around it
+		first := start
+		for first < len(edits) && edits[first].kind == ' ' {
+			first++
+		}
+		if first == len(edits) {
+			break
+		}
+		hunkStart := max(start, first-context)
+		oldLine, newLine = oldLine+hunkStart-start, newLine+hunkStart-start
+		end, unchanged := first, 0
+		for end < len(edits) && unchanged <= 2*context {
+			if edits[end].kind == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+			end++
+		}
+		if unchanged > context {
+			end -= unchanged - context
+		}
+
+		oldCount, newCount := 0, 0
+		for _, e := range edits[hunkStart:end] {
+			if e.kind != '+' {
+				oldCount++
+			}
+			if e.kind != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(oldLine, oldCount), hunkRange(newLine, newCount))
+		for _, e := range edits[hunkStart:end] {
+			fmt.Fprintf(&b, "%c%s\n", e.kind, e.text)
+		}
+		oldLine, newLine = oldLine+oldCount, newLine+newCount
+		start = end
+	}
+	return b.String()
+}
+
+// hunkRange prints the start line and the number of lines of a hunk. An empty range starts at
+// the line before it.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		start--
+	}
+	if count == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// splitLines splits a text into lines without their line breaks
+func splitLines(text string) []string {
+	lines := strings.Split(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines computes the shortest edit script between two lists of lines from their longest
+// common subsequence
+func diffLines(old, new []string) []edit {
+	// common[i][j] is the length of the longest common subsequence of old[i:] and new[j:]
+	common := make([][]int, len(old)+1)
+	for i := range common {
+		common[i] = make([]int, len(new)+1)
+	}
+	for i := len(old) - 1; i >= 0; i-- {
+		for j := len(new) - 1; j >= 0; j-- {
+			if old[i] == new[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+
+	var edits []edit
+	i, j := 0, 0
+	for i < len(old) || j < len(new) {
+		switch {
+		case i < len(old) && j < len(new) && old[i] == new[j]:
+			edits = append(edits, edit{' ', old[i]})
+			i, j = i+1, j+1
+		case i < len(old) && (j == len(new) || common[i+1][j] >= common[i][j+1]):
+			edits = append(edits, edit{'-', old[i]})
+			i++
+		default:
+			edits = append(edits, edit{'+', new[j]})
+			j++
+		}
+	}
+	return edits
+}
@@ -0,0 +1,398 @@
+// Package format prints programs back to source in the canonical style used by 'cyone fmt':
+// four spaces of indentation, one statement per line, single spaces around binary operators,
+// hexadecimal literals with uppercase digits and a fixed width, aligned 'loc ... at' columns
+// and aligned trailing comments. Comments are preserved.
+package format
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"cyone/internal/ast"
+	"cyone/internal/diagnostic"
+	"cyone/internal/lexer"
+	"cyone/internal/parser"
+)
+
+// indent is the indentation of one nesting level
+const indent = "    "
+
+// Digits of the hexadecimal literals: addresses are 16-bit, values are bytes
+const (
+	addressWidth = 4
+	byteWidth    = 2
+)
+
+// Source formats a source file. The source is returned unchanged with the diagnostics when it
+// has syntax errors.
+func Source(filename, source string) (string, diagnostic.List) {
+	tokens, diagnostics := lexer.NewFileLexer(filename, source).Tokenize()
+	par := parser.NewParser(tokens)
+	program, _ := par.Parse()
+	diagnostics.Add(par.Diagnostics()...)
+	if diagnostics.HasErrors() {
+		diagnostics.Sort()
+		return source, diagnostics
+	}
+	return Program(program), diagnostics
+}
+
+// Program prints a program in the canonical style. Declarations keep their order in the source
+// and single blank lines between them are preserved.
+func Program(program *ast.Program) string {
+	var declarations []ast.Commented
+	for _, variable := range program.Variables {
+		declarations = append(declarations, variable)
+	}
+	if program.Start != nil {
+		declarations = append(declarations, program.Start)
+	}
+	for _, block := range program.Blocks {
+		declarations = append(declarations, block)
+	}
+	sort.SliceStable(declarations, func(i, j int) bool {
+		return declarations[i].Location().Start.Offset < declarations[j].Location().Start.Offset
+	})
+
+	p := &printer{}
+	widths := nameWidths(declarations)
+	for i, declaration := range declarations {
+		if i > 0 {
+			_, isBlock := declaration.(*ast.Block)
+			_, wasBlock := declarations[i-1].(*ast.Block)
+			if isBlock || wasBlock || separated(declarations[i-1], declaration) {
+				p.blank()
+			}
+		}
+		p.leading(declaration)
+		switch declaration := declaration.(type) {
+		case *ast.VariableDeclaration:
+			code := fmt.Sprintf("loc %-*s at %s", widths[declaration], declaration.Name, literal(declaration.Address, addressWidth))
+			if declaration.Type != "" {
+				code += " : " + declaration.Type
+			}
+			p.emit(code + ";")
+		case *ast.StartBlock:
+			p.emit(fmt.Sprintf("start at %s;", target(declaration.Label, declaration.Address)))
+		case *ast.Block:
+			header := "block " + declaration.Name
+			if declaration.Name == "" {
+				header += literal(declaration.Address, addressWidth)
+			} else if declaration.Address != "" {
+				header += " at " + literal(declaration.Address, addressWidth)
+			}
+			p.emit(header + " {")
+			p.body(declaration)
+			p.emit("}")
+		}
+		p.trailing(declaration)
+	}
+	if len(program.Comments) > 0 {
+		if len(declarations) > 0 && program.Comments[0].Start.Line > lastLine(declarations[len(declarations)-1])+1 {
+			p.blank()
+		}
+		for _, comment := range program.Comments {
+			p.comment(comment)
+		}
+	}
+	return p.String()
+}
+
+// nameWidths returns the width of the name column of every variable declaration: the longest
+// name among the consecutive declarations that are not separated by a blank line
+func nameWidths(declarations []ast.Commented) map[*ast.VariableDeclaration]int {
+	widths := make(map[*ast.VariableDeclaration]int)
+	var run []*ast.VariableDeclaration
+	flush := func() {
+		width := 0
+		for _, variable := range run {
+			if len(variable.Name) > width {
+				width = len(variable.Name)
+			}
+		}
+		for _, variable := range run {
+			widths[variable] = width
+		}
+		run = run[:0]
+	}
+	for i, declaration := range declarations {
+		variable, isVariable := declaration.(*ast.VariableDeclaration)
+		if !isVariable || (i > 0 && separated(declarations[i-1], declaration)) {
+			flush()
+		}
+		if isVariable {
+			run = append(run, variable)
+		}
+	}
+	flush()
+	return widths
+}
+
+// line is a line of output: code followed by an optional comment
+type line struct {
+	depth   int
+	code    string
+	comment string
+}
+
+// printer accumulates the lines of a formatted program
+type printer struct {
+	lines []line
+	depth int
+}
+
+// emit adds a line of code at the current depth
+func (p *printer) emit(code string) {
+	p.lines = append(p.lines, line{depth: p.depth, code: code})
+}
+
+// comment adds a line holding only a comment
+func (p *printer) comment(comment *ast.Comment) {
+	p.lines = append(p.lines, line{depth: p.depth, comment: strings.TrimRight(comment.Text, " \t\r")})
+}
+
+// blank adds an empty line, unless the output is empty or already ends with one
+func (p *printer) blank() {
+	if len(p.lines) > 0 && p.lines[len(p.lines)-1] != (line{}) {
+		p.lines = append(p.lines, line{})
+	}
+}
+
+// leading adds the comments placed before a node
+func (p *printer) leading(node ast.Commented) {
+	for _, comment := range node.Attached().Leading {
+		p.comment(comment)
+	}
+}
+
+// trailing adds the comment following a node to its last line
+func (p *printer) trailing(node ast.Commented) {
+	p.attach(node.Attached().Trailing)
+}
+
+// attach adds a comment to the last line, if there is one
+func (p *printer) attach(comment *ast.Comment) {
+	if comment != nil {
+		p.lines[len(p.lines)-1].comment = strings.TrimRight(comment.Text, " \t\r")
+	}
+}
+
+// body adds the comment following the opening brace of a block to the last line, then its
+// statements and closing comments one level deeper
+func (p *printer) body(block *ast.Block) {
+	p.attach(block.Opening)
+	p.depth++
+	for i, statement := range block.Statements {
+		if i > 0 && separated(block.Statements[i-1], statement) {
+			p.blank()
+		}
+		p.leading(statement)
+		p.statement(statement)
+		p.trailing(statement)
+	}
+	if len(block.Closing) > 0 {
+		if last := len(block.Statements) - 1; last >= 0 && block.Closing[0].Start.Line > lastLine(block.Statements[last])+1 {
+			p.blank()
+		}
+		for _, comment := range block.Closing {
+			p.comment(comment)
+		}
+	}
+	p.depth--
+}
+
+// statement adds the lines of a statement
+func (p *printer) statement(statement ast.Statement) {
+	switch statement := statement.(type) {
+	case *ast.Assignment:
+		p.emit(fmt.Sprintf("%s = %s;", statement.VariableName, Expression(statement.Expression)))
+	case *ast.MemoryAssignment:
+		p.emit(fmt.Sprintf("mem[%s] = %s;", address(statement.MemoryAddress), Expression(statement.Value)))
+	case *ast.Call:
+		p.emit(call(statement.FunctionName, statement.Parameters) + ";")
+	case *ast.Goto:
+		p.emit(fmt.Sprintf("goto %s;", target(statement.Label, statement.Address)))
+	case *ast.WhileStatement:
+		p.emit(fmt.Sprintf("while (%s) {", Expression(statement.ConditionExpression)))
+		p.body(statement.Body)
+		p.emit("}")
+	case *ast.IfStatement:
+		p.emit(fmt.Sprintf("if (%s) {", Expression(statement.ConditionExpression)))
+		p.body(statement.ThenBlock)
+		for statement.ElseIf != nil {
+			p.emit(p.closeBefore(statement.ThenBlock) + fmt.Sprintf("else if (%s) {", Expression(statement.ElseIf.ConditionExpression)))
+			statement = statement.ElseIf
+			p.body(statement.ThenBlock)
+		}
+		if statement.ElseBlock != nil {
+			p.emit(p.closeBefore(statement.ThenBlock) + "else {")
+			p.body(statement.ElseBlock)
+		}
+		p.emit("}")
+	}
+}
+
+// closeBefore returns the start of the line that continues a then block with 'else'. The closing
+// brace shares that line, unless a comment follows it: the brace and the comment then get their
+// own line so that the comment stays with the then block.
+func (p *printer) closeBefore(then *ast.Block) string {
+	if then.Trailing == nil {
+		return "} "
+	}
+	p.emit("}")
+	p.trailing(then)
+	return ""
+}
+
+// String returns the output, aligning the trailing comments of consecutive lines
+func (p *printer) String() string {
+	var b strings.Builder
+	for start := 0; start < len(p.lines); {
+		end := start + 1
+		width := 0
+		if p.lines[start].code != "" && p.lines[start].comment != "" {
+			for end = start; end < len(p.lines) && aligned(p.lines[start], p.lines[end]); end++ {
+				width = max(width, len(p.lines[end].code))
+			}
+		}
+		for _, l := range p.lines[start:end] {
+			if l == (line{}) {
+				b.WriteString("\n")
+				continue
+			}
+			b.WriteString(strings.Repeat(indent, l.depth))
+			switch {
+			case l.comment == "":
+				b.WriteString(l.code)
+			case l.code == "":
+				b.WriteString(l.comment)
+			default:
+				fmt.Fprintf(&b, "%-*s %s", width, l.code, l.comment)
+			}
+			b.WriteString("\n")
+		}
+		start = end
+	}
+	return b.String()
+}
+
+// aligned reports whether the trailing comment of a line is aligned with that of the first line
+// of a run: both lines hold code and a comment at the same depth
+func aligned(first, l line) bool {
+	return l.code != "" && l.comment != "" && l.depth == first.depth
+}
+
+// Expression prints an expression, adding the parentheses required by operator precedence
+func Expression(e ast.Expression) string {
+	switch e := e.(type) {
+	case *ast.Constant:
+		return constant(e.Value)
+	case *ast.Variable:
+		return e.Name
+	case *ast.MemoryLocation:
+		return fmt.Sprintf("mem[%s]", literal(e.Address, addressWidth))
+	case *ast.UnaryExpression:
+		operand := Expression(e.Expression)
+		if _, isBinary := e.Expression.(*ast.BinaryExpression); isBinary {
+			operand = "(" + operand + ")"
+		}
+		return e.Operator + operand
+	case *ast.BinaryExpression:
+		precedence := parser.Precedence(e.Operator)
+		left, right := Expression(e.LeftExpression), Expression(e.RightExpression)
+		// Operators are left associative, so a right operand of the same level needs parentheses
+		if binary, isBinary := e.LeftExpression.(*ast.BinaryExpression); isBinary && parser.Precedence(binary.Operator) < precedence {
+			left = "(" + left + ")"
+		}
+		if binary, isBinary := e.RightExpression.(*ast.BinaryExpression); isBinary && parser.Precedence(binary.Operator) <= precedence {
+			right = "(" + right + ")"
+		}
+		return fmt.Sprintf("%s %s %s", left, e.Operator, right)
+	case *ast.CallExpression:
+		return call(e.FunctionName, e.Parameters)
+	default:
+		return fmt.Sprintf("%v", e)
+	}
+}
+
+// address prints the address of a memory assignment
+func address(e ast.Expression) string {
+	if constant, isConstant := e.(*ast.Constant); isConstant {
+		return literal(constant.Value, addressWidth)
+	}
+	return Expression(e)
+}
+
+// call prints a call to a kernel function and its parameters, without a semicolon
+func call(name string, parameters []ast.Expression) string {
+	values := make([]string, len(parameters))
+	for i, parameter := range parameters {
+		values[i] = parameterValue(parameter)
+	}
+	return fmt.Sprintf("call %s (%s)", name, strings.Join(values, ", "))
+}
+
+// parameterValue prints a parameter of a kernel call: a value or a variable name
+func parameterValue(parameter ast.Expression) string {
+	switch parameter := parameter.(type) {
+	case *ast.ByteValue:
+		return constant(parameter.Value)
+	case *ast.MemoryLocation:
+		if _, err := strconv.ParseUint(parameter.Address, 0, 16); err == nil {
+			return fmt.Sprintf("mem[%s]", literal(parameter.Address, addressWidth))
+		}
+		return parameter.Address
+	default:
+		return Expression(parameter)
+	}
+}
+
+// target prints the target of a 'start' directive or a 'goto' statement
+func target(label, addr string) string {
+	if label != "" {
+		return label
+	}
+	return literal(addr, addressWidth)
+}
+
+// constant prints a constant with two digits, or four when it is written with more than two
+// digits, as addresses and words passed to kernel functions are
+func constant(text string) string {
+	if len(text) > len("0x00") {
+		return literal(text, addressWidth)
+	}
+	return literal(text, byteWidth)
+}
+
+// literal prints a hexadecimal literal with uppercase digits and at least width digits. Literals
+// that cannot be parsed are kept as written.
+func literal(text string, width int) string {
+	value, err := strconv.ParseUint(text, 0, 64)
+	if err != nil {
+		return text
+	}
+	return fmt.Sprintf("0x%0*X", width, value)
+}
+
+// separated reports whether two consecutive nodes are separated by a blank line in the source
+func separated(previous, next ast.Commented) bool {
+	return firstLine(next) > lastLine(previous)+1
+}
+
+// firstLine returns the first source line of a node, including the comments before it
+func firstLine(node ast.Commented) int {
+	if leading := node.Attached().Leading; len(leading) > 0 {
+		return leading[0].Start.Line
+	}
+	return node.Location().Start.Line
+}
+
+// lastLine returns the last source line of a node, including its trailing comment
+func lastLine(node ast.Commented) int {
+	if trailing := node.Attached().Trailing; trailing != nil {
+		return trailing.Start.Line
+	}
+	return node.Location().End.Line
+}
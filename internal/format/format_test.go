@@ -0,0 +1,205 @@
+package format
+
+import (
+	"fmt"
+	"testing"
+
+	"cyone/internal/bytecode"
+	"cyone/internal/compiler"
+	"cyone/internal/kernel"
+)
+
+var sources = []struct {
+	name      string
+	source    string
+	canonical string
+}{
+	{
+		name: "statements and comments",
+		source: `// Header comment
+loc x at 0x0;
+loc counter at 0x01 : word;   // a word
+start at main;
+block main{x=0x1;counter=counter+x*0x2;
+if(x==0x01){x=0x02;}else if(x==0x3){x=0x4;}else{x=0x5;} // chain
+while(!(x<0xa)&&-x){x=x+0x01;}
+mem[0x2]=call READ_ADC(x);
+call SET_COLOR(0x1); goto main;
+// closing
+}
+block 0x300 {}
+`,
+		canonical: `// Header comment
+loc x       at 0x0000;
+loc counter at 0x0001 : word; // a word
+start at main;
+
+block main {
+    x = 0x01;
+    counter = counter + x * 0x02;
+    if (x == 0x01) {
+        x = 0x02;
+    } else if (x == 0x03) {
+        x = 0x04;
+    } else {
+        x = 0x05;
+    } // chain
+    while (!(x < 0x0A) && -x) {
+        x = x + 0x01;
+    }
+    mem[0x0002] = call READ_ADC (x);
+    call SET_COLOR (0x01);
+    goto main;
+    // closing
+}
+
+block 0x0300 {
+}
+`,
+	},
+	{
+		name: "grouping and literal widths",
+		source: `loc w at 0x10 : word;
+loc b at 0x12;
+block 0x100 { w = 0x0FF; w = 0xff; w = (w + b) * 0x2; b = b - (b - 0x1); b = ~(b | 0x3) >> 0x1; b = b == (b == 0x1); }
+`,
+		canonical: `loc w at 0x0010 : word;
+loc b at 0x0012;
+
+block 0x0100 {
+    w = 0x00FF;
+    w = 0xFF;
+    w = (w + b) * 0x02;
+    b = b - (b - 0x01);
+    b = ~(b | 0x03) >> 0x01;
+    b = b == (b == 0x01);
+}
+`,
+	},
+	{
+		name: "blank lines and placed blocks",
+		source: `loc x at 0x0000;
+
+
+start at 0x0100;
+block   0x0100   {   goto   done;   }
+block done at 0x200 { x = 0x00; }
+
+// trailing comment
+`,
+		canonical: `loc x at 0x0000;
+
+start at 0x0100;
+
+block 0x0100 {
+    goto done;
+}
+
+block done at 0x0200 {
+    x = 0x00;
+}
+
+// trailing comment
+`,
+	},
+	{
+		name: "comments after braces keep their scope",
+		source: `loc x at 0x0000;
+block main { // entry
+if (x == 0x01) { // one
+x = 0x02;
+} // after if
+else if (x == 0x03) {
+x = 0x04;
+}
+// before else
+else { // other
+x = 0x05; } // done
+while (x) { // loop
+x = x - 0x01; }
+}
+`,
+		canonical: `loc x at 0x0000;
+
+block main { // entry
+    if (x == 0x01) { // one
+        x = 0x02;
+    } // after if
+    else if (x == 0x03) {
+        x = 0x04;
+
+        // before else
+    } else { // other
+        x = 0x05;
+    }           // done
+    while (x) { // loop
+        x = x - 0x01;
+    }
+}
+`,
+	},
+}
+
+func TestSource(t *testing.T) {
+	for _, test := range sources {
+		t.Run(test.name, func(t *testing.T) {
+			formatted, diagnostics := Source("test.cyo", test.source)
+			if diagnostics.HasErrors() {
+				t.Fatal(diagnostics)
+			}
+			if formatted != test.canonical {
+				t.Errorf("got\n%s\nexpected\n%s", formatted, test.canonical)
+			}
+		})
+	}
+}
+
+func TestSourceIdempotent(t *testing.T) {
+	for _, test := range sources {
+		t.Run(test.name, func(t *testing.T) {
+			once, _ := Source("test.cyo", test.source)
+			twice, diagnostics := Source("test.cyo", once)
+			if diagnostics.HasErrors() {
+				t.Fatal(diagnostics)
+			}
+			if twice != once {
+				t.Errorf("formatting is not idempotent, got\n%s\nafter\n%s", twice, once)
+			}
+		})
+	}
+}
+
+// TestSourcePreservesBytecode checks that formatting never changes the meaning of a program
+func TestSourcePreservesBytecode(t *testing.T) {
+	table, err := kernel.New("test", append(kernel.Default().Functions(),
+		&kernel.Function{Name: "READ_ADC", Opcode: 0x10, Parameters: []kernel.Parameter{{Name: "channel", Kind: kernel.Byte}}, Returns: kernel.Byte}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := bytecode.DefaultOptions()
+	options.Kernel = table
+	for _, test := range sources {
+		t.Run(test.name, func(t *testing.T) {
+			formatted, _ := Source("test.cyo", test.source)
+			_, original, diagnostics := compiler.Compile("test.cyo", test.source, options)
+			if diagnostics.HasErrors() {
+				t.Fatal(diagnostics)
+			}
+			_, reformatted, _ := compiler.Compile("test.cyo", formatted, options)
+			if fmt.Sprint(bytecode.GenerateIntelHex(original)) != fmt.Sprint(bytecode.GenerateIntelHex(reformatted)) {
+				t.Errorf("formatting changes the bytecode of\n%s", formatted)
+			}
+		})
+	}
+}
+
+func TestSourceWithErrors(t *testing.T) {
+	source := "loc x at 0x0000;\nblock 0x0100 { x = ; }\n"
+	formatted, diagnostics := Source("test.cyo", source)
+	if !diagnostics.HasErrors() {
+		t.Fatal("syntax error not reported")
+	}
+	if formatted != source {
+		t.Errorf("source with errors is changed to\n%s", formatted)
+	}
+}
@@ -0,0 +1,213 @@
+// Package kernel describes the functions that a Cyone Kernel provides to programs through
+// 'call'. Each hardware variant of the kernel can ship its own description file, declaring the
+// name, the opcode and the signature of every function, instead of the compiler hardcoding them.
+package kernel
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"cyone/internal/token"
+	"cyone/internal/utils"
+)
+
+// Kind is the kind of value passed to a kernel function parameter, or returned by a function
+type Kind string
+
+const (
+	Byte    Kind = "byte"    // An 8-bit value
+	Address Kind = "address" // A 16-bit data address
+	Word    Kind = "word"    // A 16-bit value
+)
+
+// ParseKind converts the name of a kind, as written in description files, to a Kind
+func ParseKind(name string) (Kind, error) {
+	switch kind := Kind(name); kind {
+	case Byte, Address, Word:
+		return kind, nil
+	default:
+		return "", fmt.Errorf("unknown kind '%s', expected 'byte', 'address' or 'word'", name)
+	}
+}
+
+// Parameter is a parameter of a kernel function. The name only documents the parameter.
+type Parameter struct {
+	Name string
+	Kind Kind
+}
+
+// Function is a kernel function. Returns is the kind of the value it returns, empty when the
+// function returns nothing and can only be called as a statement.
+type Function struct {
+	Name       string
+	Opcode     byte
+	Parameters []Parameter
+	Returns    Kind
+}
+
+// Signature formats the function, its parameters and its return kind, such as "DRAW_CIRCLE
+// (x: byte, y: byte, radius: byte)" or "READ_ADC (channel: byte) -> byte"
+func (f *Function) Signature() string {
+	parameters := make([]string, len(f.Parameters))
+	for i, parameter := range f.Parameters {
+		if parameter.Name == "" {
+			parameters[i] = string(parameter.Kind)
+		} else {
+			parameters[i] = fmt.Sprintf("%s: %s", parameter.Name, parameter.Kind)
+		}
+	}
+	signature := fmt.Sprintf("%s (%s)", f.Name, strings.Join(parameters, ", "))
+	if f.Returns != "" {
+		signature += " -> " + string(f.Returns)
+	}
+	return signature
+}
+
+// Table is the set of functions of a kernel, indexed by name and by opcode
+type Table struct {
+	Name      string
+	functions []*Function // Sorted by opcode
+	names     map[string]*Function
+	opcodes   map[byte]*Function
+}
+
+// New returns the table of a kernel. Function names must be valid identifiers, and both names
+// and opcodes must be unique. Functions can only return bytes, the values of expressions.
+func New(name string, functions []*Function) (*Table, error) {
+	t := &Table{
+		Name:    name,
+		names:   make(map[string]*Function, len(functions)),
+		opcodes: make(map[byte]*Function, len(functions)),
+	}
+	for _, f := range functions {
+		if !isIdentifier(f.Name) {
+			return nil, fmt.Errorf("invalid function name '%s'", f.Name)
+		}
+		if _, exists := t.names[f.Name]; exists {
+			return nil, fmt.Errorf("function '%s' is declared twice", f.Name)
+		}
+		if other, exists := t.opcodes[f.Opcode]; exists {
+			return nil, fmt.Errorf("functions '%s' and '%s' have the same opcode 0x%02X", other.Name, f.Name, f.Opcode)
+		}
+		for _, parameter := range f.Parameters {
+			if _, err := ParseKind(string(parameter.Kind)); err != nil {
+				return nil, fmt.Errorf("function '%s': %v", f.Name, err)
+			}
+		}
+		if f.Returns != "" && f.Returns != Byte {
+			return nil, fmt.Errorf("function '%s': invalid return kind '%s', expected 'byte'", f.Name, f.Returns)
+		}
+		t.names[f.Name] = f
+		t.opcodes[f.Opcode] = f
+		t.functions = append(t.functions, f)
+	}
+	sort.Slice(t.functions, func(i, j int) bool { return t.functions[i].Opcode < t.functions[j].Opcode })
+	return t, nil
+}
+
+// isIdentifier reports whether a name is lexed as an identifier, so that programs can call it
+func isIdentifier(name string) bool {
+	if name == "" || !utils.IsLetter(name[0]) {
+		return false
+	}
+	for i := 1; i < len(name); i++ {
+		if !utils.IsLetter(name[i]) && !utils.IsDigit(name[i]) {
+			return false
+		}
+	}
+	return utils.LookupIdent(name) == token.IDENTIFIER
+}
+
+// Default returns the table of the reference kernel, which provides the drawing functions
+func Default() *Table {
+	t, _ := New("default", []*Function{
+		{Name: "DRAW_LINE", Opcode: 0x00, Parameters: []Parameter{{"x0", Byte}, {"y0", Byte}, {"x1", Byte}, {"y1", Byte}}},
+		{Name: "DRAW_CIRCLE", Opcode: 0x01, Parameters: []Parameter{{"x", Byte}, {"y", Byte}, {"radius", Byte}}},
+		{Name: "SET_COLOR", Opcode: 0x02, Parameters: []Parameter{{"index", Byte}}},
+		{Name: "DRAW_RECTANGLE", Opcode: 0x03, Parameters: []Parameter{{"x", Byte}, {"y", Byte}, {"width", Byte}, {"height", Byte}}},
+	})
+	return t
+}
+
+// Lookup returns the function with a name
+func (t *Table) Lookup(name string) (*Function, bool) {
+	f, exists := t.names[name]
+	return f, exists
+}
+
+// Opcode returns the function with an opcode
+func (t *Table) Opcode(opcode byte) (*Function, bool) {
+	f, exists := t.opcodes[opcode]
+	return f, exists
+}
+
+// Functions returns every function of the table, sorted by opcode
+func (t *Table) Functions() []*Function {
+	return t.functions
+}
+
+// description is the JSON document of a kernel description file
+type description struct {
+	Name      string `json:"name"`
+	Functions []struct {
+		Name       string          `json:"name"`
+		Opcode     json.RawMessage `json:"opcode"` // A number, or a string such as "0x01"
+		Parameters []struct {
+			Name string `json:"name"`
+			Kind string `json:"kind"`
+		} `json:"parameters"`
+		Returns string `json:"returns"` // Empty when the function returns nothing
+	} `json:"functions"`
+}
+
+// Read decodes a kernel description file. A kernel without a name is called "custom". Unknown
+// fields are rejected so that a misspelled one is not silently ignored.
+func Read(r io.Reader) (*Table, error) {
+	var d description
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&d); err != nil {
+		return nil, err
+	}
+	if d.Name == "" {
+		d.Name = "custom"
+	}
+	functions := make([]*Function, 0, len(d.Functions))
+	for _, entry := range d.Functions {
+		opcode, err := parseOpcode(entry.Opcode)
+		if err != nil {
+			return nil, fmt.Errorf("function '%s': %v", entry.Name, err)
+		}
+		f := &Function{Name: entry.Name, Opcode: opcode, Returns: Kind(entry.Returns)}
+		for _, parameter := range entry.Parameters {
+			kind, err := ParseKind(parameter.Kind)
+			if err != nil {
+				return nil, fmt.Errorf("function '%s': %v", entry.Name, err)
+			}
+			f.Parameters = append(f.Parameters, Parameter{Name: parameter.Name, Kind: kind})
+		}
+		functions = append(functions, f)
+	}
+	return New(d.Name, functions)
+}
+
+// parseOpcode converts the opcode of a function, written as a number or as a string in any
+// base accepted by the compiler, to a byte
+func parseOpcode(raw json.RawMessage) (byte, error) {
+	if len(raw) == 0 {
+		return 0, fmt.Errorf("missing opcode")
+	}
+	text := string(raw)
+	if err := json.Unmarshal(raw, &text); err != nil {
+		text = string(raw)
+	}
+	value, err := strconv.ParseUint(text, 0, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid opcode %s, expected a value from 0x00 to 0xFF", raw)
+	}
+	return byte(value), nil
+}
@@ -0,0 +1,113 @@
+package kernel
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRead(t *testing.T) {
+	table, err := Read(strings.NewReader(`{
+  "name": "sensors",
+  "functions": [
+    {"name": "READ_ADC", "opcode": "0x01", "parameters": [{"name": "channel", "kind": "byte"}], "returns": "byte"},
+    {"name": "SET_LED", "opcode": 0, "parameters": [{"kind": "byte"}]},
+    {"name": "FILL", "opcode": "32", "parameters": [{"name": "buffer", "kind": "address"}, {"name": "count", "kind": "word"}]}
+  ]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.Name != "sensors" {
+		t.Errorf("name is %s, expected sensors", table.Name)
+	}
+	signatures := []string{
+		"SET_LED (byte)",
+		"READ_ADC (channel: byte) -> byte",
+		"FILL (buffer: address, count: word)",
+	}
+	functions := table.Functions()
+	if len(functions) != len(signatures) {
+		t.Fatalf("got %d functions, expected %d", len(functions), len(signatures))
+	}
+	for i, f := range functions {
+		if f.Signature() != signatures[i] {
+			t.Errorf("function %d is %s, expected %s", i, f.Signature(), signatures[i])
+		}
+	}
+	if f, exists := table.Lookup("FILL"); !exists || f.Opcode != 0x20 {
+		t.Errorf("FILL is not found with opcode 0x20")
+	}
+	if f, exists := table.Opcode(0x01); !exists || f.Name != "READ_ADC" {
+		t.Errorf("opcode 0x01 is not READ_ADC")
+	}
+	if _, exists := table.Lookup("DRAW_LINE"); exists {
+		t.Errorf("the reference kernel functions leak into a custom kernel")
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		expected    string
+	}{
+		{"invalid JSON", `{"functions": [`, "unexpected EOF"},
+		{"unknown field", `{"functions": [{"name": "F", "opcode": 1, "paramters": []}]}`, "json: unknown field \"paramters\""},
+		{"unknown top-level field", `{"name": "K", "return": "byte", "functions": []}`, "json: unknown field \"return\""},
+		{"missing opcode", `{"functions": [{"name": "F"}]}`, "function 'F': missing opcode"},
+		{"opcode out of range", `{"functions": [{"name": "F", "opcode": "0x100"}]}`, "function 'F': invalid opcode \"0x100\", expected a value from 0x00 to 0xFF"},
+		{"negative opcode", `{"functions": [{"name": "F", "opcode": -1}]}`, "function 'F': invalid opcode -1"},
+		{"unknown kind", `{"functions": [{"name": "F", "opcode": 1, "parameters": [{"kind": "long"}]}]}`, "function 'F': unknown kind 'long', expected 'byte', 'address' or 'word'"},
+		{"word return", `{"functions": [{"name": "F", "opcode": 1, "returns": "word"}]}`, "function 'F': invalid return kind 'word', expected 'byte'"},
+		{"keyword name", `{"functions": [{"name": "goto", "opcode": 1}]}`, "invalid function name 'goto'"},
+		{"invalid name", `{"functions": [{"name": "2D", "opcode": 1}]}`, "invalid function name '2D'"},
+		{"empty name", `{"functions": [{"opcode": 1}]}`, "invalid function name ''"},
+		{"duplicate name", `{"functions": [{"name": "F", "opcode": 1}, {"name": "F", "opcode": 2}]}`, "function 'F' is declared twice"},
+		{"duplicate opcode", `{"functions": [{"name": "F", "opcode": 1}, {"name": "G", "opcode": "0x01"}]}`, "functions 'F' and 'G' have the same opcode 0x01"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Read(strings.NewReader(test.description))
+			if err == nil {
+				t.Fatal("description accepted")
+			}
+			if !strings.HasPrefix(err.Error(), test.expected) {
+				t.Errorf("got error %q, expected %q", err, test.expected)
+			}
+		})
+	}
+}
+
+func TestReadDefaultName(t *testing.T) {
+	table, err := Read(strings.NewReader(`{"functions": []}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.Name != "custom" {
+		t.Errorf("name is %s, expected custom", table.Name)
+	}
+}
+
+// TestDefaultDescription checks that the description shipped in docs matches the reference kernel
+func TestDefaultDescription(t *testing.T) {
+	file, err := os.Open("../../docs/kernel.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	table, err := Read(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reference := Default().Functions()
+	functions := table.Functions()
+	if len(functions) != len(reference) {
+		t.Fatalf("got %d functions, expected %d", len(functions), len(reference))
+	}
+	for i, f := range functions {
+		if f.Signature() != reference[i].Signature() || f.Opcode != reference[i].Opcode {
+			t.Errorf("function 0x%02X %s, expected 0x%02X %s", f.Opcode, f.Signature(), reference[i].Opcode, reference[i].Signature())
+		}
+	}
+}
@@ -1,29 +1,44 @@
 package lexer
 
 import (
+	"cyone/internal/diagnostic"
 	"cyone/internal/token"
 	"cyone/internal/utils"
-	"fmt"
 	"unicode"
 )
 
 // Lexer represents the lexical analyzer
 type Lexer struct {
+	filename    string
 	input       string
 	currentPos  int
 	nextPos     int
 	currentChar byte
+	line        int
+	column      int
 }
 
 // NewLexer initializes a new Lexer
 func NewLexer(input string) *Lexer {
-	lexer := &Lexer{input: input}
+	return NewFileLexer("", input)
+}
+
+// NewFileLexer initializes a new Lexer whose token positions refer to the given file name
+func NewFileLexer(filename, input string) *Lexer {
+	lexer := &Lexer{filename: filename, input: input, line: 1}
 	lexer.advanceChar()
 	return lexer
 }
 
 // advanceChar reads the next character and advances the lexer's position
 func (l *Lexer) advanceChar() {
+	if l.currentChar == '\n' {
+		l.line++
+		l.column = 0
+	}
+	if l.nextPos <= len(l.input) {
+		l.column++
+	}
 	if l.nextPos >= len(l.input) {
 		l.currentChar = 0
 	} else {
@@ -37,20 +52,9 @@ func (l *Lexer) advanceChar() {
 func (l *Lexer) NextToken() (token.Token, error) {
 	var tok token.Token
 	l.skipWhitespace()
+	start := l.position()
 
 	switch l.currentChar {
-	case '=':
-		if l.peekChar() == '=' {
-			tok = l.createTwoCharToken(token.EQ)
-		} else {
-			tok = utils.NewToken(token.ASSIGN, l.currentChar)
-		}
-	case '!':
-		if l.peekChar() == '=' {
-			tok = l.createTwoCharToken(token.NOT_EQ)
-		} else {
-			return utils.NewToken(token.ILLEGAL, l.currentChar), fmt.Errorf("unexpected character: '%c' at position %d", l.currentChar, l.currentPos)
-		}
 	case '/':
 		if l.peekChar() == '/' {
 			tok.Type = token.COMMENT
@@ -59,27 +63,68 @@ func (l *Lexer) NextToken() (token.Token, error) {
 			tok = utils.NewToken(token.SLASH, l.currentChar)
 		}
 	default:
-		if tokenType, ok := token.SingleCharTokens[l.currentChar]; ok {
+		if tokenType, ok := token.MultiCharTokens[string(l.currentChar)+string(l.peekChar())]; ok {
+			tok = l.createTwoCharToken(tokenType)
+		} else if tokenType, ok := token.SingleCharTokens[l.currentChar]; ok {
 			tok = utils.NewToken(tokenType, l.currentChar)
 		} else if utils.IsLetter(l.currentChar) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = utils.LookupIdent(tok.Literal)
-			return tok, nil
+			return l.locate(tok, start), nil
 		} else if l.currentChar == '0' && l.peekChar() == 'x' {
 			tok.Type = token.HEXNUMBER
 			tok.Literal = l.readHexNumber()
-			return tok, nil
+			return l.locate(tok, start), nil
 		} else if l.currentChar == 0 {
 			tok.Literal = ""
 			tok.Type = token.EOF
-			return tok, nil
+			return l.locate(tok, start), nil
 		} else {
-			return utils.NewToken(token.ILLEGAL, l.currentChar), fmt.Errorf("unexpected character: '%c' at position %d", l.currentChar, l.currentPos)
+			return l.illegal(start)
 		}
 	}
 
 	l.advanceChar()
-	return tok, nil
+	return l.locate(tok, start), nil
+}
+
+// position returns the source position of the current character
+func (l *Lexer) position() token.Position {
+	return token.Position{Filename: l.filename, Offset: l.currentPos, Line: l.line, Column: l.column}
+}
+
+// locate sets the start and end positions of a token that has just been read
+func (l *Lexer) locate(tok token.Token, start token.Position) token.Token {
+	tok.Pos = start
+	tok.End = l.position()
+	return tok
+}
+
+// illegal returns an ILLEGAL token for the current character along with a diagnostic describing it.
+// The character is consumed so that lexing can continue after the error.
+func (l *Lexer) illegal(start token.Position) (token.Token, error) {
+	tok := utils.NewToken(token.ILLEGAL, l.currentChar)
+	l.advanceChar()
+	tok = l.locate(tok, start)
+	return tok, diagnostic.Errorf(diagnostic.IllegalCharacter, tok.Span(), "unexpected character: '%s'", tok.Literal)
+}
+
+// Tokenize reads the whole input and returns its tokens, excluding the final EOF token,
+// together with a diagnostic for every illegal character found along the way
+func (l *Lexer) Tokenize() ([]token.Token, diagnostic.List) {
+	var tokens []token.Token
+	var diagnostics diagnostic.List
+	for {
+		tok, err := l.NextToken()
+		if err != nil {
+			diagnostics.AddError(err)
+			continue
+		}
+		if tok.Type == token.EOF {
+			return tokens, diagnostics
+		}
+		tokens = append(tokens, tok)
+	}
 }
 
 // createTwoCharToken returns a token made up of two characters
@@ -89,10 +134,11 @@ func (l *Lexer) createTwoCharToken(tokenType token.TokenType) token.Token {
 	return token.Token{Type: tokenType, Literal: string(previousChar) + string(l.currentChar)}
 }
 
-// readIdentifier reads an identifier and advances lexer's position
+// readIdentifier reads an identifier and advances lexer's position. Identifiers start with a
+// letter or an underscore, digits are allowed after the first character.
 func (l *Lexer) readIdentifier() string {
 	startPos := l.currentPos
-	for utils.IsLetter(l.currentChar) {
+	for utils.IsLetter(l.currentChar) || utils.IsDigit(l.currentChar) {
 		l.advanceChar()
 	}
 	return l.input[startPos:l.currentPos]
@@ -0,0 +1,155 @@
+// Package listing prints the listing of a compiled program in the style of classic assemblers:
+// every source line next to the code addresses and raw bytes generated for it, with the
+// address range and the length prefix of each block.
+package listing
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"cyone/internal/ast"
+	"cyone/internal/bytecode"
+	"cyone/internal/disasm"
+)
+
+// bytesPerRow is the number of raw bytes printed on each row
+const bytesPerRow = 8
+
+// segment is a run of bytes generated for a source line
+type segment struct {
+	address uint16
+	data    []byte
+}
+
+// lister attributes the bytes of every record to source lines
+type lister struct {
+	record   *disasm.Record
+	segments map[int][]segment // Bytes generated for each source line
+	headers  map[int][]string  // Comments printed before a source line
+}
+
+// Write prints the listing of a program compiled from source
+func Write(w io.Writer, source string, program *ast.Program, bytecodes []bytecode.Bytecode) error {
+	l := &lister{segments: make(map[int][]segment), headers: make(map[int][]string)}
+	blocks := make(map[uint16]*ast.Block)
+	for _, bc := range bytecodes {
+		if bc.Block != nil {
+			blocks[bc.Address] = bc.Block
+		}
+	}
+
+	for _, record := range disasm.DecodeBytecode(bytecodes).Records {
+		l.record = record
+		end := int(record.Address) + len(record.Bytes)
+		if block, exists := blocks[record.Address]; exists {
+			line := block.Span.Start.Line
+			l.headers[line] = append(l.headers[line], fmt.Sprintf("; block 0x%04X - 0x%04X (%d bytes), length prefix 0x%04X", record.Address, end-1, len(record.Bytes), len(record.Bytes)-3))
+			l.block(block, record)
+		} else if program.Start != nil {
+			line := program.Start.Span.Start.Line
+			l.headers[line] = append(l.headers[line], "; start vector")
+			l.add(line, int(record.Address), end)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSuffix(source, "\n"), "\n")
+	var b strings.Builder
+	for i, text := range lines {
+		line := i + 1
+		for _, header := range l.headers[line] {
+			b.WriteString(header + "\n")
+		}
+		first := true
+		for _, s := range l.segments[line] {
+			for offset := 0; offset < len(s.data); offset += bytesPerRow {
+				row := s.data[offset:min(offset+bytesPerRow, len(s.data))]
+				raw := make([]string, len(row))
+				for j, value := range row {
+					raw[j] = fmt.Sprintf("%02X", value)
+				}
+				prefix := fmt.Sprintf("%04X  %-*s", int(s.address)+offset, bytesPerRow*3-1, strings.Join(raw, " "))
+				if first {
+					b.WriteString(strings.TrimRight(fmt.Sprintf("%s  %5d  %s", prefix, line, text), " \t") + "\n")
+					first = false
+				} else {
+					b.WriteString(strings.TrimRight(prefix, " ") + "\n")
+				}
+			}
+		}
+		if first {
+			b.WriteString(strings.TrimRight(fmt.Sprintf("%*s  %5d  %s", 4+2+bytesPerRow*3-1, "", line, text), " \t") + "\n")
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// add attributes the bytes between two addresses of the current record to a source line
+func (l *lister) add(line int, from, to int) {
+	if from >= to {
+		return
+	}
+	base := int(l.record.Address)
+	segments := l.segments[line]
+	// Bytes following those already attributed to the line extend them
+	if last := len(segments) - 1; last >= 0 && int(segments[last].address)+len(segments[last].data) == from {
+		segments[last].data = l.record.Bytes[int(segments[last].address)-base : to-base]
+		return
+	}
+	l.segments[line] = append(segments, segment{address: uint16(from), data: l.record.Bytes[from-base : to-base]})
+}
+
+// block attributes the bytes of a block record: the opcode, the length prefix and the opening
+// brace to the block declaration, then every statement, then the closing brace
+func (l *lister) block(block *ast.Block, record *disasm.Record) {
+	start, end := int(record.Address), int(record.Address)+len(record.Bytes)
+	if record.Err != nil {
+		l.add(block.Span.Start.Line, start, end)
+		return
+	}
+	l.add(block.Span.Start.Line, start, start+4)
+	l.statements(block.Statements, record.Statements)
+	l.add(block.Span.End.Line, end-1, end)
+}
+
+// statements attributes the bytes of decoded statements to the source statements they were
+// generated from
+func (l *lister) statements(statements []ast.Statement, decoded []*disasm.Statement) {
+	for i := 0; i < len(statements) && i < len(decoded); i++ {
+		d := decoded[i]
+		line := statements[i].Location().Start.Line
+		switch s := statements[i].(type) {
+		case *ast.IfStatement:
+			l.add(line, int(d.Address), int(d.Then.Address))
+			l.branch(s.ThenBlock.Span.Start.Line, s.ThenBlock.Span.End.Line, s.ThenBlock.Statements, d.Then)
+			switch {
+			case s.ElseIf != nil:
+				// 'else if' is an if nested in the else branch, opened on the line closing the then branch
+				l.branch(s.ThenBlock.Span.End.Line, s.ElseIf.Span.End.Line, []ast.Statement{s.ElseIf}, d.Else)
+			case s.ElseBlock != nil:
+				l.branch(s.ElseBlock.Span.Start.Line, s.ElseBlock.Span.End.Line, s.ElseBlock.Statements, d.Else)
+			default:
+				// The empty else branch generated for an if without else
+				l.branch(s.ThenBlock.Span.End.Line, s.ThenBlock.Span.End.Line, nil, d.Else)
+			}
+		case *ast.WhileStatement:
+			l.add(line, int(d.Address), int(d.Body.Address))
+			l.branch(s.Body.Span.Start.Line, s.Body.Span.End.Line, s.Body.Statements, d.Body)
+		default:
+			l.add(line, int(d.Address), int(d.Address)+d.Length)
+		}
+	}
+}
+
+// branch attributes the bytes that open a branch to one line, its statements, and its closing
+// brace to another line
+func (l *lister) branch(open, close int, statements []ast.Statement, branch *disasm.Branch) {
+	first := branch.Close
+	if len(branch.Statements) > 0 {
+		first = branch.Statements[0].Address
+	}
+	l.add(open, int(branch.Address), int(first))
+	l.statements(statements, branch.Statements)
+	l.add(close, int(branch.Close), int(branch.Close)+1)
+}
@@ -0,0 +1,442 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"cyone/internal/ast"
+	"cyone/internal/bytecode"
+	"cyone/internal/compiler"
+	"cyone/internal/diagnostic"
+	"cyone/internal/kernel"
+	"cyone/internal/lexer"
+	"cyone/internal/token"
+)
+
+// document is an open source file and the result of its last analysis
+type document struct {
+	uri       string
+	filename  string
+	tokens    []token.Token
+	program   *ast.Program
+	bytecodes []bytecode.Bytecode
+	kernel    *kernel.Table
+}
+
+// Server answers the requests of an editor
+type Server struct {
+	in        *bufio.Reader
+	out       io.Writer
+	options   bytecode.Options
+	documents map[string]*document
+}
+
+// NewServer returns a server reading messages from in and writing to out. Documents are
+// compiled with options.
+func NewServer(in io.Reader, out io.Writer, options bytecode.Options) *Server {
+	if options.Kernel == nil {
+		options.Kernel = kernel.Default()
+	}
+	return &Server{in: bufio.NewReader(in), out: out, options: options, documents: make(map[string]*document)}
+}
+
+// Serve answers messages until the editor sends exit or closes the input
+func (s *Server) Serve() error {
+	for {
+		m, err := readMessage(s.in)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if m.Method == "exit" {
+			return nil
+		}
+		result, err := s.handle(m)
+		if m.ID == nil {
+			// Notifications are never answered
+			continue
+		}
+		if err != nil {
+			code := codeInvalidParams
+			if errors.Is(err, errMethodNotFound) {
+				code = codeMethodNotFound
+			}
+			err = writeMessage(s.out, &errorResponse{JSONRPC: "2.0", ID: m.ID, Error: responseError{Code: code, Message: err.Error()}})
+		} else {
+			err = writeMessage(s.out, &response{JSONRPC: "2.0", ID: m.ID, Result: result})
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// errMethodNotFound is returned for the methods the server does not implement
+var errMethodNotFound = errors.New("method not supported")
+
+// handle answers a message and returns the result of the request
+func (s *Server) handle(m *message) (interface{}, error) {
+	switch m.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":       1, // The full text is sent on every change
+				"definitionProvider":     true,
+				"hoverProvider":          true,
+				"completionProvider":     map[string]interface{}{},
+				"documentSymbolProvider": true,
+			},
+			"serverInfo": map[string]string{"name": "cyone"},
+		}, nil
+	case "initialized", "shutdown", "$/cancelRequest", "$/setTrace":
+		return nil, nil
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := json.Unmarshal(m.Params, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.analyze(params.TextDocument.URI, params.TextDocument.Text)
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := json.Unmarshal(m.Params, &params); err != nil {
+			return nil, err
+		}
+		if len(params.ContentChanges) == 0 {
+			return nil, nil
+		}
+		return nil, s.analyze(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err := json.Unmarshal(m.Params, &params); err != nil {
+			return nil, err
+		}
+		delete(s.documents, params.TextDocument.URI)
+		return nil, s.publish(params.TextDocument.URI, nil)
+	case "textDocument/definition":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(m.Params, &params); err != nil {
+			return nil, err
+		}
+		doc, index := s.tokenAt(params)
+		if doc == nil {
+			return nil, nil
+		}
+		return doc.definition(index), nil
+	case "textDocument/hover":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(m.Params, &params); err != nil {
+			return nil, err
+		}
+		doc, index := s.tokenAt(params)
+		if doc == nil {
+			return nil, nil
+		}
+		return doc.hover(index), nil
+	case "textDocument/completion":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(m.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.completion(s.documents[params.TextDocument.URI]), nil
+	case "textDocument/documentSymbol":
+		var params documentSymbolParams
+		if err := json.Unmarshal(m.Params, &params); err != nil {
+			return nil, err
+		}
+		doc, exists := s.documents[params.TextDocument.URI]
+		if !exists {
+			return []documentSymbol{}, nil
+		}
+		return doc.symbols(), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", errMethodNotFound, m.Method)
+	}
+}
+
+// analyze compiles the text of a document and publishes its diagnostics
+func (s *Server) analyze(uri, text string) error {
+	filename := uri
+	if parsed, err := url.Parse(uri); err == nil && parsed.Scheme == "file" {
+		filename = parsed.Path
+	}
+	doc := &document{uri: uri, filename: filename, kernel: s.options.Kernel}
+	doc.tokens, _ = lexer.NewFileLexer(filename, text).Tokenize()
+	var diagnostics diagnostic.List
+	doc.program, doc.bytecodes, diagnostics = compiler.Compile(filename, text, s.options)
+	s.documents[uri] = doc
+	return s.publish(uri, diagnostics)
+}
+
+// publish sends the diagnostics of a document to the editor
+func (s *Server) publish(uri string, diagnostics diagnostic.List) error {
+	params := publishDiagnosticsParams{URI: uri, Diagnostics: []lspDiagnostic{}}
+	for _, d := range diagnostics {
+		severity := severityError
+		switch d.Severity {
+		case diagnostic.Warning:
+			severity = severityWarning
+		case diagnostic.Note:
+			severity = severityInformation
+		}
+		message := d.Message
+		for _, note := range d.Notes {
+			message += "\nnote: " + note
+		}
+		params.Diagnostics = append(params.Diagnostics, lspDiagnostic{
+			Range:    toRange(d.Span),
+			Severity: severity,
+			Code:     d.Code,
+			Source:   "cyone",
+			Message:  message,
+		})
+	}
+	return writeMessage(s.out, &notification{JSONRPC: "2.0", Method: "textDocument/publishDiagnostics", Params: params})
+}
+
+// tokenAt returns the document of a request and the index of the token under the cursor,
+// or a nil document when there is no token there
+func (s *Server) tokenAt(params textDocumentPositionParams) (*document, int) {
+	doc, exists := s.documents[params.TextDocument.URI]
+	if !exists {
+		return nil, 0
+	}
+	for i, tok := range doc.tokens {
+		start, end := toPosition(tok.Pos), toPosition(tok.End)
+		if tok.Type != token.COMMENT && start.Line == params.Position.Line &&
+			start.Character <= params.Position.Character && params.Position.Character <= end.Character {
+			return doc, i
+		}
+	}
+	return nil, 0
+}
+
+// previous returns the index of the token before index, ignoring comments, or -1 when there
+// is none
+func (doc *document) previous(index int) int {
+	for i := index - 1; i >= 0; i-- {
+		if doc.tokens[i].Type != token.COMMENT {
+			return i
+		}
+	}
+	return -1
+}
+
+// typeAt returns the type of the token at index, or EOF when index is outside the tokens
+func (doc *document) typeAt(index int) token.TokenType {
+	if index < 0 || index >= len(doc.tokens) {
+		return token.EOF
+	}
+	return doc.tokens[index].Type
+}
+
+// variable returns the declaration of a variable
+func (doc *document) variable(name string) *ast.VariableDeclaration {
+	for _, declaration := range doc.program.Variables {
+		if declaration.Name == name {
+			return declaration
+		}
+	}
+	return nil
+}
+
+// variableAt returns the declaration of the variable designated by the token at index: a
+// variable name, or the data address of a 'loc name at address' declaration
+func (doc *document) variableAt(index int) *ast.VariableDeclaration {
+	tok := doc.tokens[index]
+	switch tok.Type {
+	case token.IDENTIFIER:
+		return doc.variable(tok.Literal)
+	case token.HEXNUMBER:
+		at := doc.previous(index)
+		name := doc.previous(at)
+		if doc.typeAt(at) == token.AT && doc.typeAt(name) == token.IDENTIFIER && doc.typeAt(doc.previous(name)) == token.LOC {
+			return doc.variable(doc.tokens[name].Literal)
+		}
+	}
+	return nil
+}
+
+// blockAddresses returns the code address of every block: the placed address when the
+// document compiles, the declared address otherwise
+func (doc *document) blockAddresses() map[*ast.Block]uint16 {
+	addresses := make(map[*ast.Block]uint16, len(doc.program.Blocks))
+	for _, bc := range doc.bytecodes {
+		if bc.Block != nil {
+			addresses[bc.Block] = bc.Address
+		}
+	}
+	if len(addresses) > 0 {
+		return addresses
+	}
+	for _, block := range doc.program.Blocks {
+		if address, err := strconv.ParseUint(block.Address, 0, 16); err == nil {
+			addresses[block] = uint16(address)
+		}
+	}
+	return addresses
+}
+
+// targetBlock returns the block designated by the token at index when it is a block label,
+// or the code address of a 'goto' or 'start' directive. Other addresses are data addresses.
+func (doc *document) targetBlock(index int) *ast.Block {
+	tok := doc.tokens[index]
+	switch tok.Type {
+	case token.IDENTIFIER:
+		for _, block := range doc.program.Blocks {
+			if block.Name == tok.Literal {
+				return block
+			}
+		}
+	case token.HEXNUMBER:
+		previous := doc.previous(index)
+		isGoto := doc.typeAt(previous) == token.GOTO
+		isStart := doc.typeAt(previous) == token.AT && doc.typeAt(doc.previous(previous)) == token.START
+		if !isGoto && !isStart {
+			return nil
+		}
+		address, err := strconv.ParseUint(tok.Literal, 0, 16)
+		if err != nil {
+			return nil
+		}
+		addresses := doc.blockAddresses()
+		for _, block := range doc.program.Blocks {
+			if blockAddress, exists := addresses[block]; exists && blockAddress == uint16(address) {
+				return block
+			}
+		}
+	}
+	return nil
+}
+
+// definition returns the declaration of the variable or block designated by the token at index
+func (doc *document) definition(index int) interface{} {
+	if declaration := doc.variableAt(index); declaration != nil {
+		return location{URI: doc.uri, Range: toRange(declaration.Span)}
+	}
+	if block := doc.targetBlock(index); block != nil {
+		return location{URI: doc.uri, Range: toRange(block.Span)}
+	}
+	return nil
+}
+
+// hover describes the variable, kernel function or block designated by the token at index
+func (doc *document) hover(index int) interface{} {
+	tok := doc.tokens[index]
+	var text string
+	if declaration := doc.variableAt(index); declaration != nil {
+		if declaration.IsWord() {
+			text = fmt.Sprintf("```cyone\nloc %s at %s : word;\n```\nWord variable stored in data memory at `%s`, high byte first.", declaration.Name, declaration.Address, declaration.Address)
+		} else {
+			text = fmt.Sprintf("```cyone\nloc %s at %s;\n```\nVariable stored in data memory at `%s`.", declaration.Name, declaration.Address, declaration.Address)
+		}
+	} else if f, exists := doc.kernel.Lookup(tok.Literal); exists && tok.Type == token.IDENTIFIER {
+		text = fmt.Sprintf("```cyone\ncall %s;\n```\nKernel function, opcode `0x%02X`.", f.Signature(), f.Opcode)
+	}
+	if text == "" {
+		block := doc.targetBlock(index)
+		if block == nil {
+			return nil
+		}
+		text = doc.describeBlock(block)
+	}
+	span := tok.Span()
+	r := toRange(span)
+	return hover{Contents: markupContent{Kind: "markdown", Value: text}, Range: &r}
+}
+
+// describeBlock returns the label and address range of a block
+func (doc *document) describeBlock(block *ast.Block) string {
+	name := "Block"
+	if block.Name != "" {
+		name = fmt.Sprintf("Block `%s`", block.Name)
+	}
+	for _, bc := range doc.bytecodes {
+		if bc.Block == block {
+			return fmt.Sprintf("%s at `0x%04X` - `0x%04X` (%d bytes).", name, bc.Address, int(bc.Address)+len(bc.Operands), 1+len(bc.Operands))
+		}
+	}
+	if address, exists := doc.blockAddresses()[block]; exists {
+		return fmt.Sprintf("%s at `0x%04X`.", name, address)
+	}
+	return name + "."
+}
+
+// completion lists the keywords, the kernel functions and the variables and block labels of
+// a document
+func (s *Server) completion(doc *document) []completionItem {
+	var items []completionItem
+	for _, keyword := range sortedKeys(token.Keywords) {
+		items = append(items, completionItem{Label: keyword, Kind: completionKeyword})
+	}
+	functions := append([]*kernel.Function(nil), s.options.Kernel.Functions()...)
+	sort.Slice(functions, func(i, j int) bool { return functions[i].Name < functions[j].Name })
+	for _, f := range functions {
+		items = append(items, completionItem{Label: f.Name, Kind: completionFunction, Detail: fmt.Sprintf("%s, kernel function 0x%02X", f.Signature(), f.Opcode)})
+	}
+	if doc == nil {
+		return items
+	}
+	for _, declaration := range doc.program.Variables {
+		detail := "loc at " + declaration.Address
+		if declaration.IsWord() {
+			detail += " : word"
+		}
+		items = append(items, completionItem{Label: declaration.Name, Kind: completionVariable, Detail: detail})
+	}
+	for _, block := range doc.program.Blocks {
+		if block.Name != "" {
+			items = append(items, completionItem{Label: block.Name, Kind: completionFunction, Detail: "block"})
+		}
+	}
+	return items
+}
+
+// symbols lists the variables and blocks of a document
+func (doc *document) symbols() []documentSymbol {
+	symbols := []documentSymbol{}
+	for _, declaration := range doc.program.Variables {
+		symbols = append(symbols, documentSymbol{
+			Name:           declaration.Name,
+			Detail:         declaration.Address,
+			Kind:           symbolVariable,
+			Range:          toRange(declaration.Span),
+			SelectionRange: toRange(declaration.Span),
+		})
+	}
+	addresses := doc.blockAddresses()
+	for _, block := range doc.program.Blocks {
+		name, detail := block.Name, ""
+		if address, exists := addresses[block]; exists {
+			detail = fmt.Sprintf("0x%04X", address)
+		}
+		if name == "" {
+			name, detail = "block "+detail, ""
+		}
+		symbols = append(symbols, documentSymbol{
+			Name:           name,
+			Detail:         detail,
+			Kind:           symbolFunction,
+			Range:          toRange(block.Span),
+			SelectionRange: toRange(block.Span),
+		})
+	}
+	return symbols
+}
+
+// sortedKeys returns the keys of a map in alphabetical order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,317 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+
+	"cyone/internal/bytecode"
+)
+
+const testURI = "file:///test.cyo"
+
+// testSource declares the variable x and a code block at the same address, 0x0200
+const testSource = `loc x at 0x0200;
+loc w at 0x0010 : word;
+start at 0x0100;
+block main at 0x0100 {
+    x = x + 0x01;
+    goto 0x0200;
+}
+block 0x0200 {
+    w = w + x;
+    call SET_COLOR (x);
+    goto main;
+}
+`
+
+// reply is a response or a notification written by the server
+type reply struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *responseError  `json:"error"`
+}
+
+// request returns a request, or a notification when id is 0
+func request(id int, method string, params interface{}) map[string]interface{} {
+	m := map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}
+	if id != 0 {
+		m["id"] = id
+	}
+	return m
+}
+
+// open returns the notification opening a document
+func open(text string) map[string]interface{} {
+	return request(0, "textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": testURI, "languageId": "cyone", "version": 1, "text": text},
+	})
+}
+
+// at returns the parameters of a request on the position of a document
+func at(line, character int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]string{"uri": testURI},
+		"position":     position{Line: line, Character: character},
+	}
+}
+
+// exchange sends messages to a server and returns everything it writes back
+func exchange(t *testing.T, messages ...map[string]interface{}) []reply {
+	t.Helper()
+	var in, out bytes.Buffer
+	for _, m := range messages {
+		if err := writeMessage(&in, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := NewServer(&in, &out, bytecode.DefaultOptions()).Serve(); err != nil {
+		t.Fatal(err)
+	}
+	var replies []reply
+	r := bufio.NewReader(&out)
+	for {
+		header, err := textproto.NewReader(r).ReadMIMEHeader()
+		if errors.Is(err, io.EOF) {
+			return replies
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		length, _ := strconv.Atoi(header.Get("Content-Length"))
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r, body); err != nil {
+			t.Fatal(err)
+		}
+		var rep reply
+		if err := json.Unmarshal(body, &rep); err != nil {
+			t.Fatal(err)
+		}
+		replies = append(replies, rep)
+	}
+}
+
+// result returns the result of the response to request id
+func result(t *testing.T, replies []reply, id int) json.RawMessage {
+	t.Helper()
+	for _, rep := range replies {
+		if rep.ID != nil && *rep.ID == id {
+			if rep.Error != nil {
+				t.Fatalf("request %d failed: %s", id, rep.Error.Message)
+			}
+			return rep.Result
+		}
+	}
+	t.Fatalf("request %d is not answered", id)
+	return nil
+}
+
+func TestHover(t *testing.T) {
+	tests := []struct {
+		name      string
+		line      int
+		character int
+		expected  string // A part of the hover text, empty when there is none
+	}{
+		{"variable", 4, 4, "Variable stored in data memory at `0x0200`."},
+		{"word variable", 8, 4, "Word variable stored in data memory at `0x0010`, high byte first."},
+		{"variable address", 0, 10, "loc x at 0x0200;"},
+		{"word variable address", 1, 10, "loc w at 0x0010 : word;"},
+		{"start address", 2, 10, "Block `main` at `0x0100`"},
+		{"goto address", 5, 10, "Block at `0x0200`"},
+		{"goto label", 10, 10, "Block `main` at `0x0100`"},
+		{"block label", 3, 7, "Block `main` at `0x0100`"},
+		{"kernel function", 9, 10, "Kernel function, opcode `0x02`."},
+		{"block address", 7, 8, ""},
+		{"constant", 4, 14, ""},
+		{"keyword", 5, 5, ""},
+		{"blank", 4, 1, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			replies := exchange(t, open(testSource), request(1, "textDocument/hover", at(test.line, test.character)))
+			var h *hover
+			if err := json.Unmarshal(result(t, replies, 1), &h); err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case h == nil && test.expected != "":
+				t.Errorf("no hover, expected %q", test.expected)
+			case h != nil && test.expected == "":
+				t.Errorf("got hover %q, expected none", h.Contents.Value)
+			case h != nil && !strings.Contains(h.Contents.Value, test.expected):
+				t.Errorf("got hover %q, expected %q", h.Contents.Value, test.expected)
+			}
+		})
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	tests := []struct {
+		name      string
+		line      int
+		character int
+		expected  int // Line of the definition, -1 when there is none
+	}{
+		{"variable", 4, 8, 0},
+		{"word variable", 8, 8, 1},
+		{"variable address", 0, 10, 0},
+		{"start address", 2, 10, 3},
+		{"goto address", 5, 10, 7},
+		{"goto label", 10, 10, 3},
+		{"constant", 4, 14, -1},
+		{"kernel function", 9, 10, -1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			replies := exchange(t, open(testSource), request(1, "textDocument/definition", at(test.line, test.character)))
+			var l *location
+			if err := json.Unmarshal(result(t, replies, 1), &l); err != nil {
+				t.Fatal(err)
+			}
+			line := -1
+			if l != nil {
+				line = l.Range.Start.Line
+				if l.URI != testURI {
+					t.Errorf("definition is in %s", l.URI)
+				}
+			}
+			if line != test.expected {
+				t.Errorf("definition is on line %d, expected %d", line, test.expected)
+			}
+		})
+	}
+}
+
+func TestPublishDiagnostics(t *testing.T) {
+	invalid := "loc x at 0x0000;\nstart at 0x0104;\nblock 0x0100 {\n    y = 0x01;\n}\n"
+	change := func(text string) map[string]interface{} {
+		return request(0, "textDocument/didChange", map[string]interface{}{
+			"textDocument":   map[string]string{"uri": testURI},
+			"contentChanges": []map[string]string{{"text": text}},
+		})
+	}
+	replies := exchange(t,
+		open(invalid),
+		change(testSource),
+		request(0, "textDocument/didClose", map[string]interface{}{"textDocument": map[string]string{"uri": testURI}}),
+	)
+	expected := [][]lspDiagnostic{
+		{
+			{
+				Range:    lspRange{Start: position{Line: 1, Character: 0}, End: position{Line: 1, Character: 16}},
+				Severity: severityError,
+				Code:     "E311",
+				Source:   "cyone",
+				Message:  "start address 0x0104 is not the start of any block\nnote: nearest valid block addresses: 0x0100",
+			},
+			{
+				Range:    lspRange{Start: position{Line: 3, Character: 4}, End: position{Line: 3, Character: 13}},
+				Severity: severityError,
+				Code:     "E300",
+				Source:   "cyone",
+				Message:  "variable 'y' not found in the variable address map",
+			},
+		},
+		{}, // The change fixes every error
+		{}, // Closing a document clears its diagnostics
+	}
+	if len(replies) != len(expected) {
+		t.Fatalf("got %d messages, expected %d", len(replies), len(expected))
+	}
+	for i, rep := range replies {
+		if rep.Method != "textDocument/publishDiagnostics" {
+			t.Fatalf("message %d is %s", i, rep.Method)
+		}
+		var params publishDiagnosticsParams
+		if err := json.Unmarshal(rep.Params, &params); err != nil {
+			t.Fatal(err)
+		}
+		if params.URI != testURI {
+			t.Errorf("diagnostics of %s", params.URI)
+		}
+		if got, _ := json.Marshal(params.Diagnostics); string(got) != string(mustMarshal(t, expected[i])) {
+			t.Errorf("message %d holds\n%s\nexpected\n%s", i, got, mustMarshal(t, expected[i]))
+		}
+	}
+}
+
+// mustMarshal returns the JSON encoding of v
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestCompletion(t *testing.T) {
+	replies := exchange(t, open(testSource), request(1, "textDocument/completion", at(4, 4)))
+	var items []completionItem
+	if err := json.Unmarshal(result(t, replies, 1), &items); err != nil {
+		t.Fatal(err)
+	}
+	details := make(map[string]completionItem)
+	for _, item := range items {
+		details[item.Label] = item
+	}
+	expected := []completionItem{
+		{Label: "goto", Kind: completionKeyword},
+		{Label: "while", Kind: completionKeyword},
+		{Label: "SET_COLOR", Kind: completionFunction, Detail: "SET_COLOR (index: byte), kernel function 0x02"},
+		{Label: "x", Kind: completionVariable, Detail: "loc at 0x0200"},
+		{Label: "w", Kind: completionVariable, Detail: "loc at 0x0010 : word"},
+		{Label: "main", Kind: completionFunction, Detail: "block"},
+	}
+	for _, item := range expected {
+		if got, exists := details[item.Label]; !exists || got != item {
+			t.Errorf("got item %+v, expected %+v", got, item)
+		}
+	}
+}
+
+func TestDocumentSymbols(t *testing.T) {
+	replies := exchange(t, open(testSource),
+		request(1, "textDocument/documentSymbol", map[string]interface{}{"textDocument": map[string]string{"uri": testURI}}),
+		request(2, "textDocument/documentSymbol", map[string]interface{}{"textDocument": map[string]string{"uri": "file:///closed.cyo"}}),
+	)
+	var symbols []documentSymbol
+	if err := json.Unmarshal(result(t, replies, 1), &symbols); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"x 0x0200 13 0-0",
+		"w 0x0010 13 1-1",
+		"main 0x0100 12 3-6",
+		"block 0x0200  12 7-11",
+	}
+	if len(symbols) != len(expected) {
+		t.Fatalf("got %d symbols, expected %d", len(symbols), len(expected))
+	}
+	for i, symbol := range symbols {
+		got := fmt.Sprintf("%s %s %d %d-%d", symbol.Name, symbol.Detail, symbol.Kind, symbol.Range.Start.Line, symbol.Range.End.Line)
+		if got != expected[i] {
+			t.Errorf("symbol %d is %q, expected %q", i, got, expected[i])
+		}
+	}
+	if closed := string(result(t, replies, 2)); closed != "[]" {
+		t.Errorf("symbols of a closed document: %s", closed)
+	}
+}
+
+func TestUnknownMethod(t *testing.T) {
+	replies := exchange(t, request(1, "textDocument/rename", at(0, 0)))
+	if len(replies) != 1 || replies[0].Error == nil || replies[0].Error.Code != codeMethodNotFound {
+		t.Fatalf("got %+v, expected a method not found error", replies)
+	}
+}
@@ -2,16 +2,26 @@ package parser
 
 import (
 	"cyone/internal/ast"
+	"cyone/internal/diagnostic"
 	"cyone/internal/token"
-	"fmt"
+	"errors"
 )
 
-// Parser is responsible for parsing the list of tokens into an AST
+// Parser is responsible for parsing the list of tokens into an AST.
+// Syntax errors do not stop the parser: each one is recorded as a diagnostic and parsing
+// resumes at the next ';' or '}', so a single pass reports every error in the input.
 type Parser struct {
-	tokens  []token.Token
-	current int
+	tokens      []token.Token
+	current     int
+	last        token.Token    // Last consumed token, used to compute node spans
+	comments    []*ast.Comment // Comments skipped by peek and not yet attached to a node
+	diagnostics diagnostic.List
 }
 
+// errRecovered signals that an error has already been recorded and the caller should stop
+// parsing the current construct without reporting it again
+var errRecovered = errors.New("parser recovered from a reported error")
+
 // NewParser creates a new Parser instance
 func NewParser(tokens []token.Token) *Parser {
 	return &Parser{
@@ -20,45 +30,77 @@ func NewParser(tokens []token.Token) *Parser {
 	}
 }
 
-// Parse initiates parsing and returns the constructed AST
+// Parse initiates parsing and returns the constructed AST. When syntax errors are found the
+// partial AST is returned together with a diagnostic.List holding all of them.
 func (p *Parser) Parse() (*ast.Program, error) {
 	var program ast.Program
-	for p.current < len(p.tokens) {
+	for {
+		comments := p.takeComments()
 		currentToken, err := p.peek()
 		if err != nil {
-			return nil, err
+			program.Comments = comments
+			break
 		}
 
+		var node ast.Commented
 		switch currentToken.Type {
 		case token.START:
 			startBlock, err := p.parseStartBlock()
 			if err != nil {
-				return nil, fmt.Errorf("failed to parse start block: %v", err)
+				p.recover(err, p.synchronizeTopLevel)
+				continue
 			}
 			program.Start = startBlock
+			node = startBlock
 		case token.LOC:
 			variableDeclaration, err := p.parseVariableDeclaration()
 			if err != nil {
-				return nil, fmt.Errorf("failed to parse variable declaration: %v", err)
+				p.recover(err, p.synchronizeTopLevel)
+				continue
 			}
 			program.Variables = append(program.Variables, variableDeclaration)
+			node = variableDeclaration
 		case token.BLOCK:
 			block, err := p.parseBlock()
 			if err != nil {
-				return nil, fmt.Errorf("failed to parse block: %v", err)
+				p.recover(err, p.synchronizeTopLevel)
+				continue
 			}
 			program.Blocks = append(program.Blocks, block)
+			node = block
 		default:
-			return nil, fmt.Errorf("unexpected token: %s", currentToken.Literal)
+			p.recover(p.errorf(diagnostic.UnexpectedToken, currentToken.Span(), "unexpected token: %s", currentToken.Literal), p.synchronizeTopLevel)
+			continue
 		}
+		node.Attached().Leading = comments
+		node.Attached().Trailing = p.trailingComment()
 	}
 
-	return &program, nil
+	return &program, p.diagnostics.Err()
+}
+
+// ParseExpression parses tokens holding a single expression, such as the expressions typed in
+// a debugger. Any token left after the expression is an error.
+func (p *Parser) ParseExpression() (ast.Expression, error) {
+	expression, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if extra, err := p.peek(); err == nil {
+		return nil, p.errorf(diagnostic.UnexpectedToken, extra.Span(), "unexpected token after expression: %s", extra.Literal)
+	}
+	return expression, nil
+}
+
+// Diagnostics returns every diagnostic reported while parsing
+func (p *Parser) Diagnostics() diagnostic.List {
+	return p.diagnostics
 }
 
 // parseVariableDeclaration parses a variable declaration
 func (p *Parser) parseVariableDeclaration() (*ast.VariableDeclaration, error) {
-	if _, err := p.expect(token.LOC); err != nil {
+	locToken, err := p.expect(token.LOC)
+	if err != nil {
 		return nil, err
 	}
 	nameToken, err := p.expect(token.IDENTIFIER)
@@ -74,83 +116,136 @@ func (p *Parser) parseVariableDeclaration() (*ast.VariableDeclaration, error) {
 		return nil, err
 	}
 	address := addressToken.Literal
+	var variableType string
+	if next, err := p.peek(); err == nil && next.Type == token.COLON {
+		p.advance()
+		typeToken, err := p.expect(token.IDENTIFIER)
+		if err != nil {
+			return nil, err
+		}
+		if typeToken.Literal != "byte" && typeToken.Literal != "word" {
+			return nil, p.errorf(diagnostic.UnexpectedToken, typeToken.Span(), "unknown variable type '%s', expected 'byte' or 'word'", typeToken.Literal)
+		}
+		variableType = typeToken.Literal
+	}
 	if _, err := p.expect(token.SEMICOLON); err != nil {
 		return nil, err
 	}
 
 	return &ast.VariableDeclaration{
+		Span:    p.spanFrom(locToken.Pos),
 		Name:    name,
 		Address: address,
+		Type:    variableType,
 	}, nil
 }
 
 // parseStartBlock parses the 'start' block
 func (p *Parser) parseStartBlock() (*ast.StartBlock, error) {
-	if _, err := p.expect(token.START); err != nil {
+	startToken, err := p.expect(token.START)
+	if err != nil {
 		return nil, err
 	}
 	if _, err := p.expect(token.AT); err != nil {
 		return nil, err
 	}
-	addressToken, err := p.expect(token.HEXNUMBER)
+	targetToken, err := p.expectAny(token.HEXNUMBER, token.IDENTIFIER)
 	if err != nil {
 		return nil, err
 	}
-	address := addressToken.Literal
 	if _, err := p.expect(token.SEMICOLON); err != nil {
-		return nil, fmt.Errorf("expected semicolon after start block address")
+		return nil, p.errorAtCurrent("expected semicolon after start block address")
 	}
 
-	return &ast.StartBlock{
-		Address: address,
-	}, nil
+	startBlock := &ast.StartBlock{Span: p.spanFrom(startToken.Pos)}
+	if targetToken.Type == token.IDENTIFIER {
+		startBlock.Label = targetToken.Literal
+	} else {
+		startBlock.Address = targetToken.Literal
+	}
+	return startBlock, nil
 }
 
-// parseBlock parses a 'block'
+// parseBlock parses a 'block', in one of the forms 'block 0x0100', 'block main at 0x0100' or 'block main'
 func (p *Parser) parseBlock() (*ast.Block, error) {
-	if _, err := p.expect(token.BLOCK); err != nil {
+	blockToken, err := p.expect(token.BLOCK)
+	if err != nil {
 		return nil, err
 	}
-	addressToken, err := p.expect(token.HEXNUMBER)
+	var name, address string
+	headerToken, err := p.expectAny(token.HEXNUMBER, token.IDENTIFIER)
 	if err != nil {
 		return nil, err
 	}
-	address := addressToken.Literal
+	if headerToken.Type == token.HEXNUMBER {
+		address = headerToken.Literal
+	} else {
+		name = headerToken.Literal
+		nextToken, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if nextToken.Type == token.AT {
+			p.advance()
+			addressToken, err := p.expect(token.HEXNUMBER)
+			if err != nil {
+				return nil, err
+			}
+			address = addressToken.Literal
+		}
+	}
 	if _, err := p.expect(token.LBRACE); err != nil {
 		return nil, err
 	}
 	blockContent, err := p.parseBlockContent()
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse block content: %v", err)
+		return nil, err
 	}
+	blockContent.Name = name
 	blockContent.Address = address
+	blockContent.Span = p.spanFrom(blockToken.Pos)
 
 	return blockContent, nil
 }
 
-// parseBlockContent parses statements within a block until it encounters a closing brace
+// parseBlockContent parses statements within a block until it encounters a closing brace.
+// A statement that fails to parse is reported and skipped; the block itself only fails when
+// its closing brace cannot be found. A comment on the line of the opening brace stays with it.
 func (p *Parser) parseBlockContent() (*ast.Block, error) {
+	start := p.last.Pos
+	opening := p.trailingComment()
 	var statements []ast.Statement
+	var closing []*ast.Comment
 	for {
+		comments := p.takeComments()
 		currentToken, err := p.peek()
-		if err != nil {
-			return nil, err
+		if err != nil || isTopLevel(currentToken.Type) {
+			p.diagnostics.Add(p.errorAtCurrent("expected closing brace at the end of block"))
+			return nil, errRecovered
 		}
 		if currentToken.Type == token.RBRACE {
+			closing = comments
 			break
 		}
 		statement, err := p.parseStatement()
+		if err == errRecovered {
+			return nil, errRecovered
+		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse statement: %v", err)
+			p.recover(err, p.synchronizeStatement)
+			continue
 		}
+		statement.Attached().Leading = comments
+		statement.Attached().Trailing = p.trailingComment()
 		statements = append(statements, statement)
 	}
-	if _, err := p.expect(token.RBRACE); err != nil {
-		return nil, fmt.Errorf("expected closing brace at the end of block")
-	}
+	p.advance()
 
 	return &ast.Block{
+		Span:       p.spanFrom(start),
+		Opening:    opening,
 		Statements: statements,
+		Closing:    closing,
 	}, nil
 }
 
@@ -165,6 +260,8 @@ func (p *Parser) parseStatement() (ast.Statement, error) {
 		return p.parseAssignment()
 	case token.IF:
 		return p.parseIfStatement()
+	case token.WHILE:
+		return p.parseWhileStatement()
 	case token.CALL:
 		return p.parseCall()
 	case token.GOTO:
@@ -172,7 +269,7 @@ func (p *Parser) parseStatement() (ast.Statement, error) {
 	case token.MEM:
 		return p.parseMemoryAssignment()
 	default:
-		return nil, fmt.Errorf("unexpected token: %s", currentToken.Literal)
+		return nil, p.errorf(diagnostic.UnexpectedToken, currentToken.Span(), "unexpected token: %s", currentToken.Literal)
 	}
 }
 
@@ -188,20 +285,22 @@ func (p *Parser) parseAssignment() (*ast.Assignment, error) {
 	}
 	value, err := p.parseExpression()
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse expression in assignment: %v", err)
+		return nil, err
 	}
 	if _, err := p.expect(token.SEMICOLON); err != nil {
-		return nil, fmt.Errorf("expected semicolon after assignment")
+		return nil, p.errorAtCurrent("expected semicolon after assignment")
 	}
 	return &ast.Assignment{
+		Span:         p.spanFrom(variableToken.Pos),
 		VariableName: variable,
 		Expression:   value,
 	}, nil
 }
 
-// parseIfStatement parses an if statement with optional else block
+// parseIfStatement parses an if statement with an optional else block or else if chain
 func (p *Parser) parseIfStatement() (*ast.IfStatement, error) {
-	if _, err := p.expect(token.IF); err != nil {
+	ifToken, err := p.expect(token.IF)
+	if err != nil {
 		return nil, err
 	}
 	if _, err := p.expect(token.LPAREN); err != nil {
@@ -209,47 +308,113 @@ func (p *Parser) parseIfStatement() (*ast.IfStatement, error) {
 	}
 	condition, err := p.parseExpression()
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse condition of if statement: %v", err)
+		return nil, err
 	}
 	if _, err := p.expect(token.RPAREN); err != nil {
-		return nil, fmt.Errorf("expected closing parenthesis after if condition")
+		return nil, p.errorAtCurrent("expected closing parenthesis after if condition")
 	}
 	if _, err := p.expect(token.LBRACE); err != nil {
-		return nil, fmt.Errorf("expected opening brace for if block")
+		return nil, p.errorAtCurrent("expected opening brace for if block")
 	}
 	thenBlock, err := p.parseBlockContent()
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse then block: %v", err)
+		return nil, err
 	}
 
 	var elseBlock *ast.Block
+	var elseIf *ast.IfStatement
 	nextToken, err := p.peek()
 	if err != nil {
 		return nil, err
 	}
 	if nextToken.Type == token.ELSE {
+		// Comments before 'else' belong to the then block, not to the first statement after it
+		thenBlock.Trailing = p.trailingComment()
+		thenBlock.Closing = append(thenBlock.Closing, p.takeComments()...)
 		if _, err := p.expect(token.ELSE); err != nil {
 			return nil, err
 		}
-		if _, err := p.expect(token.LBRACE); err != nil {
-			return nil, fmt.Errorf("expected opening brace for else block")
-		}
-		elseBlock, err = p.parseBlockContent()
+		nextToken, err = p.peek()
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse else block: %v", err)
+			return nil, err
+		}
+		if nextToken.Type == token.IF {
+			elseIf, err = p.parseIfStatement()
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			if _, err := p.expect(token.LBRACE); err != nil {
+				return nil, p.errorAtCurrent("expected opening brace or 'if' after else")
+			}
+			elseBlock, err = p.parseBlockContent()
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
 
 	return &ast.IfStatement{
+		Span:                p.spanFrom(ifToken.Pos),
 		ConditionExpression: condition,
 		ThenBlock:           thenBlock,
 		ElseBlock:           elseBlock,
+		ElseIf:              elseIf,
+	}, nil
+}
+
+// parseWhileStatement parses a while loop
+func (p *Parser) parseWhileStatement() (*ast.WhileStatement, error) {
+	whileToken, err := p.expect(token.WHILE)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(token.LPAREN); err != nil {
+		return nil, err
+	}
+	condition, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(token.RPAREN); err != nil {
+		return nil, p.errorAtCurrent("expected closing parenthesis after while condition")
+	}
+	if _, err := p.expect(token.LBRACE); err != nil {
+		return nil, p.errorAtCurrent("expected opening brace for while body")
+	}
+	body, err := p.parseBlockContent()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ast.WhileStatement{
+		Span:                p.spanFrom(whileToken.Pos),
+		ConditionExpression: condition,
+		Body:                body,
 	}, nil
 }
 
-// parseCall parses a function call expression, including its parameters and semicolon.
+// parseCall parses a call statement, including its parameters and semicolon.
 func (p *Parser) parseCall() (*ast.Call, error) {
-	if _, err := p.expect(token.CALL); err != nil {
+	call, err := p.parseCallExpression()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(token.SEMICOLON); err != nil {
+		return nil, p.errorAtCurrent("expected semicolon after function call")
+	}
+
+	return &ast.Call{
+		Span:         p.spanFrom(call.Start),
+		FunctionName: call.FunctionName,
+		Parameters:   call.Parameters,
+	}, nil
+}
+
+// parseCallExpression parses a function call and its parameters, without a semicolon
+func (p *Parser) parseCallExpression() (*ast.CallExpression, error) {
+	callToken, err := p.expect(token.CALL)
+	if err != nil {
 		return nil, err
 	}
 	funcNameToken, err := p.expect(token.IDENTIFIER)
@@ -258,7 +423,7 @@ func (p *Parser) parseCall() (*ast.Call, error) {
 	}
 	funcName := funcNameToken.Literal
 	if _, err := p.expect(token.LPAREN); err != nil {
-		return nil, fmt.Errorf("expected opening parenthesis after function name")
+		return nil, p.errorAtCurrent("expected opening parenthesis after function name")
 	}
 
 	var params []ast.Expression
@@ -273,7 +438,7 @@ func (p *Parser) parseCall() (*ast.Call, error) {
 
 		param, err := p.parseParameter()
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse parameter: %v", err)
+			return nil, err
 		}
 		params = append(params, param)
 
@@ -282,22 +447,20 @@ func (p *Parser) parseCall() (*ast.Call, error) {
 			return nil, err
 		}
 		if nextToken.Type == token.COMMA {
-			p.current++
+			p.advance()
 		} else if nextToken.Type == token.RPAREN {
 			break
 		} else {
-			return nil, fmt.Errorf("expected comma or closing parenthesis, got %s", nextToken.Type)
+			return nil, p.errorf(diagnostic.ExpectedToken, nextToken.Span(), "expected comma or closing parenthesis, got %s", nextToken.Type)
 		}
 	}
 
 	if _, err := p.expect(token.RPAREN); err != nil {
-		return nil, fmt.Errorf("expected closing parenthesis after function parameters")
-	}
-	if _, err := p.expect(token.SEMICOLON); err != nil {
-		return nil, fmt.Errorf("expected semicolon after function call")
+		return nil, p.errorAtCurrent("expected closing parenthesis after function parameters")
 	}
 
-	return &ast.Call{
+	return &ast.CallExpression{
+		Span:         p.spanFrom(callToken.Pos),
 		FunctionName: funcName,
 		Parameters:   params,
 	}, nil
@@ -316,37 +479,45 @@ func (p *Parser) parseParameter() (ast.ParameterValue, error) {
 		if err != nil {
 			return nil, err
 		}
-		return &ast.MemoryLocation{Address: identifierToken.Literal}, nil
+		return &ast.MemoryLocation{Span: identifierToken.Span(), Address: identifierToken.Literal}, nil
 	case token.HEXNUMBER:
 		hexToken, err := p.expect(token.HEXNUMBER)
 		if err != nil {
 			return nil, err
 		}
-		return &ast.ByteValue{Value: hexToken.Literal}, nil
+		return &ast.ByteValue{Span: hexToken.Span(), Value: hexToken.Literal}, nil
 	default:
-		return nil, fmt.Errorf("expected identifier or hexadecimal number, but got %v", currentToken.Type)
+		return nil, p.errorf(diagnostic.UnexpectedToken, currentToken.Span(), "expected identifier or hexadecimal number, but got %v", currentToken.Type)
 	}
 }
 
 // parseGoto parses a goto statement
 func (p *Parser) parseGoto() (*ast.Goto, error) {
-	if _, err := p.expect(token.GOTO); err != nil {
+	gotoToken, err := p.expect(token.GOTO)
+	if err != nil {
 		return nil, err
 	}
-	addressToken, err := p.expect(token.HEXNUMBER)
+	targetToken, err := p.expectAny(token.HEXNUMBER, token.IDENTIFIER)
 	if err != nil {
 		return nil, err
 	}
 	if _, err := p.expect(token.SEMICOLON); err != nil {
-		return nil, fmt.Errorf("expected semicolon after goto statement")
+		return nil, p.errorAtCurrent("expected semicolon after goto statement")
 	}
 
-	return &ast.Goto{Address: addressToken.Literal}, nil
+	gotoStatement := &ast.Goto{Span: p.spanFrom(gotoToken.Pos)}
+	if targetToken.Type == token.IDENTIFIER {
+		gotoStatement.Label = targetToken.Literal
+	} else {
+		gotoStatement.Address = targetToken.Literal
+	}
+	return gotoStatement, nil
 }
 
 // parseMemoryAssignment parses a memory assignment statement (mem[addr] = value)
 func (p *Parser) parseMemoryAssignment() (*ast.MemoryAssignment, error) {
-	if _, err := p.expect(token.MEM); err != nil {
+	memToken, err := p.expect(token.MEM)
+	if err != nil {
 		return nil, err
 	}
 	if _, err := p.expect(token.LBRACKET); err != nil {
@@ -354,57 +525,129 @@ func (p *Parser) parseMemoryAssignment() (*ast.MemoryAssignment, error) {
 	}
 	address, err := p.parseExpression()
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse memory address: %v", err)
+		return nil, err
 	}
 	if _, err := p.expect(token.RBRACKET); err != nil {
-		return nil, fmt.Errorf("expected closing bracket after memory address")
+		return nil, p.errorAtCurrent("expected closing bracket after memory address")
 	}
 	if _, err := p.expect(token.ASSIGN); err != nil {
-		return nil, fmt.Errorf("expected equals sign after memory address")
+		return nil, p.errorAtCurrent("expected equals sign after memory address")
 	}
 	value, err := p.parseExpression()
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse value for memory assignment: %v", err)
+		return nil, err
 	}
 	if _, err := p.expect(token.SEMICOLON); err != nil {
-		return nil, fmt.Errorf("expected semicolon after memory assignment")
+		return nil, p.errorAtCurrent("expected semicolon after memory assignment")
 	}
 
 	return &ast.MemoryAssignment{
+		Span:          p.spanFrom(memToken.Pos),
 		MemoryAddress: address,
 		Value:         value,
 	}, nil
 }
 
-// parseExpression parses a complex binary expression
+// Operator precedence levels, from the loosest to the tightest binding.
+// Binary operators of the same level associate to the left.
+//
+//	LOGICAL_OR   ||
+//	LOGICAL_AND  &&
+//	EQUALS       ==  !=
+//	LESSGREATER  <   >   <=  >=
+//	SUM          +   -   |   ^
+//	PRODUCT      *   /   %   &   <<  >>
+//	PREFIX       -x  !x  ~x   (unary)
+//
+// Bitwise operators bind like their arithmetic counterparts, as in Go, so that masks such as
+// 'x & 0x04 == 0x04' compare the masked value.
+const (
+	_ int = iota
+	LOWEST
+	LOGICAL_OR
+	LOGICAL_AND
+	EQUALS
+	LESSGREATER
+	SUM
+	PRODUCT
+	PREFIX
+)
+
+// precedences maps each binary operator to its precedence level
+var precedences = map[token.TokenType]int{
+	token.OR:          LOGICAL_OR,
+	token.AND:         LOGICAL_AND,
+	token.EQ:          EQUALS,
+	token.NOT_EQ:      EQUALS,
+	token.LT:          LESSGREATER,
+	token.GT:          LESSGREATER,
+	token.LT_EQ:       LESSGREATER,
+	token.GT_EQ:       LESSGREATER,
+	token.PLUS:        SUM,
+	token.MINUS:       SUM,
+	token.PIPE:        SUM,
+	token.CARET:       SUM,
+	token.ASTERISK:    PRODUCT,
+	token.SLASH:       PRODUCT,
+	token.MOD:         PRODUCT,
+	token.AMPERSAND:   PRODUCT,
+	token.SHIFT_LEFT:  PRODUCT,
+	token.SHIFT_RIGHT: PRODUCT,
+}
+
+// Precedence returns the precedence level of a binary operator, or LOWEST for an unknown operator
+func Precedence(operator string) int {
+	tokenType, exists := token.MultiCharTokens[operator]
+	if !exists && len(operator) == 1 {
+		tokenType = token.SingleCharTokens[operator[0]]
+	}
+	if precedence, exists := precedences[tokenType]; exists {
+		return precedence
+	}
+	return LOWEST
+}
+
+// prefixOperators lists the tokens accepted as unary prefix operators
+var prefixOperators = map[token.TokenType]bool{
+	token.MINUS: true,
+	token.BANG:  true,
+	token.TILDE: true,
+}
+
+// parseExpression parses a complex expression, honoring operator precedence and parentheses
 func (p *Parser) parseExpression() (ast.Expression, error) {
-	leftExpression, err := p.parsePrimaryExpression()
+	return p.parseBinaryExpression(LOWEST)
+}
+
+// parseBinaryExpression parses a chain of binary operators using precedence climbing.
+// Only operators binding at least as tightly as minPrecedence are consumed, the right-hand
+// side of each operator is parsed one level higher, which makes operators left associative.
+func (p *Parser) parseBinaryExpression(minPrecedence int) (ast.Expression, error) {
+	leftExpression, err := p.parseUnaryExpression()
 	if err != nil {
 		return nil, err
 	}
 
 	for {
+		// The end of input ends the expression, the caller reports any missing token
 		nextToken, err := p.peek()
 		if err != nil {
-			return nil, err
-		}
-
-		if nextToken.Type != token.MOD && nextToken.Type != token.PLUS && nextToken.Type != token.MINUS && nextToken.Type != token.EQ &&
-			nextToken.Type != token.NOT_EQ && nextToken.Type != token.GT && nextToken.Type != token.LT && nextToken.Type != token.ASTERISK {
 			break
 		}
 
-		operatorToken, err := p.expectAny(token.MOD, token.PLUS, token.MINUS, token.EQ, token.NOT_EQ, token.GT, token.LT, token.ASTERISK)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse operator: %v", err)
+		precedence, isOperator := precedences[nextToken.Type]
+		if !isOperator || precedence < minPrecedence {
+			break
 		}
+		operatorToken := p.advance()
 
-		rightExpression, err := p.parsePrimaryExpression()
+		rightExpression, err := p.parseBinaryExpression(precedence + 1)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse right-hand expression: %v", err)
+			return nil, err
 		}
 
 		leftExpression = &ast.BinaryExpression{
+			Span:            token.Span{Start: leftExpression.Location().Start, End: rightExpression.Location().End},
 			LeftExpression:  leftExpression,
 			Operator:        operatorToken.Literal,
 			RightExpression: rightExpression,
@@ -414,7 +657,29 @@ func (p *Parser) parseExpression() (ast.Expression, error) {
 	return leftExpression, nil
 }
 
-// parsePrimaryExpression parses the most basic expressions (constants, variables, memory access)
+// parseUnaryExpression parses an expression optionally preceded by prefix operators (e.g., -x, !flag, ~mask)
+func (p *Parser) parseUnaryExpression() (ast.Expression, error) {
+	currentToken, err := p.peek()
+	if err != nil {
+		return nil, err
+	}
+	if !prefixOperators[currentToken.Type] {
+		return p.parsePrimaryExpression()
+	}
+	operatorToken := p.advance()
+	operand, err := p.parseUnaryExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ast.UnaryExpression{
+		Span:       p.spanFrom(operatorToken.Pos),
+		Operator:   operatorToken.Literal,
+		Expression: operand,
+	}, nil
+}
+
+// parsePrimaryExpression parses the most basic expressions (constants, variables, memory access, parenthesized expressions)
 func (p *Parser) parsePrimaryExpression() (ast.Expression, error) {
 	currentToken, err := p.peek()
 	if err != nil {
@@ -423,25 +688,38 @@ func (p *Parser) parsePrimaryExpression() (ast.Expression, error) {
 
 	switch currentToken.Type {
 	case token.IDENTIFIER:
-		p.current++
-		return &ast.Variable{Name: currentToken.Literal}, nil
+		p.advance()
+		return &ast.Variable{Span: currentToken.Span(), Name: currentToken.Literal}, nil
 	case token.HEXNUMBER:
-		p.current++
-		return &ast.Constant{Value: currentToken.Literal}, nil
+		p.advance()
+		return &ast.Constant{Span: currentToken.Span(), Value: currentToken.Literal}, nil
 	case token.MEM:
 		expr, err := p.parseMemoryAccess()
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse memory access: %v", err)
+			return nil, err
+		}
+		return expr, nil
+	case token.CALL:
+		return p.parseCallExpression()
+	case token.LPAREN:
+		p.advance()
+		expr, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(token.RPAREN); err != nil {
+			return nil, p.errorAtCurrent("expected closing parenthesis after expression")
 		}
 		return expr, nil
 	default:
-		return nil, fmt.Errorf("unexpected token: %v", currentToken.Type)
+		return nil, p.errorf(diagnostic.UnexpectedToken, currentToken.Span(), "unexpected token: %v", currentToken.Type)
 	}
 }
 
 // parseMemoryAccess parses expressions like mem[0x0005]
 func (p *Parser) parseMemoryAccess() (ast.Expression, error) {
-	if _, err := p.expect(token.MEM); err != nil {
+	memToken, err := p.expect(token.MEM)
+	if err != nil {
 		return nil, err
 	}
 	if _, err := p.expect(token.LBRACKET); err != nil {
@@ -449,13 +727,13 @@ func (p *Parser) parseMemoryAccess() (ast.Expression, error) {
 	}
 	addressToken, err := p.expect(token.HEXNUMBER)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse memory address: %v", err)
+		return nil, err
 	}
 	if _, err := p.expect(token.RBRACKET); err != nil {
-		return nil, fmt.Errorf("expected closing bracket after memory address")
+		return nil, p.errorAtCurrent("expected closing bracket after memory address")
 	}
 
-	return &ast.MemoryLocation{Address: addressToken.Literal}, nil
+	return &ast.MemoryLocation{Span: p.spanFrom(memToken.Pos), Address: addressToken.Literal}, nil
 }
 
 // expect consumes the next token if it matches the expected type
@@ -465,20 +743,11 @@ func (p *Parser) expect(expectedType token.TokenType) (token.Token, error) {
 		return token.Token{}, err
 	}
 
-	for currentToken.Type == token.COMMENT {
-		p.current++
-		currentToken, err = p.peek()
-		if err != nil {
-			return token.Token{}, err
-		}
-	}
-
 	if currentToken.Type != expectedType {
-		return token.Token{}, fmt.Errorf("expected token %v but got %v", expectedType, currentToken.Type)
+		return token.Token{}, p.errorf(diagnostic.ExpectedToken, currentToken.Span(), "expected token %v but got %v", expectedType, currentToken.Type)
 	}
 
-	p.current++
-	return currentToken, nil
+	return p.advance(), nil
 }
 
 // expectAny consumes the next token if it matches any of the expected types
@@ -488,37 +757,167 @@ func (p *Parser) expectAny(expectedTypes ...token.TokenType) (token.Token, error
 		return token.Token{}, err
 	}
 
-	for currentToken.Type == token.COMMENT {
-		p.current++
-		currentToken, err = p.peek()
-		if err != nil {
-			return token.Token{}, err
-		}
-	}
-
 	for _, expectedType := range expectedTypes {
 		if currentToken.Type == expectedType {
-			p.current++
-			return currentToken, nil
+			return p.advance(), nil
 		}
 	}
 
-	return token.Token{}, fmt.Errorf("expected one of %v but got %v", expectedTypes, currentToken.Type)
+	return token.Token{}, p.errorf(diagnostic.ExpectedToken, currentToken.Span(), "expected one of %v but got %v", expectedTypes, currentToken.Type)
 }
 
-// peek returns the current token without consuming it and skips comment tokens
+// peek returns the current token without consuming it. Comment tokens are skipped and kept
+// until they are attached to a node.
 func (p *Parser) peek() (token.Token, error) {
-	if p.current >= len(p.tokens) {
-		return token.Token{}, fmt.Errorf("reached end of input")
-	}
-
 	for p.current < len(p.tokens) && p.tokens[p.current].Type == token.COMMENT {
+		p.comments = append(p.comments, newComment(p.tokens[p.current]))
 		p.current++
 	}
 
 	if p.current >= len(p.tokens) {
-		return token.Token{}, fmt.Errorf("reached end of input")
+		return token.Token{}, p.errorf(diagnostic.UnexpectedEOF, p.endOfInput(), "reached end of input")
 	}
 
 	return p.tokens[p.current], nil
 }
+
+// advance consumes the current token and returns it
+func (p *Parser) advance() token.Token {
+	p.last = p.tokens[p.current]
+	p.current++
+	return p.last
+}
+
+// takeComments returns the comments skipped before the next token and not yet attached to a node
+func (p *Parser) takeComments() []*ast.Comment {
+	p.peek()
+	comments := p.comments
+	p.comments = nil
+	return comments
+}
+
+// trailingComment consumes the comment that follows the last consumed token on the same line,
+// if any
+func (p *Parser) trailingComment() *ast.Comment {
+	line := p.last.End.Line
+	if len(p.comments) > 0 && p.comments[0].Start.Line == line {
+		comment := p.comments[0]
+		p.comments = p.comments[1:]
+		return comment
+	}
+	if len(p.comments) == 0 && p.current < len(p.tokens) && p.tokens[p.current].Type == token.COMMENT && p.tokens[p.current].Pos.Line == line {
+		comment := newComment(p.tokens[p.current])
+		p.current++
+		return comment
+	}
+	return nil
+}
+
+// newComment returns the comment node of a comment token
+func newComment(tok token.Token) *ast.Comment {
+	return &ast.Comment{Span: tok.Span(), Text: tok.Literal}
+}
+
+// endOfInput returns an empty span located right after the last token of the input
+func (p *Parser) endOfInput() token.Span {
+	if len(p.tokens) == 0 {
+		return token.Span{}
+	}
+	end := p.tokens[len(p.tokens)-1].End
+	return token.Span{Start: end, End: end}
+}
+
+// spanFrom returns the span from the given position to the end of the last consumed token
+func (p *Parser) spanFrom(start token.Position) token.Span {
+	return token.Span{Start: start, End: p.last.End}
+}
+
+// errorf creates a syntax error diagnostic
+func (p *Parser) errorf(code string, span token.Span, format string, args ...interface{}) *diagnostic.Diagnostic {
+	return diagnostic.Errorf(code, span, format, args...)
+}
+
+// errorAtCurrent creates a syntax error located at the token that is about to be consumed
+func (p *Parser) errorAtCurrent(format string, args ...interface{}) *diagnostic.Diagnostic {
+	currentToken, err := p.peek()
+	if err != nil {
+		return p.errorf(diagnostic.UnexpectedEOF, p.endOfInput(), format, args...)
+	}
+	return p.errorf(diagnostic.ExpectedToken, currentToken.Span(), format, args...)
+}
+
+// recover records a syntax error, unless it was already recorded, and skips the remaining
+// tokens of the failed construct using the given synchronization function
+func (p *Parser) recover(err error, synchronize func()) {
+	if err == errRecovered {
+		return
+	}
+	p.diagnostics.AddError(err)
+	synchronize()
+}
+
+// synchronizeStatement skips tokens until the end of the current statement: a ';' (consumed),
+// a '}' closing the enclosing block (not consumed), or the start of a top-level declaration.
+// Braces opened along the way are skipped as a unit, so the body of a broken 'if' is ignored.
+func (p *Parser) synchronizeStatement() {
+	depth := 0
+	for {
+		currentToken, err := p.peek()
+		if err != nil {
+			return
+		}
+		switch currentToken.Type {
+		case token.SEMICOLON:
+			p.advance()
+			if depth == 0 {
+				return
+			}
+		case token.LBRACE:
+			p.advance()
+			depth++
+		case token.RBRACE:
+			if depth == 0 {
+				return
+			}
+			p.advance()
+			depth--
+			if depth == 0 {
+				return
+			}
+		default:
+			if depth == 0 && isTopLevel(currentToken.Type) {
+				return
+			}
+			p.advance()
+		}
+	}
+}
+
+// synchronizeTopLevel skips tokens until the start of the next top-level declaration
+func (p *Parser) synchronizeTopLevel() {
+	depth := 0
+	for {
+		currentToken, err := p.peek()
+		if err != nil {
+			return
+		}
+		switch currentToken.Type {
+		case token.LBRACE:
+			depth++
+		case token.RBRACE:
+			if depth > 0 {
+				depth--
+			}
+		default:
+			if depth == 0 && isTopLevel(currentToken.Type) {
+				return
+			}
+		}
+		p.advance()
+	}
+}
+
+// isTopLevel reports whether a token type starts a top-level declaration
+func isTopLevel(tokenType token.TokenType) bool {
+	return tokenType == token.LOC || tokenType == token.START || tokenType == token.BLOCK
+}
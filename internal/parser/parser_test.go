@@ -0,0 +1,232 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"cyone/internal/ast"
+	"cyone/internal/lexer"
+)
+
+// tree prints a syntax tree node fully parenthesized, with operators first, so that tests
+// compare the grouping chosen by the parser
+func tree(node ast.Node) string {
+	switch node := node.(type) {
+	case *ast.Variable:
+		return node.Name
+	case *ast.Constant:
+		return node.Value
+	case *ast.ByteValue:
+		return node.Value
+	case *ast.MemoryLocation:
+		return "mem[" + node.Address + "]"
+	case *ast.BinaryExpression:
+		return fmt.Sprintf("(%s %s %s)", node.Operator, tree(node.LeftExpression), tree(node.RightExpression))
+	case *ast.UnaryExpression:
+		return fmt.Sprintf("(%s %s)", node.Operator, tree(node.Expression))
+	case *ast.CallExpression:
+		return fmt.Sprintf("(call %s%s)", node.FunctionName, parameters(node.Parameters))
+	case *ast.Assignment:
+		return fmt.Sprintf("(= %s %s)", node.VariableName, tree(node.Expression))
+	case *ast.MemoryAssignment:
+		return fmt.Sprintf("(= mem[%s] %s)", tree(node.MemoryAddress), tree(node.Value))
+	case *ast.Call:
+		return fmt.Sprintf("(call %s%s)", node.FunctionName, parameters(node.Parameters))
+	case *ast.Goto:
+		return fmt.Sprintf("(goto %s%s)", node.Label, node.Address)
+	case *ast.WhileStatement:
+		return fmt.Sprintf("(while %s %s)", tree(node.ConditionExpression), tree(node.Body))
+	case *ast.IfStatement:
+		text := fmt.Sprintf("(if %s %s", tree(node.ConditionExpression), tree(node.ThenBlock))
+		if node.ElseIf != nil {
+			text += " " + tree(node.ElseIf)
+		} else if node.ElseBlock != nil {
+			text += " " + tree(node.ElseBlock)
+		}
+		return text + ")"
+	case *ast.Block:
+		statements := make([]string, len(node.Statements))
+		for i, statement := range node.Statements {
+			statements[i] = tree(statement)
+		}
+		return "{" + strings.Join(statements, " ") + "}"
+	default:
+		return fmt.Sprintf("<%T>", node)
+	}
+}
+
+func parameters(expressions []ast.Expression) string {
+	text := ""
+	for _, expression := range expressions {
+		text += " " + tree(expression)
+	}
+	return text
+}
+
+// parse parses a source and fails the test on any diagnostic
+func parse(t *testing.T, source string) *ast.Program {
+	t.Helper()
+	tokens, diagnostics := lexer.NewFileLexer("test.cyo", source).Tokenize()
+	p := NewParser(tokens)
+	program, _ := p.Parse()
+	diagnostics.Add(p.Diagnostics()...)
+	for _, d := range diagnostics {
+		t.Error(d.String())
+	}
+	if t.Failed() {
+		t.FailNow()
+	}
+	return program
+}
+
+func TestParseExpressionPrecedence(t *testing.T) {
+	tests := []struct {
+		expression string
+		expected   string
+	}{
+		{"a + b * c", "(+ a (* b c))"},
+		{"a * b + c", "(+ (* a b) c)"},
+		{"(a + b) * c", "(* (+ a b) c)"},
+		{"a - b - c", "(- (- a b) c)"},
+		{"a / b % c", "(% (/ a b) c)"},
+		{"a + b - c | d ^ e", "(^ (| (- (+ a b) c) d) e)"},
+		{"a & b << c >> d", "(>> (<< (& a b) c) d)"},
+		{"a | b & c", "(| a (& b c))"},
+		{"mem[0x0020] & 0x04 == 0x04", "(== (& mem[0x0020] 0x04) 0x04)"},
+		{"a < b == c > d", "(== (< a b) (> c d))"},
+		{"a <= b != c >= d", "(!= (<= a b) (>= c d))"},
+		{"a == b && c != d", "(&& (== a b) (!= c d))"},
+		{"a || b && c", "(|| a (&& b c))"},
+		{"a && b || c && d", "(|| (&& a b) (&& c d))"},
+		{"-a * b", "(* (- a) b)"},
+		{"!a == b", "(== (! a) b)"},
+		{"~~a", "(~ (~ a))"},
+		{"-(a + b)", "(- (+ a b))"},
+		{"!(a == b)", "(! (== a b))"},
+		{"((a))", "a"},
+		{"call READ_ADC (0x02) + 0x01", "(+ (call READ_ADC 0x02) 0x01)"},
+		{"call READ_ADC (x, 0x0010) * 0x02", "(* (call READ_ADC mem[x] 0x0010) 0x02)"},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			tokens, diagnostics := lexer.NewLexer(test.expression).Tokenize()
+			if diagnostics.HasErrors() {
+				t.Fatalf("lexer errors: %v", diagnostics)
+			}
+			expression, err := NewParser(tokens).ParseExpression()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tree(expression); got != test.expected {
+				t.Errorf("got %s, expected %s", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestPrecedenceLevels(t *testing.T) {
+	levels := [][]string{
+		{"*", "/", "%", "&", "<<", ">>"},
+		{"+", "-", "|", "^"},
+		{"<", ">", "<=", ">="},
+		{"==", "!="},
+		{"&&"},
+		{"||"},
+	}
+	for i, level := range levels {
+		for _, operator := range level {
+			if Precedence(operator) != Precedence(level[0]) {
+				t.Errorf("%s does not bind like %s", operator, level[0])
+			}
+			if i > 0 && Precedence(operator) >= Precedence(levels[i-1][0]) {
+				t.Errorf("%s binds as tightly as %s", operator, levels[i-1][0])
+			}
+		}
+	}
+	if Precedence("=") != LOWEST {
+		t.Errorf("'=' is not a binary operator")
+	}
+}
+
+func TestParseProgram(t *testing.T) {
+	program := parse(t, `
+loc x at 0x0000;
+loc ptr at 0x0010 : word;
+loc y at 0x0001 : byte;
+start at main;
+block main {
+    x = 0x01;
+    mem[0x0002] = x + 0x01;
+    if (x == 0x01) { x = 0x02; } else if (x == 0x03) { x = 0x04; } else { x = 0x05; }
+    while (x < 0x0A) { x = x + 0x01; }
+    call DRAW_CIRCLE (x, 0x20, 0x05);
+    goto done;
+}
+block done at 0x0300 { goto 0x0100; }
+block 0x0400 { y = call READ_ADC (0x02); }
+`)
+
+	variables := []struct{ name, address, kind string }{
+		{"x", "0x0000", ""},
+		{"ptr", "0x0010", "word"},
+		{"y", "0x0001", "byte"},
+	}
+	if len(program.Variables) != len(variables) {
+		t.Fatalf("got %d variables, expected %d", len(program.Variables), len(variables))
+	}
+	for i, expected := range variables {
+		v := program.Variables[i]
+		if v.Name != expected.name || v.Address != expected.address || v.Type != expected.kind {
+			t.Errorf("variable %d is %s at %s : %s, expected %s at %s : %s", i, v.Name, v.Address, v.Type, expected.name, expected.address, expected.kind)
+		}
+	}
+	if !program.Variables[1].IsWord() || program.Variables[2].IsWord() {
+		t.Errorf("only ptr is a word variable")
+	}
+	if program.Start == nil || program.Start.Label != "main" {
+		t.Errorf("start is %+v, expected main", program.Start)
+	}
+
+	blocks := []struct{ name, address, statements string }{
+		{"main", "", "{(= x 0x01) (= mem[0x0002] (+ x 0x01)) " +
+			"(if (== x 0x01) {(= x 0x02)} (if (== x 0x03) {(= x 0x04)} {(= x 0x05)})) " +
+			"(while (< x 0x0A) {(= x (+ x 0x01))}) (call DRAW_CIRCLE mem[x] 0x20 0x05) (goto done)}"},
+		{"done", "0x0300", "{(goto 0x0100)}"},
+		{"", "0x0400", "{(= y (call READ_ADC 0x02))}"},
+	}
+	if len(program.Blocks) != len(blocks) {
+		t.Fatalf("got %d blocks, expected %d", len(program.Blocks), len(blocks))
+	}
+	for i, expected := range blocks {
+		block := program.Blocks[i]
+		if block.Name != expected.name || block.Address != expected.address {
+			t.Errorf("block %d is '%s' at '%s', expected '%s' at '%s'", i, block.Name, block.Address, expected.name, expected.address)
+		}
+		if got := tree(block); got != expected.statements {
+			t.Errorf("block %d:\ngot      %s\nexpected %s", i, got, expected.statements)
+		}
+	}
+}
+
+func TestParseSpans(t *testing.T) {
+	program := parse(t, "loc x at 0x0000;\nblock 0x0100 {\n    x = -x + 0x01;\n}\n")
+	assignment := program.Blocks[0].Statements[0].(*ast.Assignment)
+	sum := assignment.Expression.(*ast.BinaryExpression)
+	negation := sum.LeftExpression.(*ast.UnaryExpression)
+	spans := []struct {
+		node                    ast.Node
+		line, column, endColumn int
+	}{
+		{assignment, 3, 5, 19},
+		{sum, 3, 9, 18},
+		{negation, 3, 9, 11},
+		{sum.RightExpression, 3, 14, 18},
+	}
+	for _, test := range spans {
+		span := test.node.Location()
+		if span.Start.Filename != "test.cyo" || span.Start.Line != test.line || span.Start.Column != test.column || span.End.Column != test.endColumn {
+			t.Errorf("%s spans %s, expected %d:%d-%d", tree(test.node), span, test.line, test.column, test.endColumn)
+		}
+	}
+}
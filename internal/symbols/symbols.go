@@ -0,0 +1,221 @@
+// Package symbols reads and writes symbol files. A symbol file is a JSON document emitted next
+// to an image by the build, which relates the addresses of the image to the program it was
+// compiled from: the names of the variables, the address range of every block, the start vector
+// and the source location of every statement.
+package symbols
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"cyone/internal/ast"
+	"cyone/internal/bytecode"
+	"cyone/internal/disasm"
+	"cyone/internal/token"
+)
+
+// Address is a code or data address, written as a hexadecimal string such as "0x0100"
+type Address uint16
+
+// MarshalJSON writes an address as a hexadecimal string
+func (a Address) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fmt.Sprintf("0x%04X", uint16(a)))
+}
+
+// UnmarshalJSON reads an address written as a string in any base accepted by the compiler
+func (a *Address) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("address must be a string such as \"0x0100\"")
+	}
+	value, err := strconv.ParseUint(text, 0, 16)
+	if err != nil {
+		return fmt.Errorf("invalid address '%s'", text)
+	}
+	*a = Address(value)
+	return nil
+}
+
+// File is the content of a symbol file
+type File struct {
+	Source    string     `json:"source,omitempty"` // Name of the compiled source file
+	Start     *Start     `json:"start,omitempty"`
+	Variables []Variable `json:"variables"`
+	Blocks    []Block    `json:"blocks"`
+	Lines     []Line     `json:"lines,omitempty"` // Sorted by address
+}
+
+// Start is the start vector of a program
+type Start struct {
+	Address Address `json:"address"`         // Address of the first block executed
+	Block   string  `json:"block,omitempty"` // Label of that block
+}
+
+// Variable is a variable declared with 'loc'. Type is "word" for a word variable, and is empty
+// for a byte.
+type Variable struct {
+	Name    string  `json:"name"`
+	Address Address `json:"address"`
+	Type    string  `json:"type,omitempty"`
+}
+
+// Block is the address range of a block, from its opcode to its closing brace. Files written by
+// hand may omit the end, in which case the block only names its start address.
+type Block struct {
+	Name  string  `json:"name,omitempty"`
+	Start Address `json:"start"`
+	End   Address `json:"end"`
+}
+
+// Line is the source location of the statement starting at an address
+type Line struct {
+	Address   Address `json:"address"`
+	Block     Address `json:"block"`  // Address of the block holding the statement
+	Offset    int     `json:"offset"` // Offset of the statement from the start of its block
+	Line      int     `json:"line"`
+	Column    int     `json:"column"`
+	EndLine   int     `json:"endLine"`
+	EndColumn int     `json:"endColumn"`
+}
+
+// New builds the symbol file of a program compiled from filename
+func New(filename string, program *ast.Program, bytecodes []bytecode.Bytecode) *File {
+	f := &File{Source: filename, Variables: []Variable{}, Blocks: []Block{}}
+	for _, variable := range program.Variables {
+		address, err := strconv.ParseUint(variable.Address, 0, 16)
+		if err != nil {
+			continue
+		}
+		f.Variables = append(f.Variables, Variable{Name: variable.Name, Address: Address(address), Type: variableType(variable)})
+	}
+
+	for _, bc := range bytecodes {
+		switch bc.Opcode {
+		case token.OP_START:
+			if len(bc.Operands) < 2 {
+				continue
+			}
+			f.Start = &Start{Address: Address(uint16(bc.Operands[0])<<8 | uint16(bc.Operands[1]))}
+		case token.OP_BLOCK:
+			block := Block{Start: Address(bc.Address), End: Address(int(bc.Address) + len(bc.Operands))}
+			if bc.Block != nil {
+				block.Name = bc.Block.Name
+			}
+			f.Blocks = append(f.Blocks, block)
+			for _, line := range bc.Lines {
+				f.Lines = append(f.Lines, Line{
+					Address:   Address(line.Address),
+					Block:     Address(bc.Address),
+					Offset:    int(line.Address - bc.Address),
+					Line:      line.Span.Start.Line,
+					Column:    line.Span.Start.Column,
+					EndLine:   line.Span.End.Line,
+					EndColumn: line.Span.End.Column,
+				})
+			}
+		}
+	}
+	if f.Start != nil {
+		if block, exists := f.Block(uint16(f.Start.Address)); exists && block.Start == f.Start.Address {
+			f.Start.Block = block.Name
+		}
+	}
+	sort.Slice(f.Lines, func(i, j int) bool { return f.Lines[i].Address < f.Lines[j].Address })
+	return f
+}
+
+// Read decodes a symbol file
+func Read(r io.Reader) (*File, error) {
+	var f File
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return nil, err
+	}
+	sort.Slice(f.Lines, func(i, j int) bool { return f.Lines[i].Address < f.Lines[j].Address })
+	return &f, nil
+}
+
+// Write encodes a symbol file as indented JSON
+func (f *File) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(f)
+}
+
+// Block returns the block whose address range holds a code address
+func (f *File) Block(address uint16) (Block, bool) {
+	for _, block := range f.Blocks {
+		if uint16(block.Start) <= address && address <= uint16(block.End) {
+			return block, true
+		}
+	}
+	return Block{}, false
+}
+
+// Locate returns the source location of the code at an address: the location of the last
+// statement starting at or before the address in the same block
+func (f *File) Locate(address uint16) (Line, bool) {
+	block, exists := f.Block(address)
+	if !exists {
+		return Line{}, false
+	}
+	i := sort.Search(len(f.Lines), func(i int) bool { return uint16(f.Lines[i].Address) > address })
+	if i == 0 || f.Lines[i-1].Block != block.Start {
+		return Line{}, false
+	}
+	return f.Lines[i-1], true
+}
+
+// Describe formats the location of the code at an address, such as "example.cyo:14:5 in block
+// main". It is empty when the address is outside every block.
+func (f *File) Describe(address uint16) string {
+	block, exists := f.Block(address)
+	if !exists {
+		return ""
+	}
+	name := block.Name
+	if name == "" {
+		name = fmt.Sprintf("0x%04X", uint16(block.Start))
+	}
+	line, exists := f.Locate(address)
+	if !exists {
+		return "in block " + name
+	}
+	position := token.Position{Filename: f.Source, Line: line.Line, Column: line.Column}
+	return fmt.Sprintf("%s in block %s", position, name)
+}
+
+// variableType returns the type recorded in a symbol file for a variable declaration
+func variableType(variable *ast.VariableDeclaration) string {
+	if variable.IsWord() {
+		return "word"
+	}
+	return ""
+}
+
+// Disasm returns the names and source locations used to annotate disassembled images
+func (f *File) Disasm() *disasm.Symbols {
+	symbols := &disasm.Symbols{
+		Variables: make(map[uint16]string, len(f.Variables)),
+		Words:     make(map[uint16]bool),
+		Blocks:    make(map[uint16]string, len(f.Blocks)),
+		Lines:     make(map[uint16]token.Position, len(f.Lines)),
+	}
+	for _, variable := range f.Variables {
+		symbols.Variables[uint16(variable.Address)] = variable.Name
+		if variable.Type == "word" {
+			symbols.Words[uint16(variable.Address)] = true
+		}
+	}
+	for _, block := range f.Blocks {
+		if block.Name != "" {
+			symbols.Blocks[uint16(block.Start)] = block.Name
+		}
+	}
+	for _, line := range f.Lines {
+		symbols.Lines[uint16(line.Address)] = token.Position{Filename: f.Source, Line: line.Line, Column: line.Column}
+	}
+	return symbols
+}
@@ -1,5 +1,7 @@
 package token
 
+import "fmt"
+
 // TokenType represents the type of token
 type TokenType string
 
@@ -7,6 +9,57 @@ type TokenType string
 type Token struct {
 	Type    TokenType
 	Literal string
+	Pos     Position // Position of the first character of the token
+	End     Position // Position immediately after the last character of the token
+}
+
+// Span returns the source span covered by the token
+func (t Token) Span() Span {
+	return Span{Start: t.Pos, End: t.End}
+}
+
+// Position represents a location in the source code
+type Position struct {
+	Filename string // Name of the source file, may be empty
+	Offset   int    // Byte offset, starting at 0
+	Line     int    // Line number, starting at 1
+	Column   int    // Column number in bytes, starting at 1
+}
+
+// IsValid reports whether the position carries line information
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+// String formats the position as file:line:column, omitting the parts that are unknown
+func (p Position) String() string {
+	s := p.Filename
+	if p.IsValid() {
+		if s != "" {
+			s += ":"
+		}
+		s += fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	if s == "" {
+		s = "-"
+	}
+	return s
+}
+
+// Span represents the range of source code between two positions
+type Span struct {
+	Start Position // Position of the first character
+	End   Position // Position immediately after the last character
+}
+
+// Location returns the span itself, so types embedding a Span expose their location
+func (s Span) Location() Span {
+	return s
+}
+
+// String formats the span using its start position
+func (s Span) String() string {
+	return s.Start.String()
 }
 
 // Define all different types of tokens
@@ -29,6 +82,7 @@ const (
 	GOTO  TokenType = "GOTO"  // Value for the 'GOTO' keyword
 	CALL  TokenType = "CALL"  // Value for the 'CALL' keyword
 	TO    TokenType = "TO"    // Value for the 'TO' keyword
+	WHILE TokenType = "WHILE" // Value for the 'WHILE' keyword
 
 	// Operators
 	ASSIGN   TokenType = "ASSIGN"   // Value for the '=' operator
@@ -40,7 +94,20 @@ const (
 	NOT_EQ   TokenType = "NOT_EQ"   // Value for the '!=' operator
 	GT       TokenType = "GT"       // Value for the '>' operator
 	LT       TokenType = "LT"       // Value for the '<' operator
-	MOD      TokenType = "MOD"      // Value for the '<' operator
+	MOD      TokenType = "MOD"      // Value for the '%' operator
+	BANG     TokenType = "BANG"     // Value for the '!' operator
+	GT_EQ    TokenType = "GT_EQ"    // Value for the '>=' operator
+	LT_EQ    TokenType = "LT_EQ"    // Value for the '<=' operator
+	AND      TokenType = "AND"      // Value for the '&&' operator
+	OR       TokenType = "OR"       // Value for the '||' operator
+
+	// Bitwise operators
+	AMPERSAND   TokenType = "AMPERSAND"   // Value for the '&' operator
+	PIPE        TokenType = "PIPE"        // Value for the '|' operator
+	CARET       TokenType = "CARET"       // Value for the '^' operator
+	TILDE       TokenType = "TILDE"       // Value for the '~' operator
+	SHIFT_LEFT  TokenType = "SHIFT_LEFT"  // Value for the '<<' operator
+	SHIFT_RIGHT TokenType = "SHIFT_RIGHT" // Value for the '>>' operator
 
 	// Delimiters
 	COMMA     TokenType = "COMMA"     // Value for the ',' delimiter
@@ -69,6 +136,7 @@ var Keywords = map[string]TokenType{
 	"goto":  GOTO,
 	"call":  CALL,
 	"to":    TO,
+	"while": WHILE,
 }
 
 // Map of single character tokens for quick lookup
@@ -90,11 +158,22 @@ var SingleCharTokens = map[byte]TokenType{
 	'>': GT,
 	'<': LT,
 	'%': MOD,
+	'!': BANG,
+	'&': AMPERSAND,
+	'|': PIPE,
+	'^': CARET,
+	'~': TILDE,
 }
 
 var MultiCharTokens = map[string]TokenType{
 	"==": EQ,
 	"!=": NOT_EQ,
+	"<<": SHIFT_LEFT,
+	">>": SHIFT_RIGHT,
+	">=": GT_EQ,
+	"<=": LT_EQ,
+	"&&": AND,
+	"||": OR,
 }
 
 // Define opcodes for each TokenType
@@ -133,50 +212,66 @@ const (
 	OP_LBRACKET   byte = 0x1F
 	OP_RBRACKET   byte = 0x20
 	OP_COMMENT    byte = 0x21
+	OP_BANG       byte = 0x22
+	OP_AMPERSAND  byte = 0x23
+	OP_PIPE       byte = 0x24
+	OP_CARET      byte = 0x25
+	OP_TILDE      byte = 0x26
+	OP_SHL        byte = 0x27
+	OP_SHR        byte = 0x28
+	OP_AND        byte = 0x29
+	OP_OR         byte = 0x2A
+	OP_GT_EQ      byte = 0x2B
+	OP_LT_EQ      byte = 0x2C
+	OP_WHILE      byte = 0x2D
 )
 
-// Map of function names to their respective opcodes
-var FunctionOpCodes = map[string]byte{
-	"DRAW_LINE":      0x00,
-	"DRAW_CIRCLE":    0x01,
-	"SET_COLOR":      0x02,
-	"DRAW_RECTANGLE": 0x03,
-}
-
 // Map of TokenType to opcode
 var TokenOpcodes = map[TokenType]byte{
-	ILLEGAL:    OP_ILLEGAL,
-	EOF:        OP_EOF,
-	IDENTIFIER: OP_IDENTIFIER,
-	HEXNUMBER:  OP_HEXNUMBER,
-	LOC:        OP_LOC,
-	AT:         OP_AT,
-	START:      OP_START,
-	BLOCK:      OP_BLOCK,
-	MEM:        OP_MEM,
-	IF:         OP_IF,
-	ELSE:       OP_ELSE,
-	GOTO:       OP_GOTO,
-	CALL:       OP_CALL,
-	TO:         OP_TO,
-	ASSIGN:     OP_ASSIGN,
-	PLUS:       OP_PLUS,
-	MINUS:      OP_MINUS,
-	ASTERISK:   OP_ASTERISK,
-	SLASH:      OP_SLASH,
-	EQ:         OP_EQ,
-	NOT_EQ:     OP_NOT_EQ,
-	GT:         OP_GT,
-	LT:         OP_LT,
-	MOD:        OP_MOD,
-	COMMA:      OP_COMMA,
-	SEMICOLON:  OP_SEMICOLON,
-	COLON:      OP_COLON,
-	LPAREN:     OP_LPAREN,
-	RPAREN:     OP_RPAREN,
-	LBRACE:     OP_LBRACE,
-	RBRACE:     OP_RBRACE,
-	LBRACKET:   OP_LBRACKET,
-	RBRACKET:   OP_RBRACKET,
-	COMMENT:    OP_COMMENT,
+	ILLEGAL:     OP_ILLEGAL,
+	EOF:         OP_EOF,
+	IDENTIFIER:  OP_IDENTIFIER,
+	HEXNUMBER:   OP_HEXNUMBER,
+	LOC:         OP_LOC,
+	AT:          OP_AT,
+	START:       OP_START,
+	BLOCK:       OP_BLOCK,
+	MEM:         OP_MEM,
+	IF:          OP_IF,
+	ELSE:        OP_ELSE,
+	GOTO:        OP_GOTO,
+	CALL:        OP_CALL,
+	TO:          OP_TO,
+	ASSIGN:      OP_ASSIGN,
+	PLUS:        OP_PLUS,
+	MINUS:       OP_MINUS,
+	ASTERISK:    OP_ASTERISK,
+	SLASH:       OP_SLASH,
+	EQ:          OP_EQ,
+	NOT_EQ:      OP_NOT_EQ,
+	GT:          OP_GT,
+	LT:          OP_LT,
+	MOD:         OP_MOD,
+	COMMA:       OP_COMMA,
+	SEMICOLON:   OP_SEMICOLON,
+	COLON:       OP_COLON,
+	LPAREN:      OP_LPAREN,
+	RPAREN:      OP_RPAREN,
+	LBRACE:      OP_LBRACE,
+	RBRACE:      OP_RBRACE,
+	LBRACKET:    OP_LBRACKET,
+	RBRACKET:    OP_RBRACKET,
+	COMMENT:     OP_COMMENT,
+	BANG:        OP_BANG,
+	AMPERSAND:   OP_AMPERSAND,
+	PIPE:        OP_PIPE,
+	CARET:       OP_CARET,
+	TILDE:       OP_TILDE,
+	SHIFT_LEFT:  OP_SHL,
+	SHIFT_RIGHT: OP_SHR,
+	AND:         OP_AND,
+	OR:          OP_OR,
+	GT_EQ:       OP_GT_EQ,
+	LT_EQ:       OP_LT_EQ,
+	WHILE:       OP_WHILE,
 }
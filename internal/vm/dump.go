@@ -0,0 +1,34 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+)
+
+// dumpRowSize is the number of bytes shown on each line of a memory dump
+const dumpRowSize = 16
+
+// DumpMemory writes the rows of data memory that hold at least one non-zero byte as
+// hexadecimal lines such as "0x0000: 07 03 00 ...". Rows made only of zeros are omitted.
+func (m *Machine) DumpMemory(w io.Writer) error {
+	for row := 0; row < MemorySize; row += dumpRowSize {
+		data := m.Memory[row : row+dumpRowSize]
+		if isZero(data) {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "0x%04X: % X\n", row, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isZero reports whether every byte of data is zero
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,78 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"cyone/internal/kernel"
+)
+
+// Function simulates a kernel function. It receives the evaluated parameters of the call and
+// returns the result of the function, zero for functions that return nothing.
+type Function func(m *Machine, args []byte) (byte, error)
+
+// Registry is a Kernel that dispatches calls to Go functions registered by name
+type Registry struct {
+	functions map[byte]Function
+	table     *kernel.Table
+}
+
+// NewRegistry returns a registry without any function for the kernel described by table, or
+// for the reference kernel when table is nil
+func NewRegistry(table *kernel.Table) *Registry {
+	if table == nil {
+		table = kernel.Default()
+	}
+	return &Registry{functions: make(map[byte]Function), table: table}
+}
+
+// NewLoggingRegistry returns a registry where every kernel function is a stub that writes the
+// call and its arguments to w, for example "DRAW_CIRCLE(0x20, 0x20, 0x09)". Stubs return zero,
+// which is logged for the functions that return a value, as in "READ_ADC(0x02) -> 0x00".
+func NewLoggingRegistry(w io.Writer, table *kernel.Table) *Registry {
+	r := NewRegistry(table)
+	for _, f := range r.table.Functions() {
+		r.Register(f.Name, logCall(w, f))
+	}
+	return r
+}
+
+// logCall returns a function that writes a call to f and its arguments to w
+func logCall(w io.Writer, f *kernel.Function) Function {
+	return func(m *Machine, args []byte) (byte, error) {
+		values := make([]string, len(args))
+		for i, arg := range args {
+			values[i] = fmt.Sprintf("0x%02X", arg)
+		}
+		call := fmt.Sprintf("%s(%s)", f.Name, strings.Join(values, ", "))
+		if f.Returns != "" {
+			call += " -> 0x00"
+		}
+		_, err := fmt.Fprintln(w, call)
+		return 0, err
+	}
+}
+
+// Register sets the function called for the kernel function with the given name, replacing
+// any function registered before. The name must be a known kernel function.
+func (r *Registry) Register(name string, function Function) error {
+	f, exists := r.table.Lookup(name)
+	if !exists {
+		return fmt.Errorf("function '%s' is not provided by the %s kernel", name, r.table.Name)
+	}
+	r.functions[f.Opcode] = function
+	return nil
+}
+
+// Call runs the function registered for the opcode and returns its result
+func (r *Registry) Call(m *Machine, function byte, args []byte) (byte, error) {
+	f, exists := r.functions[function]
+	if !exists {
+		if f, known := r.table.Opcode(function); known {
+			return 0, fmt.Errorf("no handler registered for %s", f.Name)
+		}
+		return 0, fmt.Errorf("unknown kernel function")
+	}
+	return f(m, args)
+}
@@ -0,0 +1,153 @@
+package vm
+
+import (
+	"cyone/internal/bytecode"
+	pkg_token "cyone/internal/token"
+)
+
+// skipStatements decodes the statements that start at pc without executing them and returns
+// the address of the closing brace that ends them
+func (m *Machine) skipStatements(pc uint16) (uint16, error) {
+	for m.Code[pc] != pkg_token.OP_RBRACE {
+		next, err := m.skipStatement(pc)
+		if err != nil {
+			return 0, err
+		}
+		pc = next
+	}
+	return pc, nil
+}
+
+// skipStatement decodes the statement at pc without executing it and returns the address
+// that follows it
+func (m *Machine) skipStatement(pc uint16) (uint16, error) {
+	switch opcode := m.Code[pc]; opcode {
+	case pkg_token.OP_IDENTIFIER:
+		assign := pc + 3
+		if m.Code[assign] == pkg_token.OP_COLON {
+			assign++
+		}
+		if err := m.expect(assign, pkg_token.OP_ASSIGN); err != nil {
+			return 0, err
+		}
+		next, err := m.skipExpression(assign + 1)
+		if err != nil {
+			return 0, err
+		}
+		return next + 1, m.expect(next, pkg_token.OP_EOF)
+	case pkg_token.OP_IF:
+		next, err := m.skipCondition(pc + 1)
+		if err != nil {
+			return 0, err
+		}
+		thenStart, err := m.branch(next, bytecode.BranchThen)
+		if err != nil {
+			return 0, err
+		}
+		thenEnd, err := m.skipStatements(thenStart)
+		if err != nil {
+			return 0, err
+		}
+		elseStart, err := m.branch(thenEnd+1, bytecode.BranchElse)
+		if err != nil {
+			return 0, err
+		}
+		elseEnd, err := m.skipStatements(elseStart)
+		if err != nil {
+			return 0, err
+		}
+		return elseEnd + 1, nil
+	case pkg_token.OP_WHILE:
+		next, err := m.skipCondition(pc + 1)
+		if err != nil {
+			return 0, err
+		}
+		if err := m.expect(next, pkg_token.OP_LBRACE); err != nil {
+			return 0, err
+		}
+		bodyEnd, err := m.skipStatements(next + 1)
+		if err != nil {
+			return 0, err
+		}
+		return bodyEnd + 1, nil
+	case pkg_token.OP_GOTO:
+		return pc + 4, m.expect(pc+3, pkg_token.OP_EOF)
+	case pkg_token.OP_CALL:
+		next, err := m.skipArguments(pc + 2)
+		if err != nil {
+			return 0, err
+		}
+		return next + 2, m.expect(next+1, pkg_token.OP_EOF)
+	default:
+		return 0, m.faultf(pc, "unknown statement opcode 0x%02X", opcode)
+	}
+}
+
+// skipCondition decodes a parenthesized condition and returns the address that follows the
+// closing parenthesis
+func (m *Machine) skipCondition(pc uint16) (uint16, error) {
+	if err := m.expect(pc, pkg_token.OP_LPAREN); err != nil {
+		return 0, err
+	}
+	next, err := m.skipExpression(pc + 1)
+	if err != nil {
+		return 0, err
+	}
+	return next + 1, m.expect(next, pkg_token.OP_RPAREN)
+}
+
+// skipExpression decodes the expression operand at pc without evaluating it and returns the
+// address that follows it
+func (m *Machine) skipExpression(pc uint16) (uint16, error) {
+	if err := m.nest(pc); err != nil {
+		return 0, err
+	}
+	defer m.unnest()
+	switch tag := m.Code[pc]; tag {
+	case bytecode.OperandMemory, bytecode.OperandWordMemory:
+		return pc + 3, nil
+	case bytecode.OperandConstant:
+		return pc + 2, nil
+	case bytecode.OperandWord:
+		return pc + 3, nil
+	case bytecode.OperandBinary:
+		next, err := m.skipExpression(pc + 2)
+		if err != nil {
+			return 0, err
+		}
+		return m.skipExpression(next)
+	case bytecode.OperandUnary:
+		return m.skipExpression(pc + 2)
+	case bytecode.OperandLogical:
+		next, err := m.skipExpression(pc + 2)
+		if err != nil {
+			return 0, err
+		}
+		return next + 1 + uint16(m.Code[next]), nil
+	case bytecode.OperandCall:
+		next, err := m.skipArguments(pc + 2)
+		if err != nil {
+			return 0, err
+		}
+		return next + 1, nil
+	default:
+		return 0, m.faultf(pc, "unknown operand tag 0x%02X", tag)
+	}
+}
+
+// skipArguments decodes the parenthesized parameters of a call without evaluating them and
+// returns the address of the closing parenthesis
+func (m *Machine) skipArguments(pc uint16) (uint16, error) {
+	if err := m.expect(pc, pkg_token.OP_LPAREN); err != nil {
+		return 0, err
+	}
+	next := pc + 1
+	for m.Code[next] != pkg_token.OP_RPAREN {
+		var err error
+		next, err = m.skipExpression(next)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return next, nil
+}
@@ -0,0 +1,477 @@
+// Package vm implements a reference interpreter of the Cyone Kernel. It executes the bytecode
+// produced by the compiler exactly as specified in docs/bytecode.md, and is meant to be read
+// alongside that document by anyone implementing the kernel on a microcontroller.
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"cyone/internal/bytecode"
+	pkg_token "cyone/internal/token"
+)
+
+// MemorySize is the size in bytes of both the code and the data address spaces
+const MemorySize = 0x10000
+
+// MaxExpressionDepth is the deepest nesting of expression operands that the machine decodes.
+// Deeper operands raise a fault instead of exhausting the stack of the interpreter.
+const MaxExpressionDepth = 256
+
+// ErrHalted is returned when stepping a machine that is no longer running
+var ErrHalted = errors.New("machine is halted")
+
+// ErrStepLimit is returned when a machine reaches its maximum number of steps, which usually
+// means that the program loops forever
+var ErrStepLimit = errors.New("step limit reached")
+
+// Kernel executes the functions invoked by 'call' statements and call operands
+type Kernel interface {
+	// Call runs the function with the given opcode and returns its result, which is discarded
+	// by call statements. The arguments are the evaluated parameters of the call, in source
+	// order. Address and word parameters take two bytes, high byte first.
+	Call(m *Machine, function byte, args []byte) (byte, error)
+}
+
+// Fault is an error raised while decoding or executing code
+type Fault struct {
+	Address uint16
+	Message string
+}
+
+func (f *Fault) Error() string {
+	return fmt.Sprintf("fault at 0x%04X: %s", f.Address, f.Message)
+}
+
+// frame is a construct whose body is being executed. When the closing brace of the body is
+// reached, execution resumes at the resume address, or halts if the frame is a block.
+type frame struct {
+	resume uint16
+	block  bool
+}
+
+// Machine is a Cyone Kernel with separate code and data memories of 64 KiB each
+type Machine struct {
+	Code   [MemorySize]byte
+	Memory [MemorySize]byte
+	Kernel Kernel
+
+	// MaxSteps limits the number of statements executed since Start, zero means no limit
+	MaxSteps int
+
+	// OnBlockEnter, when set, is called every time execution enters a block, including the
+	// first block entered by Start. An error stops the machine.
+	OnBlockEnter func(m *Machine) error
+
+	PC     uint16 // Address of the next statement
+	Block  uint16 // Address of the block being executed
+	Steps  int    // Number of statements executed since Start
+	Halted bool
+
+	frames []frame
+	depth  int // Nesting level of the expression operand being decoded
+}
+
+// NewMachine returns a machine with zeroed memories that calls kernel functions on kernel
+func NewMachine(kernel Kernel) *Machine {
+	return &Machine{Kernel: kernel, Halted: true}
+}
+
+// LoadBytecode copies the records produced by the compiler into code memory
+func (m *Machine) LoadBytecode(bytecodes []bytecode.Bytecode) {
+	for _, bc := range bytecodes {
+		m.Code[bc.Address] = bc.Opcode
+		m.loadCode(bc.Address+1, bc.Operands)
+	}
+}
+
+// LoadRecords copies the data records of an Intel HEX file into code memory
+func (m *Machine) LoadRecords(records []bytecode.Record) {
+	for _, record := range records {
+		m.loadCode(record.Address, record.Data)
+	}
+}
+
+// LoadIntelHex reads an Intel HEX image into code memory
+func (m *Machine) LoadIntelHex(r io.Reader) error {
+	records, err := bytecode.ParseIntelHex(r)
+	if err != nil {
+		return err
+	}
+	m.LoadRecords(records)
+	return nil
+}
+
+// loadCode copies data to code memory at address, wrapping around at the end of memory
+func (m *Machine) loadCode(address uint16, data []byte) {
+	for i, b := range data {
+		m.Code[address+uint16(i)] = b
+	}
+}
+
+// Start reads the start vector at code address 0x0000 and enters the block it points to
+func (m *Machine) Start() error {
+	m.Halted = true
+	m.Steps = 0
+	if err := m.expect(0x0000, pkg_token.OP_START); err != nil {
+		return err
+	}
+	if err := m.expect(0x0003, pkg_token.OP_EOF); err != nil {
+		return err
+	}
+	if err := m.enterBlock(m.word(0x0001)); err != nil {
+		return err
+	}
+	return m.closeFrames()
+}
+
+// Run executes statements until the machine halts or faults
+func (m *Machine) Run() error {
+	for !m.Halted {
+		if err := m.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Step executes the next statement. The machine halts when execution reaches the end of a
+// block without jumping to another one. Between steps, the program counter of a running machine
+// is always the address of a statement.
+func (m *Machine) Step() error {
+	if m.Halted {
+		return ErrHalted
+	}
+	if m.MaxSteps > 0 && m.Steps >= m.MaxSteps {
+		return fmt.Errorf("%w: %d statements executed, next one at 0x%04X in block 0x%04X", ErrStepLimit, m.Steps, m.PC, m.Block)
+	}
+	m.Steps++
+	err := m.execute()
+	if err == nil {
+		err = m.closeFrames()
+	}
+	if err != nil {
+		m.Halted = true
+		return err
+	}
+	return nil
+}
+
+// enterBlock clears every open construct and continues execution at the first statement of
+// the block at address
+func (m *Machine) enterBlock(address uint16) error {
+	if err := m.expect(address, pkg_token.OP_BLOCK); err != nil {
+		return err
+	}
+	length := m.word(address + 1)
+	if err := m.expect(address+3, pkg_token.OP_LBRACE); err != nil {
+		return err
+	}
+	if length < 2 {
+		return m.faultf(address+1, "block length 0x%04X is shorter than its braces", length)
+	}
+	if err := m.expect(address+2+length, pkg_token.OP_RBRACE); err != nil {
+		return err
+	}
+	m.frames = append(m.frames[:0], frame{block: true})
+	m.Block = address
+	m.PC = address + 4
+	m.Halted = false
+	if m.OnBlockEnter != nil {
+		return m.OnBlockEnter(m)
+	}
+	return nil
+}
+
+// closeFrames leaves every construct whose closing brace is at the program counter
+func (m *Machine) closeFrames() error {
+	for m.Code[m.PC] == pkg_token.OP_RBRACE {
+		if len(m.frames) == 0 {
+			return m.faultf(m.PC, "closing brace without an open construct")
+		}
+		closed := m.frames[len(m.frames)-1]
+		m.frames = m.frames[:len(m.frames)-1]
+		if closed.block {
+			m.Halted = true
+			return nil
+		}
+		m.PC = closed.resume
+	}
+	return nil
+}
+
+// execute decodes and executes the statement at the program counter
+func (m *Machine) execute() error {
+	pc := m.PC
+	switch opcode := m.Code[pc]; opcode {
+	case pkg_token.OP_IDENTIFIER:
+		address := m.word(pc + 1)
+		// A colon before the assignment marks the store of a word
+		word := m.Code[pc+3] == pkg_token.OP_COLON
+		assign := pc + 3
+		if word {
+			assign++
+		}
+		if err := m.expect(assign, pkg_token.OP_ASSIGN); err != nil {
+			return err
+		}
+		value, next, err := m.evaluate(assign + 1)
+		if err != nil {
+			return err
+		}
+		if err := m.expect(next, pkg_token.OP_EOF); err != nil {
+			return err
+		}
+		if word {
+			m.Memory[address] = byte(value.Bits >> 8)
+			m.Memory[address+1] = byte(value.Bits)
+		} else {
+			m.Memory[address] = byte(value.Bits)
+		}
+		m.PC = next + 1
+	case pkg_token.OP_IF:
+		condition, next, err := m.condition(pc + 1)
+		if err != nil {
+			return err
+		}
+		thenStart, err := m.branch(next, bytecode.BranchThen)
+		if err != nil {
+			return err
+		}
+		thenEnd, err := m.skipStatements(thenStart)
+		if err != nil {
+			return err
+		}
+		elseStart, err := m.branch(thenEnd+1, bytecode.BranchElse)
+		if err != nil {
+			return err
+		}
+		elseEnd, err := m.skipStatements(elseStart)
+		if err != nil {
+			return err
+		}
+		m.frames = append(m.frames, frame{resume: elseEnd + 1})
+		if condition.Bits != 0 {
+			m.PC = thenStart
+		} else {
+			m.PC = elseStart
+		}
+	case pkg_token.OP_WHILE:
+		condition, next, err := m.condition(pc + 1)
+		if err != nil {
+			return err
+		}
+		if err := m.expect(next, pkg_token.OP_LBRACE); err != nil {
+			return err
+		}
+		bodyEnd, err := m.skipStatements(next + 1)
+		if err != nil {
+			return err
+		}
+		if condition.Bits != 0 {
+			// The body resumes at the while opcode, so the condition is evaluated again
+			m.frames = append(m.frames, frame{resume: pc})
+			m.PC = next + 1
+		} else {
+			m.PC = bodyEnd + 1
+		}
+	case pkg_token.OP_GOTO:
+		if err := m.expect(pc+3, pkg_token.OP_EOF); err != nil {
+			return err
+		}
+		return m.enterBlock(m.word(pc + 1))
+	case pkg_token.OP_CALL:
+		function := m.Code[pc+1]
+		args, next, err := m.arguments(pc + 2)
+		if err != nil {
+			return err
+		}
+		if err := m.expect(next+1, pkg_token.OP_EOF); err != nil {
+			return err
+		}
+		m.PC = next + 2
+		if _, err := m.call(pc, function, args); err != nil {
+			return err
+		}
+	default:
+		return m.faultf(pc, "unknown statement opcode 0x%02X", opcode)
+	}
+	return nil
+}
+
+// condition evaluates a parenthesized condition and returns its value and the address that
+// follows the closing parenthesis
+func (m *Machine) condition(pc uint16) (bytecode.Value, uint16, error) {
+	if err := m.expect(pc, pkg_token.OP_LPAREN); err != nil {
+		return bytecode.Value{}, 0, err
+	}
+	value, next, err := m.evaluate(pc + 1)
+	if err != nil {
+		return bytecode.Value{}, 0, err
+	}
+	if err := m.expect(next, pkg_token.OP_RPAREN); err != nil {
+		return bytecode.Value{}, 0, err
+	}
+	return value, next + 1, nil
+}
+
+// arguments evaluates the parenthesized parameters of a call and returns them and the address
+// of the closing parenthesis
+func (m *Machine) arguments(pc uint16) ([]byte, uint16, error) {
+	if err := m.expect(pc, pkg_token.OP_LPAREN); err != nil {
+		return nil, 0, err
+	}
+	var args []byte
+	next := pc + 1
+	for m.Code[next] != pkg_token.OP_RPAREN {
+		var value bytecode.Value
+		var err error
+		value, next, err = m.evaluate(next)
+		if err != nil {
+			return nil, 0, err
+		}
+		// Words, the addresses and words of the signature, are passed high byte first
+		if value.Word {
+			args = append(args, byte(value.Bits>>8))
+		}
+		args = append(args, byte(value.Bits))
+	}
+	return args, next, nil
+}
+
+// call runs a kernel function for the call statement or operand at pc
+func (m *Machine) call(pc uint16, function byte, args []byte) (byte, error) {
+	if m.Kernel == nil {
+		return 0, m.faultf(pc, "call to function 0x%02X without a kernel", function)
+	}
+	value, err := m.Kernel.Call(m, function, args)
+	if err != nil {
+		return 0, &Fault{Address: pc, Message: fmt.Sprintf("function 0x%02X: %v", function, err)}
+	}
+	return value, nil
+}
+
+// branch checks the marker and opening brace of an if branch and returns the address of its
+// first statement
+func (m *Machine) branch(pc uint16, marker byte) (uint16, error) {
+	if m.Code[pc] != marker {
+		return 0, m.faultf(pc, "expected branch marker 0x%02X, found 0x%02X", marker, m.Code[pc])
+	}
+	if err := m.expect(pc+1, pkg_token.OP_LBRACE); err != nil {
+		return 0, err
+	}
+	return pc + 2, nil
+}
+
+// evaluate evaluates the expression operand at pc and returns its value and the address that
+// follows it
+func (m *Machine) evaluate(pc uint16) (bytecode.Value, uint16, error) {
+	if err := m.nest(pc); err != nil {
+		return bytecode.Value{}, 0, err
+	}
+	defer m.unnest()
+	switch tag := m.Code[pc]; tag {
+	case bytecode.OperandMemory:
+		return bytecode.Value{Bits: uint16(m.Memory[m.word(pc+1)])}, pc + 3, nil
+	case bytecode.OperandWordMemory:
+		address := m.word(pc + 1)
+		return bytecode.Value{Bits: uint16(m.Memory[address])<<8 | uint16(m.Memory[address+1]), Word: true}, pc + 3, nil
+	case bytecode.OperandConstant:
+		return bytecode.Value{Bits: uint16(m.Code[pc+1])}, pc + 2, nil
+	case bytecode.OperandWord:
+		return bytecode.Value{Bits: m.word(pc + 1), Word: true}, pc + 3, nil
+	case bytecode.OperandBinary:
+		left, next, err := m.evaluate(pc + 2)
+		if err != nil {
+			return bytecode.Value{}, 0, err
+		}
+		right, next, err := m.evaluate(next)
+		if err != nil {
+			return bytecode.Value{}, 0, err
+		}
+		value, err := bytecode.EvaluateBinaryValue(m.Code[pc+1], left, right)
+		if err != nil {
+			return bytecode.Value{}, 0, m.faultf(pc+1, "%v", err)
+		}
+		return value, next, nil
+	case bytecode.OperandUnary:
+		operand, next, err := m.evaluate(pc + 2)
+		if err != nil {
+			return bytecode.Value{}, 0, err
+		}
+		value, err := bytecode.EvaluateUnaryValue(m.Code[pc+1], operand)
+		if err != nil {
+			return bytecode.Value{}, 0, m.faultf(pc+1, "%v", err)
+		}
+		return value, next, nil
+	case bytecode.OperandLogical:
+		operator := m.Code[pc+1]
+		if operator != pkg_token.OP_AND && operator != pkg_token.OP_OR {
+			return bytecode.Value{}, 0, m.faultf(pc+1, "unknown logical operator opcode 0x%02X", operator)
+		}
+		left, next, err := m.evaluate(pc + 2)
+		if err != nil {
+			return bytecode.Value{}, 0, err
+		}
+		end := next + 1 + uint16(m.Code[next])
+		if operator == pkg_token.OP_AND && left.Bits == 0 {
+			return bytecode.Value{Bits: 0x00}, end, nil
+		}
+		if operator == pkg_token.OP_OR && left.Bits != 0 {
+			return bytecode.Value{Bits: 0x01}, end, nil
+		}
+		right, rightEnd, err := m.evaluate(next + 1)
+		if err != nil {
+			return bytecode.Value{}, 0, err
+		}
+		if rightEnd != end {
+			return bytecode.Value{}, 0, m.faultf(next, "right operand is %d bytes long, the length prefix is %d", rightEnd-next-1, m.Code[next])
+		}
+		value, err := bytecode.EvaluateBinaryValue(operator, left, right)
+		return value, end, err
+	case bytecode.OperandCall:
+		function := m.Code[pc+1]
+		args, next, err := m.arguments(pc + 2)
+		if err != nil {
+			return bytecode.Value{}, 0, err
+		}
+		value, err := m.call(pc, function, args)
+		return bytecode.Value{Bits: uint16(value)}, next + 1, err
+	default:
+		return bytecode.Value{}, 0, m.faultf(pc, "unknown operand tag 0x%02X", tag)
+	}
+}
+
+// nest enters the expression operand at pc and returns a fault if it is nested deeper than
+// MaxExpressionDepth
+func (m *Machine) nest(pc uint16) error {
+	if m.depth >= MaxExpressionDepth {
+		return m.faultf(pc, "expression nested deeper than %d operands", MaxExpressionDepth)
+	}
+	m.depth++
+	return nil
+}
+
+// unnest leaves the expression operand entered by nest
+func (m *Machine) unnest() {
+	m.depth--
+}
+
+// word reads a big-endian 16-bit value from code memory
+func (m *Machine) word(address uint16) uint16 {
+	return uint16(m.Code[address])<<8 | uint16(m.Code[address+1])
+}
+
+// expect returns a fault unless the code byte at address is opcode
+func (m *Machine) expect(address uint16, opcode byte) error {
+	if m.Code[address] != opcode {
+		return m.faultf(address, "expected opcode 0x%02X, found 0x%02X", opcode, m.Code[address])
+	}
+	return nil
+}
+
+// faultf returns a fault at address with a formatted message
+func (m *Machine) faultf(address uint16, format string, args ...interface{}) *Fault {
+	return &Fault{Address: address, Message: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,408 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"cyone/internal/bytecode"
+	"cyone/internal/compiler"
+	"cyone/internal/kernel"
+	pkg_token "cyone/internal/token"
+)
+
+// testKernel extends the reference kernel with a function that returns a value and one that
+// takes address and word parameters
+func testKernel(t *testing.T) *kernel.Table {
+	t.Helper()
+	functions := append(kernel.Default().Functions(),
+		&kernel.Function{Name: "READ_ADC", Opcode: 0x10, Parameters: []kernel.Parameter{{Name: "channel", Kind: kernel.Byte}}, Returns: kernel.Byte},
+		&kernel.Function{Name: "FILL", Opcode: 0x20, Parameters: []kernel.Parameter{{Name: "buffer", Kind: kernel.Address}, {Name: "count", Kind: kernel.Word}, {Name: "value", Kind: kernel.Byte}}},
+	)
+	table, err := kernel.New("test", functions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+// calls records the kernel calls made by a program. READ_ADC returns its channel plus 0x10.
+type calls struct {
+	log []string
+}
+
+func (c *calls) Call(m *Machine, function byte, args []byte) (byte, error) {
+	c.log = append(c.log, fmt.Sprintf("%02X:% X", function, args))
+	if function == 0x10 {
+		return args[0] + 0x10, nil
+	}
+	return 0, nil
+}
+
+// run compiles and executes a program with the given data memory, and returns the machine
+func run(t *testing.T, source string, memory map[uint16]byte) (*Machine, *calls) {
+	t.Helper()
+	options := bytecode.DefaultOptions()
+	options.Kernel = testKernel(t)
+	_, bytecodes, diagnostics := compiler.Compile("test.cyo", source, options)
+	if diagnostics.HasErrors() {
+		t.Fatalf("source does not compile: %v", diagnostics)
+	}
+	kernel := &calls{}
+	m := NewMachine(kernel)
+	m.MaxSteps = 1000
+	m.LoadBytecode(bytecodes)
+	for address, value := range memory {
+		m.Memory[address] = value
+	}
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return m, kernel
+}
+
+func TestByteOperators(t *testing.T) {
+	tests := []struct {
+		expression string
+		a, b       byte
+		expected   byte
+	}{
+		{"a + b", 0xF0, 0x20, 0x10},
+		{"a - b", 0x01, 0x02, 0xFF},
+		{"a * b", 0x10, 0x11, 0x10},
+		{"a / b", 0x10, 0x03, 0x05},
+		{"a / b", 0x10, 0x00, 0xFF},
+		{"a % b", 0x10, 0x03, 0x01},
+		{"a % b", 0x10, 0x00, 0x10},
+		{"a & b", 0x0F, 0x3C, 0x0C},
+		{"a | b", 0x0F, 0x30, 0x3F},
+		{"a ^ b", 0x0F, 0x3C, 0x33},
+		{"a << b", 0x81, 0x01, 0x02},
+		{"a << b", 0x01, 0x08, 0x00},
+		{"a >> b", 0x81, 0x01, 0x40},
+		{"a >> b", 0x80, 0x09, 0x00},
+		{"a == b", 0x05, 0x05, 0x01},
+		{"a == b", 0x05, 0x06, 0x00},
+		{"a != b", 0x05, 0x06, 0x01},
+		{"a > b", 0xFF, 0x01, 0x01},
+		{"a < b", 0xFF, 0x01, 0x00},
+		{"a >= b", 0x05, 0x05, 0x01},
+		{"a <= b", 0x06, 0x05, 0x00},
+		{"a && b", 0x05, 0x06, 0x01},
+		{"a && b", 0x00, 0x06, 0x00},
+		{"a || b", 0x00, 0x06, 0x01},
+		{"a || b", 0x00, 0x00, 0x00},
+		{"-a", 0x01, 0x00, 0xFF},
+		{"!a", 0x00, 0x00, 0x01},
+		{"!a", 0x07, 0x00, 0x00},
+		{"~a", 0x0F, 0x00, 0xF0},
+		{"(a + b) * 0x02", 0x01, 0x02, 0x06},
+		{"mem[0x0021] + 0x01", 0x00, 0x41, 0x42},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s/%02X,%02X", test.expression, test.a, test.b), func(t *testing.T) {
+			m, _ := run(t, fmt.Sprintf(`
+loc r at 0x0000;
+loc a at 0x0020;
+loc b at 0x0021;
+start at 0x0100;
+block 0x0100 { r = %s; }
+`, test.expression), map[uint16]byte{0x0020: test.a, 0x0021: test.b})
+			if got := m.Memory[0x0000]; got != test.expected {
+				t.Errorf("got 0x%02X, expected 0x%02X", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestWordOperators(t *testing.T) {
+	tests := []struct {
+		expression string
+		p, q       uint16
+		expected   uint16
+	}{
+		{"p + q", 0x12FF, 0x0001, 0x1300},
+		{"p + q", 0xFFFF, 0x0002, 0x0001},
+		{"p - q", 0x0000, 0x0001, 0xFFFF},
+		{"p * q", 0x0100, 0x0101, 0x0100},
+		{"p / q", 0x1000, 0x0010, 0x0100},
+		{"p / q", 0x1000, 0x0000, 0xFFFF},
+		{"p % q", 0x1001, 0x0010, 0x0001},
+		{"p & q", 0xFF0F, 0x0FF0, 0x0F00},
+		{"p | q", 0xF000, 0x000F, 0xF00F},
+		{"p ^ q", 0xFFFF, 0x0F0F, 0xF0F0},
+		{"p << q", 0x0081, 0x0008, 0x8100},
+		{"p >> q", 0x8100, 0x0009, 0x0040},
+		{"p << q", 0x0001, 0x0010, 0x0000},
+		{"-p", 0x0001, 0x0000, 0xFFFF},
+		{"~p", 0x00FF, 0x0000, 0xFF00},
+		{"p + b", 0x00FF, 0x0000, 0x010F},
+		{"p + 0x01", 0x00FF, 0x0000, 0x0100},
+		{"b + 0x0100", 0x0000, 0x0000, 0x0110},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s/%04X,%04X", test.expression, test.p, test.q), func(t *testing.T) {
+			m, _ := run(t, fmt.Sprintf(`
+loc r at 0x0000 : word;
+loc p at 0x0010 : word;
+loc q at 0x0012 : word;
+loc b at 0x0021;
+start at 0x0100;
+block 0x0100 { r = %s; }
+`, test.expression), map[uint16]byte{
+				0x0010: byte(test.p >> 8), 0x0011: byte(test.p),
+				0x0012: byte(test.q >> 8), 0x0013: byte(test.q),
+				0x0021: 0x10,
+			})
+			if got := uint16(m.Memory[0x0000])<<8 | uint16(m.Memory[0x0001]); got != test.expected {
+				t.Errorf("got 0x%04X, expected 0x%04X", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestWordComparisons(t *testing.T) {
+	tests := []struct {
+		expression string
+		expected   byte
+	}{
+		{"p == 0x1234", 0x01},
+		{"p != 0x1234", 0x00},
+		{"p > 0x1233", 0x01},
+		{"p < 0x00FF", 0x00},
+		{"p >= 0x1234", 0x01},
+		{"p <= 0x1233", 0x00},
+		{"p && 0x0100", 0x01},
+		{"!p", 0x00},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			m, _ := run(t, fmt.Sprintf(`
+loc r at 0x0000;
+loc p at 0x0010 : word;
+start at 0x0100;
+block 0x0100 { r = %s; }
+`, test.expression), map[uint16]byte{0x0010: 0x12, 0x0011: 0x34})
+			if got := m.Memory[0x0000]; got != test.expected {
+				t.Errorf("got 0x%02X, expected 0x%02X", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestShortCircuit(t *testing.T) {
+	tests := []struct {
+		expression string
+		a          byte
+		called     bool
+	}{
+		{"a && call READ_ADC (0x01)", 0x00, false},
+		{"a && call READ_ADC (0x01)", 0x01, true},
+		{"a || call READ_ADC (0x01)", 0x01, false},
+		{"a || call READ_ADC (0x01)", 0x00, true},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s/%02X", test.expression, test.a), func(t *testing.T) {
+			_, kernel := run(t, fmt.Sprintf(`
+loc r at 0x0000;
+loc a at 0x0020;
+start at 0x0100;
+block 0x0100 { r = %s; }
+`, test.expression), map[uint16]byte{0x0020: test.a})
+			if called := len(kernel.log) > 0; called != test.called {
+				t.Errorf("READ_ADC called: %v, expected %v", called, test.called)
+			}
+		})
+	}
+}
+
+func TestStatements(t *testing.T) {
+	m, kernel := run(t, `
+loc i at 0x0000;
+loc s at 0x0001;
+loc f at 0x0002;
+loc x at 0x0003;
+loc ptr at 0x0010 : word;
+start at main;
+block main {
+    while (i < 0x05) {
+        s = s + i;
+        i = i + 0x01;
+    }
+    if (s == 0x09) { f = 0x01; } else if (s == 0x0A) { f = 0x02; } else { f = 0x03; }
+    if (i == 0x00) { f = 0x04; }
+    mem[0x0004] = 0xAB;
+    ptr = 0x1234;
+    x = call READ_ADC (0x02);
+    call FILL (ptr, ptr, x);
+    call SET_COLOR (x);
+    goto done;
+}
+block done { i = 0x00; }
+block 0x0400 { i = 0xEE; }
+`, nil)
+	memory := []struct {
+		address uint16
+		value   byte
+	}{
+		{0x0000, 0x00}, // done ran after the loop, block 0x0400 did not
+		{0x0001, 0x0A},
+		{0x0002, 0x02},
+		{0x0003, 0x12},
+		{0x0004, 0xAB},
+		{0x0010, 0x12},
+		{0x0011, 0x34},
+	}
+	for _, test := range memory {
+		if got := m.Memory[test.address]; got != test.value {
+			t.Errorf("mem[0x%04X] is 0x%02X, expected 0x%02X", test.address, got, test.value)
+		}
+	}
+	expected := "[10:02 20:00 10 12 34 12 02:12]"
+	if got := fmt.Sprint(kernel.log); got != expected {
+		t.Errorf("kernel calls %s, expected %s", got, expected)
+	}
+	if !m.Halted {
+		t.Errorf("machine is not halted")
+	}
+	if err := m.Step(); !errors.Is(err, ErrHalted) {
+		t.Errorf("step after halt returned %v, expected %v", err, ErrHalted)
+	}
+}
+
+func TestBlockEnter(t *testing.T) {
+	options := bytecode.DefaultOptions()
+	_, bytecodes, diagnostics := compiler.Compile("test.cyo", `
+loc i at 0x0000;
+start at loop;
+block loop {
+    i = i + 0x01;
+    if (i < 0x03) { goto loop; }
+}
+`, options)
+	if diagnostics.HasErrors() {
+		t.Fatal(diagnostics)
+	}
+	m := NewMachine(NewRegistry(nil))
+	m.LoadBytecode(bytecodes)
+	entered := 0
+	m.OnBlockEnter = func(m *Machine) error {
+		entered++
+		return nil
+	}
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if entered != 3 || m.Memory[0x0000] != 0x03 {
+		t.Errorf("entered the block %d times with i = 0x%02X, expected 3 times with i = 0x03", entered, m.Memory[0x0000])
+	}
+}
+
+func TestStepLimit(t *testing.T) {
+	_, bytecodes, _ := compiler.Compile("test.cyo", "start at 0x0100;\nblock 0x0100 { goto 0x0100; }\n", bytecode.DefaultOptions())
+	m := NewMachine(NewRegistry(nil))
+	m.MaxSteps = 10
+	m.LoadBytecode(bytecodes)
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(); !errors.Is(err, ErrStepLimit) {
+		t.Fatalf("got %v, expected %v", err, ErrStepLimit)
+	}
+	if m.Steps != 10 {
+		t.Errorf("executed %d statements, expected 10", m.Steps)
+	}
+}
+
+// nested returns a program whose only block, at 0x0100, holds an expression of depth nested
+// negations of 0x01 between the bytes of prefix and suffix
+func nested(prefix []byte, depth int, suffix []byte) []bytecode.Record {
+	body := []byte{pkg_token.OP_LBRACE}
+	body = append(body, prefix...)
+	for i := 0; i < depth; i++ {
+		body = append(body, bytecode.OperandUnary, pkg_token.OP_MINUS)
+	}
+	body = append(body, bytecode.OperandConstant, 0x01)
+	body = append(body, suffix...)
+	body = append(body, pkg_token.OP_RBRACE)
+	return []bytecode.Record{
+		{Address: 0x0000, Data: []byte{pkg_token.OP_START, 0x01, 0x00, pkg_token.OP_EOF}},
+		{Address: 0x0100, Data: append([]byte{pkg_token.OP_BLOCK, byte(len(body) >> 8), byte(len(body))}, body...)},
+	}
+}
+
+func TestExpressionDepth(t *testing.T) {
+	m := NewMachine(NewRegistry(nil))
+	m.LoadRecords(nested([]byte{pkg_token.OP_IDENTIFIER, 0x00, 0x00, pkg_token.OP_ASSIGN}, MaxExpressionDepth-1, []byte{pkg_token.OP_EOF}))
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Memory[0x0000] != 0xFF {
+		t.Errorf("got 0x%02X, expected 0xFF", m.Memory[0x0000])
+	}
+}
+
+func TestFaults(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []bytecode.Record
+		address uint16
+	}{
+		{"missing start vector", nil, 0x0000},
+		{"start at a missing block", []bytecode.Record{
+			{Address: 0x0000, Data: []byte{pkg_token.OP_START, 0x01, 0x00, pkg_token.OP_EOF}},
+		}, 0x0100},
+		{"unknown statement", []bytecode.Record{
+			{Address: 0x0000, Data: []byte{pkg_token.OP_START, 0x01, 0x00, pkg_token.OP_EOF}},
+			{Address: 0x0100, Data: []byte{pkg_token.OP_BLOCK, 0x00, 0x03, pkg_token.OP_LBRACE, 0xFF, pkg_token.OP_RBRACE}},
+		}, 0x0104},
+		{"unknown operand tag", []bytecode.Record{
+			{Address: 0x0000, Data: []byte{pkg_token.OP_START, 0x01, 0x00, pkg_token.OP_EOF}},
+			{Address: 0x0100, Data: []byte{pkg_token.OP_BLOCK, 0x00, 0x09, pkg_token.OP_LBRACE,
+				pkg_token.OP_IDENTIFIER, 0x00, 0x00, pkg_token.OP_ASSIGN, 0xEE, 0x00, pkg_token.OP_EOF, pkg_token.OP_RBRACE}},
+		}, 0x0108},
+		{"unknown kernel function", []bytecode.Record{
+			{Address: 0x0000, Data: []byte{pkg_token.OP_START, 0x01, 0x00, pkg_token.OP_EOF}},
+			{Address: 0x0100, Data: []byte{pkg_token.OP_BLOCK, 0x00, 0x07, pkg_token.OP_LBRACE,
+				pkg_token.OP_CALL, 0x7F, pkg_token.OP_LPAREN, pkg_token.OP_RPAREN, pkg_token.OP_EOF, pkg_token.OP_RBRACE}},
+		}, 0x0104},
+		{"expression nested too deep", nested(
+			[]byte{pkg_token.OP_IDENTIFIER, 0x00, 0x00, pkg_token.OP_ASSIGN}, 300, []byte{pkg_token.OP_EOF},
+		), 0x0308},
+		{"skipped expression nested too deep", nested(
+			[]byte{pkg_token.OP_IF, pkg_token.OP_LPAREN, bytecode.OperandConstant, 0x01, pkg_token.OP_RPAREN,
+				bytecode.BranchThen, pkg_token.OP_LBRACE, pkg_token.OP_IDENTIFIER, 0x00, 0x00, pkg_token.OP_ASSIGN},
+			300,
+			[]byte{pkg_token.OP_EOF, pkg_token.OP_RBRACE, bytecode.BranchElse, pkg_token.OP_LBRACE, pkg_token.OP_RBRACE},
+		), 0x030F},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := NewMachine(NewRegistry(nil))
+			m.LoadRecords(test.records)
+			err := m.Start()
+			if err == nil {
+				err = m.Run()
+			}
+			var fault *Fault
+			if !errors.As(err, &fault) {
+				t.Fatalf("got %v, expected a fault", err)
+			}
+			if fault.Address != test.address {
+				t.Errorf("fault at 0x%04X, expected 0x%04X: %v", fault.Address, test.address, fault)
+			}
+			if !m.Halted {
+				t.Errorf("machine is not halted after a fault")
+			}
+		})
+	}
+}